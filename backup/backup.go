@@ -0,0 +1,355 @@
+// backup package lists and restores the project backups
+// in '_non_persistent/backups' and snapshots the files replaced by an update.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcu-art/ergomcutool/changes"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/utils"
+)
+
+// Backup kinds
+const (
+	KindUpdate   = "update"
+	KindMakefile = "makefile"
+	KindIoc      = "ioc"
+	KindMigrate  = "migrate"
+)
+
+// ManifestFileName describes the files of the update snapshot.
+const ManifestFileName = "snapshot.json"
+
+// File is a backed up file.
+type File struct {
+	// Path is the restored file from the project root
+	Path string `json:"path"`
+	// Backup is the backup file from the project root,
+	// empty if the file didn't exist and is removed on restore
+	Backup string `json:"backup,omitempty"`
+}
+
+// Link is a backed up symlink.
+type Link struct {
+	// Path is the symlink from the project root
+	Path string `json:"path"`
+	// Target is empty if the symlink didn't exist and is removed on restore
+	Target string `json:"target,omitempty"`
+}
+
+// Entry is a backup of one or more files.
+type Entry struct {
+	// ID is the path from the backups directory,
+	// e.g. 'makefile/3-makefile-2024_01_31'
+	ID    string    `json:"id"`
+	Kind  string    `json:"kind"`
+	Time  time.Time `json:"time"`
+	Files []File    `json:"files"`
+	Links []Link    `json:"links,omitempty"`
+	// number orders the backups of the same kind
+	number uint64
+}
+
+// manifest is the contents of the snapshot manifest,
+// the backup paths are relative to the snapshot directory.
+type manifest struct {
+	Time  time.Time `json:"time"`
+	Files []File    `json:"files"`
+	Links []Link    `json:"links,omitempty"`
+}
+
+// Dir returns the backups directory of the project.
+func Dir(root string) string {
+	return filepath.Join(root, config.BackupsDir)
+}
+
+// parseName splits the '<N>-<baseName>-<date>' backup name
+// created by utils.BackupFile.
+func parseName(name string) (number uint64, baseName string, ok bool) {
+	prefix, rest, found := strings.Cut(name, "-")
+	i := strings.LastIndex(rest, "-")
+	if !found || i <= 0 {
+		return 0, "", false
+	}
+	number, err := strconv.ParseUint(prefix, 10, 32)
+	if err != nil {
+		return 0, "", false
+	}
+	return number, rest[:i], true
+}
+
+// List returns the backups of the project, grouped by kind,
+// the newest first.
+func List(root string) ([]Entry, error) {
+	r := make([]Entry, 0, 20)
+	dir := Dir(root)
+	add := func(kind, id, target string, number uint64) error {
+		info, err := os.Stat(filepath.Join(dir, id))
+		if err != nil {
+			return err
+		}
+		r = append(r, Entry{ID: filepath.ToSlash(id), Kind: kind, Time: info.ModTime(),
+			Files:  []File{{Path: target, Backup: filepath.Join(config.BackupsDir, id)}},
+			number: number})
+		return nil
+	}
+
+	// Numbered backups of the Makefile, the .ioc file and the migrated files
+	migrated := map[string]string{
+		filepath.Base(config.ProjectFilePath):     config.ProjectFilePath,
+		filepath.Base(config.LocalConfigFilePath): config.LocalConfigFilePath,
+	}
+	for _, kind := range []string{KindMakefile, KindIoc, KindMigrate} {
+		files, err := utils.GetFileList(filepath.Join(dir, kind))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, f := range files {
+			number, target, ok := parseName(f)
+			if !ok {
+				continue
+			}
+			if kind == KindMakefile {
+				target = "Makefile"
+			}
+			if kind == KindMigrate {
+				if target, ok = migrated[target]; !ok {
+					continue
+				}
+			}
+			if err = add(kind, filepath.Join(kind, f), target, number); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The .ioc file backed up by 'create'
+	files, err := utils.GetFileList(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, ".ioc.backup") {
+			if err = add(KindIoc, f, strings.TrimSuffix(f, ".backup"), 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Update snapshots
+	snapshots, err := os.ReadDir(filepath.Join(dir, KindUpdate))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		number, _, ok := parseName(s.Name())
+		if !s.IsDir() || !ok {
+			continue
+		}
+		id := filepath.Join(KindUpdate, s.Name())
+		m, err := readManifest(filepath.Join(dir, id))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", filepath.ToSlash(id), err)
+		}
+		for i, f := range m.Files {
+			if f.Backup != "" {
+				m.Files[i].Backup = filepath.Join(config.BackupsDir, id, f.Backup)
+			}
+		}
+		r = append(r, Entry{ID: filepath.ToSlash(id), Kind: KindUpdate, Time: m.Time,
+			Files: m.Files, Links: m.Links, number: number})
+	}
+
+	kindOrder := map[string]int{KindUpdate: 0, KindMakefile: 1, KindIoc: 2, KindMigrate: 3}
+	sort.SliceStable(r, func(i, j int) bool {
+		if r[i].Kind != r[j].Kind {
+			return kindOrder[r[i].Kind] < kindOrder[r[j].Kind]
+		}
+		if r[i].number != r[j].number {
+			return r[i].number > r[j].number
+		}
+		return r[i].ID < r[j].ID
+	})
+	return r, nil
+}
+
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ManifestFileName, err)
+	}
+	return m, nil
+}
+
+// Find returns the backup with the ID. The number prefix of the backup name
+// is enough if it is unique, e.g. 'makefile/3' or 'update/12'.
+func Find(entries []Entry, id string) (*Entry, error) {
+	id = strings.TrimSuffix(filepath.ToSlash(id), "/")
+	var found *Entry
+	for i := range entries {
+		e := &entries[i]
+		if e.ID == id {
+			return e, nil
+		}
+		if strings.HasPrefix(e.ID, id+"-") {
+			if found != nil {
+				return nil, fmt.Errorf("backup ID %q is ambiguous", id)
+			}
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("backup %q not found", id)
+	}
+	return found, nil
+}
+
+// Restore restores the backed up files and symlinks through the change set,
+// so that a dry-run set records the differences from the current files.
+// All backup files are read before any file is changed.
+func Restore(root string, e *Entry, c *changes.Set) error {
+	contents := make([][]byte, len(e.Files))
+	for i, f := range e.Files {
+		if f.Backup == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(root, f.Backup))
+		if err != nil {
+			return fmt.Errorf("failed to read the backup of %q: %w", f.Path, err)
+		}
+		contents[i] = data
+	}
+	for i, f := range e.Files {
+		path := filepath.Join(root, f.Path)
+		var err error
+		if f.Backup == "" {
+			_, err = c.Remove(path)
+		} else {
+			_, err = c.WriteFile(path, contents[i])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %q: %w", f.Path, err)
+		}
+	}
+	for _, l := range e.Links {
+		path := filepath.Join(root, l.Path)
+		var err error
+		if l.Target == "" {
+			_, err = c.RemoveLink(path)
+		} else {
+			_, err = c.Symlink(path, l.Target)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore symlink %q: %w", l.Path, err)
+		}
+	}
+	return nil
+}
+
+// relPath returns the path from the project root.
+func relPath(root, path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(absRoot, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// Snapshot saves the original contents of the files and symlinks
+// changed by the set into a new update snapshot, so that the changes can be
+// undone with Restore. Relative paths in the set are relative to the root.
+// Only the 'limit' newest snapshots are kept.
+// Returns the ID of the snapshot, empty if nothing was changed.
+func Snapshot(root string, c *changes.Set, limit int) (string, error) {
+	if c.DryRun || !c.Changed() {
+		return "", nil
+	}
+	updateDir := filepath.Join(Dir(root), KindUpdate)
+	if err := os.MkdirAll(updateDir, fs.FileMode(config.DefaultDirPermissions)); err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(updateDir)
+	if err != nil {
+		return "", err
+	}
+	numbers := make([]uint64, 0, len(entries))
+	names := make(map[uint64]string, len(entries))
+	for _, e := range entries {
+		if number, _, ok := parseName(e.Name()); ok && e.IsDir() {
+			numbers = append(numbers, number)
+			names[number] = e.Name()
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	var next uint64 = 1
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1] + 1
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s-%s", next, KindUpdate, now.Format("2006_01_02_150405"))
+	tmpDir, err := os.MkdirTemp(updateDir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	m := manifest{Time: now}
+	for i, f := range c.Files {
+		file := File{Path: relPath(root, f.Path)}
+		if f.Old != nil {
+			file.Backup = filepath.Join("files", strconv.Itoa(i))
+			_, err = utils.WriteFileIfChanged(filepath.Join(tmpDir, file.Backup), f.Old,
+				config.DefaultDirPermissions, config.DefaultFilePermissions)
+			if err != nil {
+				return "", err
+			}
+		}
+		m.Files = append(m.Files, file)
+	}
+	for _, l := range c.Links {
+		m.Links = append(m.Links, Link{Path: relPath(root, l.Path), Target: l.OldTarget})
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filepath.Join(tmpDir, ManifestFileName), data, fs.FileMode(config.DefaultFilePermissions))
+	if err != nil {
+		return "", err
+	}
+	if err = os.Chmod(tmpDir, fs.FileMode(config.DefaultDirPermissions)); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmpDir, filepath.Join(updateDir, name)); err != nil {
+		return "", err
+	}
+
+	// Remove the oldest snapshots
+	numbers = append(numbers, next)
+	for i := 0; i < len(numbers)-limit; i++ {
+		if err = os.RemoveAll(filepath.Join(updateDir, names[numbers[i]])); err != nil {
+			return "", err
+		}
+	}
+	return filepath.ToSlash(filepath.Join(KindUpdate, name)), nil
+}
@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/changes"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func newSet(dryRun bool) *changes.Set {
+	return changes.New(dryRun, config.DefaultDirPermissions, config.DefaultFilePermissions)
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func TestList(t *testing.T) {
+	root := t.TempDir()
+	makefile := filepath.Join(root, "Makefile")
+	for _, contents := range []string{"1\n", "2\n"} {
+		require.Nil(t, os.WriteFile(makefile, []byte(contents), 0o644))
+		_, err := utils.BackupFile(makefile, filepath.Join(Dir(root), KindMakefile), "makefile",
+			config.MakefileBackupsLimit, false, config.DefaultDirPermissions)
+		require.Nil(t, err)
+	}
+	ioc := filepath.Join(root, "board.ioc")
+	require.Nil(t, os.WriteFile(ioc, []byte("ioc\n"), 0o644))
+	_, err := utils.BackupFile(ioc, filepath.Join(Dir(root), KindIoc), "board.ioc",
+		config.IocBackupsLimit, false, config.DefaultDirPermissions)
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(Dir(root), "board.ioc.backup"), []byte("created\n"), 0o644))
+	project := filepath.Join(root, config.ProjectFilePath)
+	require.Nil(t, os.MkdirAll(filepath.Dir(project), 0o755))
+	require.Nil(t, os.WriteFile(project, []byte("project\n"), 0o644))
+	_, err = utils.BackupFile(project, filepath.Join(root, config.MigrateBackupsDir), filepath.Base(project),
+		config.MigrationBackupsLimit, false, config.DefaultDirPermissions)
+	require.Nil(t, err)
+
+	entries, err := List(root)
+	require.Nil(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t, KindMakefile, entries[0].Kind)
+	require.Equal(t, "Makefile", entries[0].Files[0].Path)
+	require.Equal(t, "2\n", readFile(t, filepath.Join(root, entries[0].Files[0].Backup)))
+	require.Equal(t, "1\n", readFile(t, filepath.Join(root, entries[1].Files[0].Backup)))
+	require.Equal(t, KindIoc, entries[2].Kind)
+	require.Equal(t, "board.ioc", entries[2].Files[0].Path)
+	require.Equal(t, "board.ioc.backup", entries[3].ID)
+	require.Equal(t, "board.ioc", entries[3].Files[0].Path)
+	require.Equal(t, KindMigrate, entries[4].Kind)
+	require.Equal(t, config.ProjectFilePath, entries[4].Files[0].Path)
+
+	e, err := Find(entries, "makefile/1")
+	require.Nil(t, err)
+	require.Equal(t, &entries[1], e)
+	_, err = Find(entries, entries[2].ID)
+	require.Nil(t, err)
+	_, err = Find(entries, "makefile/3")
+	require.NotNil(t, err)
+
+	// Restore the oldest Makefile backup
+	c := newSet(true)
+	require.Nil(t, Restore(root, e, c))
+	require.Contains(t, c.Diff(), "-2\n+1\n")
+	require.Nil(t, Restore(root, e, newSet(false)))
+	require.Equal(t, "1\n", readFile(t, makefile))
+
+	empty, err := List(t.TempDir())
+	require.Nil(t, err)
+	require.Empty(t, empty)
+}
+
+func TestSnapshot(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "existing.txt")
+	require.Nil(t, os.WriteFile(existing, []byte("old\n"), 0o644))
+	link := filepath.Join(root, "_external", "lib")
+
+	// Nothing is saved in dry-run mode or if nothing has changed
+	id, err := Snapshot(root, newSet(true), 5)
+	require.Nil(t, err)
+	require.Equal(t, "", id)
+
+	c := newSet(false)
+	_, err = c.WriteFile(existing, []byte("new\n"))
+	require.Nil(t, err)
+	_, err = c.WriteFile(filepath.Join(root, "sub", "created.txt"), []byte("created\n"))
+	require.Nil(t, err)
+	_, err = c.Symlink(link, "../lib")
+	require.Nil(t, err)
+	id, err = Snapshot(root, c, 5)
+	require.Nil(t, err)
+
+	entries, err := List(root)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	e := &entries[0]
+	require.Equal(t, id, e.ID)
+	require.Equal(t, KindUpdate, e.Kind)
+	require.Equal(t, []Link{{Path: filepath.Join("_external", "lib")}}, e.Links)
+	require.Equal(t, "existing.txt", e.Files[0].Path)
+	require.Equal(t, "old\n", readFile(t, filepath.Join(root, e.Files[0].Backup)))
+	require.Equal(t, "", e.Files[1].Backup)
+
+	// Restoring the snapshot undoes the changes
+	c = newSet(false)
+	require.Nil(t, Restore(root, e, c))
+	require.Equal(t, "old\n", readFile(t, existing))
+	require.False(t, utils.FileExists(filepath.Join(root, "sub", "created.txt")))
+	_, err = os.Lstat(link)
+	require.True(t, os.IsNotExist(err))
+
+	// The restore is a snapshot itself, the oldest snapshots are removed
+	for i := 0; i < 3; i++ {
+		_, err = Snapshot(root, c, 2)
+		require.Nil(t, err)
+	}
+	entries, err = List(root)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	e, err = Find(entries, "update/4")
+	require.Nil(t, err)
+	require.Equal(t, "new\n", readFile(t, filepath.Join(root, e.Files[0].Backup)))
+	_, err = Find(entries, "update/2")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,63 @@
+package cgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMacroName(t *testing.T) {
+	require.Equal(t, "LEDUSER1", MacroName("LedUser1"))
+	require.Equal(t, "SYS_JTMS_SWDIO", MacroName("SYS_JTMS-SWDIO"))
+	require.Equal(t, "_1WIRE", MacroName("1wire"))
+}
+
+func TestPinMapHeader(t *testing.T) {
+	m, err := iocfile.FromFile("../iocfile/test_data/sample1.ioc")
+	require.Nil(t, err)
+	parsed, err := m.Parse()
+	require.Nil(t, err)
+
+	parsed.Pins = append(parsed.Pins, iocfile.Pin{
+		Name: "PA5", Signal: "SPI1_SCK", AlternateFunction: "GPIO_AF5_SPI1"})
+	parsed.Pins = append(parsed.Pins, iocfile.Pin{Name: "PA0", Signal: "S_TIM2_CH1"})
+	parsed.Peripherals = append(parsed.Peripherals, iocfile.Peripheral{
+		Name: "SPI1", Pins: []string{"PA5"}}, iocfile.Peripheral{Name: "TIM2", Pins: []string{"PA0"}})
+
+	h := PinMapHeader(parsed, "sample1.ioc")
+	require.Contains(t, h, "#define BOARD_BTNUSER1_PORT GPIOC\n#define BOARD_BTNUSER1_PIN GPIO_PIN_13\n")
+	require.Contains(t, h, "#define BOARD_USART1_RX_INSTANCE USART1\n")
+	require.Contains(t, h, "#define BOARD_S_TIM2_CH1_INSTANCE TIM2\n")
+	require.Contains(t, h, "#define BOARD_SPI1_SCK_AF GPIO_AF5_SPI1\n#define BOARD_SPI1_SCK_AF_NUM 5\n")
+	// CubeMX doesn't store the default alternate function
+	require.Contains(t, h, "#define BOARD_USART1_RX_PIN_NUM 10\n#define BOARD_USART1_RX_INSTANCE USART1\n")
+	require.NotContains(t, h, "BOARD_USART1_RX_AF")
+	require.Contains(t, h, "#define BOARD_SYS_JTMS_SWDIO_PIN GPIO_PIN_13\n")
+	require.NotContains(t, h, "BOARD_SYS_JTMS_SWDIO_INSTANCE")
+	require.NotContains(t, h, "VP_SYS")
+
+	// Pins are sorted by port and number
+	require.Less(t, strings.Index(h, "/* PA2:"), strings.Index(h, "/* PA5:"))
+	require.Less(t, strings.Index(h, "/* PA5:"), strings.Index(h, "/* PA10:"))
+	require.Less(t, strings.Index(h, "/* PA15:"), strings.Index(h, "/* PB3:"))
+
+	// Output is stable
+	require.Equal(t, h, PinMapHeader(parsed, "sample1.ioc"))
+}
+
+func TestClockConfigHeader(t *testing.T) {
+	m, err := iocfile.FromFile("../iocfile/test_data/sample1.ioc")
+	require.Nil(t, err)
+	clocks, err := m.Clocks()
+	require.Nil(t, err)
+
+	h := ClockConfigHeader(clocks, "sample1.ioc")
+	require.Contains(t, h, "#define BOARD_SYSCLK_HZ 8000000UL\n")
+	require.Contains(t, h, "#define BOARD_APB1_TIM_HZ 8000000UL\n")
+	require.Contains(t, h, "#define BOARD_HSE_HZ 8000000UL\n")
+	require.Contains(t, h, "#define BOARD_VCOOUTPUT_HZ 64000000UL\n")
+	require.Contains(t, h, "#define BOARD_USART1_CLK_HZ 8000000UL\n")
+	require.True(t, strings.HasSuffix(h, "#endif /* CLOCK_CONFIG_H */\n"))
+}
@@ -0,0 +1,39 @@
+package cgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/iocfile"
+)
+
+// ClockConfigHeader generates the 'clock_config.h' contents:
+// bus, oscillator and peripheral kernel clock frequencies in Hz.
+func ClockConfigHeader(clocks *iocfile.ClockTree, iocFileName string) string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "/* This file was generated by ergomcutool from %q, do not edit it manually.\n", iocFileName)
+	b.WriteString(" * Run 'ergomcutool gen clocks' to update it. */\n\n")
+	b.WriteString("#ifndef CLOCK_CONFIG_H\n#define CLOCK_CONFIG_H\n")
+
+	sections := []struct {
+		comment string
+		suffix  string
+		clocks  []iocfile.Clock
+	}{
+		{"Bus clocks", "_HZ", clocks.Buses},
+		{"Oscillators", "_HZ", clocks.Oscillators},
+		{"PLL outputs", "_HZ", clocks.Pll},
+		{"Peripheral kernel clocks", "_CLK_HZ", clocks.Peripherals},
+	}
+	for _, s := range sections {
+		if len(s.clocks) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n/* %s */\n", s.comment)
+		for _, c := range s.clocks {
+			fmt.Fprintf(&b, "#define %s%s%s %dUL\n", MacroPrefix, MacroName(c.Name), s.suffix, c.Frequency)
+		}
+	}
+	b.WriteString("\n#endif /* CLOCK_CONFIG_H */\n")
+	return b.String()
+}
@@ -0,0 +1,161 @@
+// cgen package generates C headers from the .ioc file data.
+package cgen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/iocfile"
+)
+
+// MacroPrefix is prepended to all generated macro names
+// to avoid conflicts with the CubeMX-generated 'main.h' defines.
+var MacroPrefix = "BOARD_"
+
+// nonInstancePeripherals are peripherals that don't have
+// a HAL instance handle that could be used in the code.
+var nonInstancePeripherals = map[string]bool{
+	"SYS":  true,
+	"RCC":  true,
+	"NVIC": true,
+	"DMA":  true,
+	"GPIO": true,
+}
+
+// MacroName converts an arbitrary string into a valid C macro name, e.g.
+// 'LedUser1' becomes 'LEDUSER1', 'SYS_JTMS-SWDIO' becomes 'SYS_JTMS_SWDIO'.
+func MacroName(s string) string {
+	b := strings.Builder{}
+	for i, r := range strings.ToUpper(s) {
+		switch {
+		case r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// pinMacroBase returns the macro name of the pin without the prefix.
+// The user label has precedence, then the peripheral signal name,
+// then the pin name itself.
+func pinMacroBase(p *iocfile.Pin, port string, number int) string {
+	if p.Label != "" {
+		return MacroName(p.Label)
+	}
+	if p.Signal != "" && !strings.HasPrefix(p.Signal, "GPIO_") {
+		return MacroName(p.Signal)
+	}
+	return fmt.Sprintf("P%s%d", port, number)
+}
+
+// peripheralInstance returns the peripheral the signal belongs to,
+// e.g. 'USART1' for 'USART1_RX'. The longest match wins.
+func peripheralInstance(signal string, peripherals []iocfile.Peripheral) string {
+	r := ""
+	for _, p := range peripherals {
+		if strings.HasPrefix(signal, p.Name+"_") && len(p.Name) > len(r) {
+			r = p.Name
+		}
+	}
+	if nonInstancePeripherals[r] {
+		return ""
+	}
+	return r
+}
+
+// alternateFunctionNumber extracts the number from the alternate
+// function name, e.g. 5 from 'GPIO_AF5_SPI1'.
+func alternateFunctionNumber(af string) (int, bool) {
+	s, found := strings.CutPrefix(af, "GPIO_AF")
+	if !found {
+		return 0, false
+	}
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end == -1 {
+		end = len(s)
+	}
+	n, err := strconv.Atoi(s[:end])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+type pinMapEntry struct {
+	pin    iocfile.Pin
+	port   string
+	number int
+}
+
+// PinMapHeader generates the 'board_pins.h' contents.
+// Only physical GPIO pins are included. The pins are sorted by port and
+// number so that the output doesn't change unless the pin configuration does.
+// The _AF and _AF_NUM macros are only generated for the pins that have
+// GPIO_AF in the .ioc file. CubeMX omits it for the default alternate
+// function of the signal, and the number can't be derived from the signal
+// because it depends on the pin and the device: the default one is only
+// found in the generated HAL MSP code ('GPIO_InitStruct.Alternate').
+func PinMapHeader(parsed *iocfile.ParsedIoc, iocFileName string) string {
+	entries := make([]pinMapEntry, 0, len(parsed.Pins))
+	for _, p := range parsed.Pins {
+		if p.Virtual {
+			continue
+		}
+		port, number, ok := p.GpioPort()
+		if !ok {
+			continue
+		}
+		entries = append(entries, pinMapEntry{pin: p, port: port, number: number})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].port != entries[j].port {
+			return entries[i].port < entries[j].port
+		}
+		return entries[i].number < entries[j].number
+	})
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "/* This file was generated by ergomcutool from %q, do not edit it manually.\n", iocFileName)
+	b.WriteString(" * Run 'ergomcutool gen pinmap' to update it. */\n\n")
+	b.WriteString("#ifndef BOARD_PINS_H\n#define BOARD_PINS_H\n")
+
+	usedNames := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		p := e.pin
+		name := pinMacroBase(&p, e.port, e.number)
+		if usedNames[name] {
+			name = fmt.Sprintf("%s_P%s%d", name, e.port, e.number)
+		}
+		usedNames[name] = true
+		name = MacroPrefix + name
+
+		comment := p.Name
+		if p.Signal != "" {
+			comment += ": " + p.Signal
+		}
+		fmt.Fprintf(&b, "\n/* %s */\n", comment)
+		fmt.Fprintf(&b, "#define %s_PORT GPIO%s\n", name, e.port)
+		fmt.Fprintf(&b, "#define %s_PIN GPIO_PIN_%d\n", name, e.number)
+		fmt.Fprintf(&b, "#define %s_PIN_NUM %d\n", name, e.number)
+		if p.AlternateFunction != "" {
+			fmt.Fprintf(&b, "#define %s_AF %s\n", name, p.AlternateFunction)
+			if n, ok := alternateFunctionNumber(p.AlternateFunction); ok {
+				fmt.Fprintf(&b, "#define %s_AF_NUM %d\n", name, n)
+			}
+		}
+		if instance := peripheralInstance(p.SignalName(), parsed.Peripherals); instance != "" {
+			fmt.Fprintf(&b, "#define %s_INSTANCE %s\n", name, instance)
+		}
+	}
+	b.WriteString("\n#endif /* BOARD_PINS_H */\n")
+	return b.String()
+}
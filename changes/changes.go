@@ -0,0 +1,276 @@
+// changes package applies or records the changes of the generated project files,
+// so that they can be previewed as a unified diff before they are made.
+package changes
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// File is a change of the file contents.
+type File struct {
+	Path string
+	// Old is nil if the file doesn't exist
+	Old []byte
+	New []byte
+	// Removed is true if the file is removed
+	Removed bool
+}
+
+// Link is a change of the symlink target.
+type Link struct {
+	Path string
+	// OldTarget is empty if the symlink doesn't exist
+	OldTarget string
+	// Target is empty if the symlink is removed
+	Target string
+}
+
+// Set is a set of changes. The changes are made immediately
+// unless DryRun is true, and are recorded in both cases.
+// Unchanged files and symlinks are not recorded.
+type Set struct {
+	DryRun   bool
+	DirPerm  uint32
+	FilePerm uint32
+	Files    []File
+	Links    []Link
+}
+
+// New creates a set that uses the specified permissions
+// for the new directories and files.
+func New(dryRun bool, dirPerm, filePerm uint32) *Set {
+	return &Set{DryRun: dryRun, DirPerm: dirPerm, FilePerm: filePerm}
+}
+
+func (s *Set) findFile(path string) *File {
+	for i := range s.Files {
+		if s.Files[i].Path == path {
+			return &s.Files[i]
+		}
+	}
+	return nil
+}
+
+// current returns the current contents of the file, taking the recorded
+// changes into account, and its record if any. The contents are nil
+// if the file doesn't exist.
+func (s *Set) current(path string) ([]byte, *File, error) {
+	if recorded := s.findFile(path); recorded != nil {
+		if recorded.Removed {
+			return nil, recorded, nil
+		}
+		return recorded.New, recorded, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return data, nil, nil
+}
+
+// record records the change, the file keeps its original contents
+// in the record if it is changed twice.
+func (s *Set) record(path string, recorded *File, old, data []byte, removed bool) {
+	if recorded != nil {
+		recorded.New, recorded.Removed = data, removed
+		return
+	}
+	s.Files = append(s.Files, File{Path: path, Old: old, New: data, Removed: removed})
+}
+
+// WriteFile writes the file if it doesn't exist or its contents differ.
+// Missing parent directories are created. The file is replaced atomically,
+// so that it is never left partially written.
+// Returns true if the file was written, which is never the case in dry-run mode.
+func (s *Set) WriteFile(path string, data []byte) (bool, error) {
+	old, recorded, err := s.current(path)
+	if err != nil {
+		return false, err
+	}
+	if old != nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	s.record(path, recorded, old, data, false)
+	if s.DryRun {
+		return false, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(path), fs.FileMode(s.DirPerm)); err != nil {
+		return false, err
+	}
+	if err = writeFileAtomic(path, data, fs.FileMode(s.FilePerm)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory
+// and renames it to path. An existing file keeps its permissions.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Remove removes the file if it exists.
+// Returns true if the file was removed, which is never the case in dry-run mode.
+func (s *Set) Remove(path string) (bool, error) {
+	old, recorded, err := s.current(path)
+	if err != nil || old == nil {
+		return false, err
+	}
+	s.record(path, recorded, old, nil, true)
+	if s.DryRun {
+		return false, nil
+	}
+	if err = os.Remove(path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Symlink creates the symlink to the target or replaces it
+// if it points elsewhere. Missing parent directories are created.
+// Returns true if the symlink was created, which is never the case in dry-run mode.
+func (s *Set) Symlink(path, target string) (bool, error) {
+	oldTarget, err := os.Readlink(path)
+	if err == nil && oldTarget == target {
+		return false, nil
+	}
+	if err != nil {
+		if _, statErr := os.Lstat(path); statErr == nil {
+			return false, fmt.Errorf("%q exists and is not a symlink", path)
+		}
+	}
+	s.Links = append(s.Links, Link{Path: path, OldTarget: oldTarget, Target: target})
+	if s.DryRun {
+		return false, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(path), fs.FileMode(s.DirPerm)); err != nil {
+		return false, err
+	}
+	if oldTarget != "" {
+		if err = os.Remove(path); err != nil {
+			return false, fmt.Errorf("failed to unlink: %w", err)
+		}
+	}
+	if err = os.Symlink(target, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveLink removes the symlink if it exists.
+// Returns true if the symlink was removed, which is never the case in dry-run mode.
+func (s *Set) RemoveLink(path string) (bool, error) {
+	oldTarget, err := os.Readlink(path)
+	if err != nil {
+		if _, statErr := os.Lstat(path); statErr == nil {
+			return false, fmt.Errorf("%q exists and is not a symlink", path)
+		}
+		return false, nil
+	}
+	s.Links = append(s.Links, Link{Path: path, OldTarget: oldTarget})
+	if s.DryRun {
+		return false, nil
+	}
+	if err = os.Remove(path); err != nil {
+		return false, fmt.Errorf("failed to unlink: %w", err)
+	}
+	return true, nil
+}
+
+// Changed returns true if any change was recorded.
+func (s *Set) Changed() bool {
+	return len(s.Files) > 0 || len(s.Links) > 0
+}
+
+// displayPath returns the path relative to the working directory if possible.
+func displayPath(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+// splitLines splits the text into lines, the empty text has no lines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return difflib.SplitLines(s)
+}
+
+func unifiedDiff(a, b, fromFile, toFile string) string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	return diff
+}
+
+// Diff returns the unified diff of the recorded changes,
+// the files first and the symlinks after them.
+// New and removed files are compared with '/dev/null'.
+func (s *Set) Diff() string {
+	b := strings.Builder{}
+	for _, f := range s.Files {
+		path := displayPath(f.Path)
+		from := "a/" + path
+		to := "b/" + path
+		if f.Old == nil {
+			from = "/dev/null"
+		}
+		if f.Removed {
+			to = "/dev/null"
+		}
+		b.WriteString(unifiedDiff(string(f.Old), string(f.New), from, to))
+	}
+	for _, l := range s.Links {
+		path := displayPath(l.Path)
+		from := "a/" + path + " (symlink)"
+		to := "b/" + path + " (symlink)"
+		old, target := l.OldTarget+"\n", l.Target+"\n"
+		if l.OldTarget == "" {
+			from, old = "/dev/null", ""
+		}
+		if l.Target == "" {
+			to, target = "/dev/null", ""
+		}
+		b.WriteString(unifiedDiff(old, target, from, to))
+	}
+	return b.String()
+}
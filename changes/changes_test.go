@@ -0,0 +1,137 @@
+package changes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	require.Nil(os.WriteFile(existing, []byte("a\nb\nc\n"), 0o644))
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	require.Nil(os.WriteFile(unchanged, []byte("same\n"), 0o644))
+
+	c := New(true, 0o755, 0o644)
+	written, err := c.WriteFile(existing, []byte("a\nB\nc\n"))
+	require.Nil(err)
+	require.False(written)
+	_, err = c.WriteFile(filepath.Join(dir, "sub", "new.txt"), []byte("new\n"))
+	require.Nil(err)
+	_, err = c.WriteFile(unchanged, []byte("same\n"))
+	require.Nil(err)
+	_, err = c.Symlink(filepath.Join(dir, "link"), "../target")
+	require.Nil(err)
+
+	require.True(c.Changed())
+	require.Len(c.Files, 2)
+	require.Len(c.Links, 1)
+
+	// Nothing is written in dry-run mode
+	data, err := os.ReadFile(existing)
+	require.Nil(err)
+	require.Equal("a\nb\nc\n", string(data))
+	require.False(fileExists(filepath.Join(dir, "sub")))
+	require.False(fileExists(filepath.Join(dir, "link")))
+
+	diff := c.Diff()
+	require.Contains(diff, "-b\n+B\n")
+	require.Contains(diff, "--- /dev/null\n")
+	require.Contains(diff, "+new\n")
+	require.Contains(diff, "+../target\n")
+	require.NotContains(diff, "unchanged.txt")
+}
+
+func TestWrite(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "file.txt")
+	link := filepath.Join(dir, "ext", "link")
+
+	c := New(false, 0o755, 0o644)
+	written, err := c.WriteFile(path, []byte("1\n"))
+	require.Nil(err)
+	require.True(written)
+	// The second write keeps the original contents in the record
+	written, err = c.WriteFile(path, []byte("2\n"))
+	require.Nil(err)
+	require.True(written)
+	require.Len(c.Files, 1)
+	require.Nil(c.Files[0].Old)
+	require.Equal("2\n", string(c.Files[0].New))
+
+	written, err = c.Symlink(link, "../sub")
+	require.Nil(err)
+	require.True(written)
+	written, err = c.Symlink(link, "../sub")
+	require.Nil(err)
+	require.False(written)
+	written, err = c.Symlink(link, "../other")
+	require.Nil(err)
+	require.True(written)
+	target, err := os.Readlink(link)
+	require.Nil(err)
+	require.Equal("../other", target)
+
+	c = New(false, 0o755, 0o644)
+	written, err = c.WriteFile(path, []byte("2\n"))
+	require.Nil(err)
+	require.False(written)
+	require.False(c.Changed())
+	require.Equal("", c.Diff())
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestRemove(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	require.Nil(os.WriteFile(path, []byte("old\n"), 0o600))
+	link := filepath.Join(dir, "link")
+	require.Nil(os.Symlink("file.txt", link))
+
+	c := New(true, 0o755, 0o644)
+	removed, err := c.Remove(path)
+	require.Nil(err)
+	require.False(removed)
+	_, err = c.RemoveLink(link)
+	require.Nil(err)
+	removed, err = c.Remove(filepath.Join(dir, "missing.txt"))
+	require.Nil(err)
+	require.False(removed)
+	require.Len(c.Files, 1)
+	require.True(c.Files[0].Removed)
+	diff := c.Diff()
+	require.Contains(diff, "+++ /dev/null\n")
+	require.Contains(diff, "-old\n")
+	require.Contains(diff, "-file.txt\n")
+	require.True(fileExists(path))
+
+	// The file replaced atomically keeps its permissions
+	c = New(false, 0o755, 0o644)
+	_, err = c.WriteFile(path, []byte("new\n"))
+	require.Nil(err)
+	info, err := os.Stat(path)
+	require.Nil(err)
+	require.Equal(os.FileMode(0o600), info.Mode().Perm())
+	removed, err = c.Remove(path)
+	require.Nil(err)
+	require.True(removed)
+	require.False(fileExists(path))
+	require.Equal("old\n", string(c.Files[0].Old))
+	removed, err = c.RemoveLink(link)
+	require.Nil(err)
+	require.True(removed)
+	require.False(fileExists(link))
+	entries, err := os.ReadDir(dir)
+	require.Nil(err)
+	require.Empty(entries)
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/backup"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Inspect and restore the project backups",
+	Long: `Inspect and restore the project backups in '_non_persistent/backups':
+the Makefile backups made before STM32CubeMX regenerates the code,
+the .ioc file backups and the snapshots of the files replaced by update-project.
+A backup is selected by its ID or by the number prefix of its name,
+e.g. 'makefile/3' or 'update/12'.`,
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the backups, the newest first",
+	Run:   backupList,
+}
+
+var backupShowCmd = &cobra.Command{
+	Use:   "show ID [PATH]",
+	Short: "Print the backed up file",
+	Long: `Print the backed up file. For update snapshots, the saved files are listed
+unless PATH selects one of them.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  backupShow,
+}
+
+var backupDiffCmd = &cobra.Command{
+	Use:   "diff ID",
+	Short: "Print the unified diff between the current files and the backup",
+	Args:  cobra.ExactArgs(1),
+	Run:   backupDiff,
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore ID",
+	Short: "Restore the files from the backup",
+	Long: `Restore the files from the backup. Each file is replaced atomically,
+the files that didn't exist when an update snapshot was taken are removed.
+The replaced files are saved into a new snapshot, so that the restore can be undone.`,
+	Args: cobra.ExactArgs(1),
+	Run:  backupRestore,
+}
+
+var (
+	backup_Format string
+	backup_DryRun bool
+)
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupShowCmd)
+	backupCmd.AddCommand(backupDiffCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	backupListCmd.Flags().StringVarP(&backup_Format, "format", "o", formatTable,
+		"Output format: table, csv or json")
+	backupRestoreCmd.Flags().BoolVar(&backup_DryRun, "dry-run", false,
+		"Print the changes as a unified diff instead of making them")
+}
+
+// listBackups returns the backups of the project in CWD.
+func listBackups() []backup.Entry {
+	if !utils.FileExists(config.ProjectFilePath) {
+		log.Fatalf("error: project file %q doesn't exist, run the command from the project root.\n",
+			config.ProjectFilePath)
+	}
+	entries, err := backup.List(".")
+	if err != nil {
+		log.Fatalf("error: failed to list the backups: %v\n", err)
+	}
+	return entries
+}
+
+// findBackup returns the backup with the ID.
+func findBackup(id string) *backup.Entry {
+	e, err := backup.Find(listBackups(), id)
+	if err != nil {
+		log.Fatalf("error: %v, see 'ergomcutool backup list'.\n", err)
+	}
+	return e
+}
+
+func backupList(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(backup_Format)
+	entries := listBackups()
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		paths := make([]string, 0, len(e.Files)+len(e.Links))
+		for _, f := range e.Files {
+			paths = append(paths, filepath.ToSlash(f.Path))
+		}
+		for _, l := range e.Links {
+			paths = append(paths, filepath.ToSlash(l.Path))
+		}
+		rows = append(rows, []string{e.ID, e.Kind, e.Time.Format("2006-01-02 15:04:05"),
+			strings.Join(paths, ", ")})
+	}
+	if len(rows) == 0 && backup_Format == formatTable {
+		log.Printf("No backups found.\n")
+		return
+	}
+	printRecords(backup_Format, []string{"ID", "KIND", "TIME", "FILES"}, rows, entries)
+}
+
+func backupShow(cmd *cobra.Command, args []string) {
+	e := findBackup(args[0])
+	if e.Kind != backup.KindUpdate && len(args) == 1 {
+		args = append(args, e.Files[0].Path)
+	}
+	if len(args) == 1 {
+		rows := make([][]string, 0, len(e.Files)+len(e.Links))
+		for _, f := range e.Files {
+			status := "saved"
+			if f.Backup == "" {
+				status = "didn't exist"
+			}
+			rows = append(rows, []string{filepath.ToSlash(f.Path), status})
+		}
+		for _, l := range e.Links {
+			status := "symlink to " + l.Target
+			if l.Target == "" {
+				status = "symlink didn't exist"
+			}
+			rows = append(rows, []string{filepath.ToSlash(l.Path), status})
+		}
+		printRecords(formatTable, []string{"PATH", "BACKUP"}, rows, nil)
+		return
+	}
+	path := filepath.Clean(args[1])
+	for _, f := range e.Files {
+		if filepath.Clean(f.Path) != path {
+			continue
+		}
+		if f.Backup == "" {
+			log.Fatalf("error: %q didn't exist when backup %q was made.\n", args[1], e.ID)
+		}
+		data, err := os.ReadFile(f.Backup)
+		if err != nil {
+			log.Fatalf("error: failed to read %q: %v\n", f.Backup, err)
+		}
+		_, _ = os.Stdout.Write(data)
+		return
+	}
+	log.Fatalf("error: backup %q doesn't contain %q.\n", e.ID, args[1])
+}
+
+func backupDiff(cmd *cobra.Command, args []string) {
+	e := findBackup(args[0])
+	c := newChangeSet(true)
+	if err := backup.Restore(".", e, c); err != nil {
+		log.Fatalf("error: %v\n", err)
+	}
+	if !c.Changed() {
+		log.Printf("The files are identical to backup %q.\n", e.ID)
+		return
+	}
+	fmt.Print(c.Diff())
+}
+
+func backupRestore(cmd *cobra.Command, args []string) {
+	e := findBackup(args[0])
+	c := newChangeSet(backup_DryRun)
+	if err := backup.Restore(".", e, c); err != nil {
+		log.Fatalf("error: %v\n", err)
+	}
+	if c.DryRun {
+		fmt.Print(c.Diff())
+	}
+	if !c.Changed() {
+		log.Printf("The files are identical to backup %q, nothing to restore.\n", e.ID)
+		return
+	}
+	if c.DryRun {
+		log.Printf("%d file(s) and %d symlink(s) would be restored.\n", len(c.Files), len(c.Links))
+		return
+	}
+	id, err := backup.Snapshot(".", c, config.UpdateSnapshotsLimit)
+	if err != nil {
+		log.Printf("warning: failed to save the snapshot of the replaced files: %v.\n", err)
+	} else {
+		log.Printf("The replaced files were saved to backup %q.\n", id)
+	}
+	log.Printf("%d file(s) and %d symlink(s) were restored from backup %q.\n",
+		len(c.Files), len(c.Links), e.ID)
+}
@@ -2,8 +2,11 @@ package cli
 
 import (
 	"fmt"
+	"log"
+	"path/filepath"
 
 	"github.com/mcu-art/ergomcutool/config"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 )
 
@@ -12,6 +15,12 @@ var appShortDescription = fmt.Sprintf("ergomcutool version %s", config.Version)
 // verbose is a persistent flag that can be used by all CLI commands.
 var verbose bool
 
+// Persistent flags overriding the tool configuration
+var (
+	root_Settings   []string
+	root_ConfigFile string
+)
+
 var rootCmd = &cobra.Command{
 	Use:     "ergomcutool",
 	Short:   appShortDescription,
@@ -40,8 +49,24 @@ func init() {
 	// Add persistent flags
 	rootCmd.PersistentFlags().BoolVarP(
 		&verbose, "verbose", "", false, "Verbose mode")
+	rootCmd.PersistentFlags().StringArrayVar(&root_Settings, "set", nil,
+		"Override a configuration setting, e.g. --set openocd.interface=jlink.cfg (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&root_ConfigFile, "config", "",
+		"Use the file instead of the user configuration file '~/.ergomcutool/ergomcutool_config.yaml'")
 }
 
 func initConfig() {
-	// nothing to do
+	config.DefaultOverrides.Settings = root_Settings
+	if root_ConfigFile != "" {
+		path, err := homedir.Expand(root_ConfigFile)
+		if err == nil {
+			path, err = filepath.Abs(path)
+		}
+		if err != nil {
+			log.Fatalf("error: invalid --config path %q: %v\n", root_ConfigFile, err)
+		}
+		// The file is only read, the commands that write the user configuration
+		// keep using config.UserConfigFilePath
+		config.DefaultOverrides.UserConfigFile = path
+	}
 }
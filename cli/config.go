@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and edit the tool configuration",
+	Long: `Inspect and edit the tool configuration. The settings are merged from
+the user configuration file, the local configuration file of the project
+(_non_persistent/ergomcutool_config.yaml), the ERGOMCUTOOL_* environment variables
+and the --set flags, the later layers take precedence.
+The keys are dotted, e.g. 'openocd.interface' or 'external_dependencies.<VAR>.path'.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the configuration layers or the effective configuration",
+	Run:   configShow,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "Print the effective value of the setting",
+	Args:  cobra.ExactArgs(1),
+	Run:   configGet,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: "Set the value in the configuration file",
+	Long: `Set the value in the configuration file, the comments and the formatting
+of the file are preserved. The local configuration file is edited
+if the command is run from the project root, the user one otherwise.
+An empty VALUE is written as null.`,
+	Args: cobra.ExactArgs(2),
+	Run:  configSet,
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open the configuration file in $EDITOR",
+	Long: `Open the configuration file in $VISUAL or $EDITOR ('vi' by default).
+The local configuration file is edited if the command is run from the project root,
+the user one otherwise.`,
+	Run: configEdit,
+}
+
+var (
+	config_Format    string
+	config_Effective bool
+	config_Local     bool
+	config_User      bool
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configEditCmd)
+	configShowCmd.Flags().StringVarP(&config_Format, "format", "o", formatTable,
+		"Output format: table, csv or json")
+	configShowCmd.Flags().BoolVar(&config_Effective, "effective", false,
+		"Print the merged settings with the layer that defined each value")
+	for _, cmd := range []*cobra.Command{configSetCmd, configEditCmd} {
+		cmd.Flags().BoolVar(&config_Local, "local", false, "Edit the local configuration file")
+		cmd.Flags().BoolVar(&config_User, "user", false, "Edit the user configuration file")
+		cmd.MarkFlagsMutuallyExclusive("local", "user")
+	}
+}
+
+// configFile returns the configuration file selected by --local and --user.
+func configFile() string {
+	if config_Local || (!config_User && utils.FileExists(config.ProjectFilePath)) {
+		if !utils.FileExists(config.ProjectFilePath) {
+			log.Fatalf("error: project file %q doesn't exist, run the command from the project root.\n",
+				config.ProjectFilePath)
+		}
+		return config.LocalConfigFilePath
+	}
+	if config.DefaultOverrides.UserConfigFile != "" {
+		log.Fatalf("error: the --config file %q is only read, edit it directly.\n",
+			config.DefaultOverrides.UserConfigFile)
+	}
+	return config.UserConfigFilePath
+}
+
+// effectiveSettings returns the merged configuration of the project in CWD.
+func effectiveSettings() []config.Setting {
+	settings, err := config.Effective(config.UserConfigDir, ".", config.DefaultOverrides, log.Default())
+	if errors.Is(err, config.ErrNotInitialized) {
+		log.Fatalf("error: ergomcutool configuration file doesn't exist, please run 'ergomcutool init' first.\n")
+	}
+	if errors.Is(err, config.ErrNewerVersion) {
+		log.Fatalf("error: %v.\nPlease upgrade ergomcutool.\n", err)
+	}
+	if err != nil {
+		log.Fatalf("error: %v\n", err)
+	}
+	return settings
+}
+
+func configShow(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(config_Format)
+	if config_Effective {
+		settings := effectiveSettings()
+		rows := make([][]string, 0, len(settings))
+		for _, s := range settings {
+			rows = append(rows, []string{s.Key, s.Value, s.Origin})
+		}
+		printRecords(config_Format, []string{"KEY", "VALUE", "ORIGIN"}, rows, settings)
+		return
+	}
+
+	// The layers in the order of precedence, the lowest first
+	type layer struct {
+		Layer  string `json:"layer"`
+		Source string `json:"source"`
+		Status string `json:"status"`
+	}
+	exists := func(path string) string {
+		if utils.FileExists(path) {
+			return "ok"
+		}
+		return "doesn't exist"
+	}
+	userConfigFile := config.UserConfigFile(config.UserConfigDir, config.DefaultOverrides)
+	layers := []layer{{"user", userConfigFile, exists(userConfigFile)}}
+	if utils.FileExists(config.ProjectFilePath) {
+		layers = append(layers, layer{"local", config.LocalConfigFilePath, exists(config.LocalConfigFilePath)})
+	}
+	env := os.Environ()
+	sort.Strings(env)
+	for _, e := range env {
+		if strings.HasPrefix(e, config.EnvPrefix) {
+			layers = append(layers, layer{"env", e, "ok"})
+		}
+	}
+	for _, s := range config.DefaultOverrides.Settings {
+		layers = append(layers, layer{config.OriginCLI, s, "ok"})
+	}
+	rows := make([][]string, 0, len(layers))
+	for _, l := range layers {
+		rows = append(rows, []string{l.Layer, l.Source, l.Status})
+	}
+	printRecords(config_Format, []string{"LAYER", "SOURCE", "STATUS"}, rows, layers)
+}
+
+func configGet(cmd *cobra.Command, args []string) {
+	// Unknown keys are reported even if the setting isn't defined
+	if _, err := (&config.ToolConfigT{}).Get(args[0]); err != nil {
+		log.Fatalf("error: %v.\n", err)
+	}
+	for _, s := range effectiveSettings() {
+		if s.Key == args[0] {
+			fmt.Println(s.Value)
+			return
+		}
+	}
+	log.Fatalf("error: %q is not set.\n", args[0])
+}
+
+func configSet(cmd *cobra.Command, args []string) {
+	key, value := args[0], args[1]
+	if err := (&config.ToolConfigT{}).Set(key, value); err != nil {
+		log.Fatalf("error: %v.\n", err)
+	}
+	file := configFile()
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		// The new file has the current schema
+		data = []byte(config.VersionKey + ": " + config.FileSchemaVersion + "\n")
+	} else if err != nil {
+		log.Fatalf("error: failed to read %q: %v\n", file, err)
+	}
+	data, err = config.SetYamlValue(data, key, value)
+	if err != nil {
+		log.Fatalf("error: failed to set %q in %q: %v.\n", key, file, err)
+	}
+	if err = yaml.Unmarshal(data, &config.ToolConfigT{}); err != nil {
+		log.Fatalf("error: %q would become invalid: %v.\n", file, err)
+	}
+	written, err := utils.WriteFileIfChanged(file, data, config.DefaultDirPermissions, config.DefaultFilePermissions)
+	if err != nil {
+		log.Fatalf("error: failed to write %q: %v\n", file, err)
+	}
+	if written {
+		log.Printf("%q was set in %q.\n", key, file)
+	} else {
+		log.Printf("%q already has this value in %q.\n", key, file)
+	}
+}
+
+func configEdit(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	file := configFile()
+	if !utils.FileExists(file) {
+		// The local configuration starts as a copy of the user one
+		userConfigFile := config.UserConfigFile(config.UserConfigDir, config.DefaultOverrides)
+		if err := utils.CopyFile(userConfigFile, file); err != nil {
+			log.Fatalf("error: failed to create %q: %v\n", file, err)
+		}
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may have arguments, e.g. 'code --wait'
+	editorArgs := strings.Fields(editor)
+	c := exec.Command(editorArgs[0], append(editorArgs[1:], file)...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		log.Fatalf("error: editor %q failed: %v\n", editor, err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("error: failed to read %q: %v\n", file, err)
+	}
+	if err = yaml.Unmarshal(data, &config.ToolConfigT{}); err != nil {
+		log.Printf("warning: %q is invalid: %v.\nRun the command again to fix it.\n", file, err)
+	}
+}
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/deps"
+	"github.com/mcu-art/ergomcutool/gitdep"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/spf13/cobra"
+)
+
+var depsCmd = &cobra.Command{
+	Use:   "deps",
+	Short: "Manage the external dependencies",
+}
+
+var depsFetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Fetch the git dependencies at the locked commits",
+	Long: `Fetch the git dependencies into the user cache and check out the commits
+locked in 'ergomcutool/ergomcu.lock'. The dependencies that are not locked yet
+are resolved from their 'ref' and added to the lockfile.`,
+	Run: depsFetch,
+}
+
+var depsUpdateCmd = &cobra.Command{
+	Use:   "update [VAR...]",
+	Short: "Update the locked commits of the git dependencies",
+	Long: `Fetch the git dependencies and lock the commits their 'ref' currently points to.
+Only the specified dependencies are updated if any variables are given.`,
+	Run: depsUpdate,
+}
+
+var depsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Print the merged external dependencies",
+	Long: `Print the external dependencies merged from the project file
+and the user and local ergomcutool_config.yaml: the resolved path,
+where each setting comes from and the symlink status in '_external'.`,
+	Run: depsList,
+}
+
+var depsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the external dependencies for problems",
+	Long: `Check the external dependencies for missing paths, dangling symlinks
+in '_external' and variables that are never referenced by the project sources.
+The command exits with a non-zero code if problems are found.`,
+	Run: depsCheck,
+}
+
+var depsGraphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Print the dependency graph in DOT format",
+	Long: `Print the dependency graph in DOT format, e.g.:
+  ergomcutool deps graph | dot -Tsvg -o deps.svg
+Dependencies without ergomcu_lib.yaml are drawn dashed.`,
+	Run: depsGraph,
+}
+
+var deps_Format string
+
+func init() {
+	rootCmd.AddCommand(depsCmd)
+	depsCmd.AddCommand(depsFetchCmd)
+	depsCmd.AddCommand(depsUpdateCmd)
+	depsCmd.AddCommand(depsListCmd)
+	depsCmd.AddCommand(depsCheckCmd)
+	depsCmd.AddCommand(depsGraphCmd)
+	for _, cmd := range []*cobra.Command{depsListCmd, depsCheckCmd} {
+		cmd.Flags().StringVarP(&deps_Format, "format", "o", formatTable,
+			"Output format: table, csv or json")
+	}
+}
+
+func depsFetch(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	syncGitDeps(false, nil)
+}
+
+func depsUpdate(cmd *cobra.Command, args []string) {
+	syncGitDeps(len(args) == 0, args)
+}
+
+// syncGitDeps fetches the git dependencies and updates the lockfile.
+func syncGitDeps(updateAll bool, update []string) {
+	pc := readProjectDeps()
+
+	gitVars := make([]string, 0, len(pc.ExternalDependencies))
+	for _, d := range pc.ExternalDependencies {
+		if d.Git != "" {
+			gitVars = append(gitVars, d.Var)
+		}
+	}
+	for _, v := range update {
+		found := false
+		for _, gv := range gitVars {
+			found = found || gv == v
+		}
+		if !found {
+			log.Fatalf("error: %q is not a git dependency.\n", v)
+		}
+	}
+
+	lock, err := gitdep.ReadLock(config.LockFilePath)
+	if err != nil {
+		log.Fatalf("error: failed to read %q: %v\n", config.LockFilePath, err)
+	}
+	// Keep the entries of the dependencies overridden by a local path
+	allVars := make([]string, 0, len(pc.ExternalDependencies))
+	for _, d := range pc.ExternalDependencies {
+		allVars = append(allVars, d.Var)
+	}
+	lock.Prune(allVars)
+
+	if len(gitVars) > 0 {
+		log.Printf("Fetching %d git dependencies...\n", len(gitVars))
+	}
+	changed, syncErr := gitdep.Sync(".", gitdep.CacheDir(config.UserConfigDir), pc.ExternalDependencies,
+		lock, updateAll, update)
+	for _, e := range changed {
+		log.Printf("%s: locked %s at %s.\n", e.Var, e.Git, e.Commit)
+	}
+
+	// The entries resolved before a failure are written too
+	if len(lock.Dependencies) > 0 || utils.FileExists(config.LockFilePath) {
+		written, err := lock.Write(config.LockFilePath)
+		if err != nil {
+			log.Fatalf("error: failed to write %q: %v\n", config.LockFilePath, err)
+		}
+		if written {
+			log.Printf("%q was updated.\n", config.LockFilePath)
+		}
+	}
+	if syncErr != nil {
+		log.Fatalf("error: failed to fetch git dependencies: %v.\n", syncErr)
+	}
+	log.Printf("Done.\n")
+}
+
+// readProjectDeps reads the project with the merged external dependencies.
+func readProjectDeps() *proj.ErgomcuProjectT {
+	config.ParseErgomcutoolConfig(false)
+	pc, err := proj.ReadAndValidate(config.ProjectFilePath)
+	var validationErr *proj.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Fatalf("error: ergomcutool project validation failed: %v.\nFix errors in %q and try again.\n",
+			validationErr.Err, config.ProjectFilePath)
+	}
+	if errors.Is(err, config.ErrNewerVersion) {
+		log.Fatalf("error: %v.\nPlease upgrade ergomcutool.\n", err)
+	}
+	if err != nil {
+		log.Fatalf("error: failed to read project file %q: %v\n",
+			config.ProjectFilePath, err)
+	}
+	return pc
+}
+
+// depInfo is the 'deps list' JSON record.
+type depInfo struct {
+	Var                 string            `json:"var"`
+	Path                string            `json:"path"`
+	Git                 string            `json:"git,omitempty"`
+	Ref                 string            `json:"ref,omitempty"`
+	Commit              string            `json:"commit,omitempty"`
+	CreateInProjectLink bool              `json:"create_in_project_link"`
+	LinkName            string            `json:"link_name,omitempty"`
+	Sources             deps.FieldSources `json:"sources"`
+	LinkStatus          string            `json:"link_status"`
+}
+
+func depsList(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(deps_Format)
+	pc := readProjectDeps()
+
+	projectDeps, err := proj.ReadExternalDependencies(config.ProjectFilePath)
+	if err != nil {
+		log.Fatalf("error: failed to read %q: %v\n", config.ProjectFilePath, err)
+	}
+	userConfigFile := config.UserConfigFile(config.UserConfigDir, config.DefaultOverrides)
+	userDeps, err := config.ReadExternalDependencies(userConfigFile)
+	if err != nil {
+		log.Fatalf("error: failed to read %q: %v\n", userConfigFile, err)
+	}
+	localDeps, err := config.ReadExternalDependencies(config.LocalConfigFilePath)
+	if err != nil {
+		log.Fatalf("error: failed to read %q: %v\n", config.LocalConfigFilePath, err)
+	}
+
+	settings, err := config.Effective(config.UserConfigDir, ".", config.DefaultOverrides,
+		log.New(io.Discard, "", 0))
+	if err != nil {
+		log.Fatalf("error: failed to read the configuration: %v\n", err)
+	}
+
+	sorted := append([]config.ExternalDependencyT{}, pc.ExternalDependencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Var < sorted[j].Var })
+	infos := make([]depInfo, 0, len(sorted))
+	rows := make([][]string, 0, len(sorted))
+	for _, d := range sorted {
+		info := depInfo{
+			Var:                 d.Var,
+			Path:                d.Path,
+			Git:                 d.Git,
+			Ref:                 d.Ref,
+			Commit:              d.Commit,
+			CreateInProjectLink: d.CreateInProjectLink,
+			LinkName:            d.LinkName,
+			Sources:             deps.Sources(&d, projectDeps, userDeps, localDeps, settings),
+			LinkStatus:          deps.LinkStatus(&d, "_external"),
+		}
+		infos = append(infos, info)
+		path := d.Path
+		if path == "" {
+			path = "(not fetched)"
+		}
+		link := "-"
+		if d.CreateInProjectLink {
+			link = d.LinkName
+		}
+		rows = append(rows, []string{d.Var, path, info.Sources.Path,
+			link, info.Sources.LinkName, info.LinkStatus})
+	}
+	printRecords(deps_Format, []string{"VAR", "PATH", "PATH SOURCE", "LINK", "LINK SOURCE", "LINK STATUS"},
+		rows, infos)
+}
+
+// depIssue is a problem found by 'deps check'.
+type depIssue struct {
+	Severity string `json:"severity"`
+	Var      string `json:"var"`
+	Message  string `json:"message"`
+}
+
+// projectPaths returns the source paths and include directories of the project
+// as they are written in the project file.
+func projectPaths(pc *proj.ErgomcuProjectT) []string {
+	r := make([]string, 0, 50)
+	addDirs := func(dirs []proj.SrcDirT) {
+		for _, d := range dirs {
+			r = append(r, d.Path)
+		}
+	}
+	r = append(r, pc.CSrc...)
+	r = append(r, pc.CIncludeDirs...)
+	r = append(r, pc.CppSrc...)
+	r = append(r, pc.AsmSrc...)
+	addDirs(pc.CSrcDirs)
+	addDirs(pc.CppSrcDirs)
+	for _, img := range pc.Images {
+		r = append(r, img.CSrc...)
+		r = append(r, img.CIncludeDirs...)
+		addDirs(img.CSrcDirs)
+	}
+	return r
+}
+
+func depsCheck(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(deps_Format)
+	pc := readProjectDeps()
+
+	issues := make([]depIssue, 0, 10)
+	addIssue := func(severity, v, format string, a ...any) {
+		issues = append(issues, depIssue{severity, v, fmt.Sprintf(format, a...)})
+	}
+
+	// Libraries that are used by other libraries or have a manifest are used
+	used := make(map[string]bool, len(pc.ExternalDependencies))
+	libs, err := deps.Resolve(".", pc.ExternalDependencies)
+	if err != nil {
+		addIssue("error", "", "%v", err)
+	}
+	for _, lib := range libs {
+		if lib.Manifest != nil {
+			used[lib.Var] = true
+		}
+		for _, d := range lib.Dependencies {
+			used[d] = true
+		}
+	}
+
+	paths := projectPaths(pc)
+	links := make(map[string]bool, len(pc.ExternalDependencies))
+	for _, d := range pc.ExternalDependencies {
+		switch {
+		case d.Git != "" && d.Commit == "":
+			addIssue("error", d.Var, "git dependency is not fetched, run 'ergomcutool deps fetch'")
+		case !utils.DirExists(d.Path):
+			addIssue("error", d.Var, "path %q doesn't exist", d.Path)
+		}
+		if d.CreateInProjectLink {
+			link := filepath.Join("_external", d.LinkName)
+			links[link] = true
+			switch status := deps.LinkStatus(&d, "_external"); status {
+			case deps.LinkOk:
+			case deps.LinkMissing:
+				addIssue("warning", d.Var, "symlink %q is missing, run 'ergomcutool update-project'", link)
+			case deps.LinkWrong:
+				addIssue("error", d.Var, "symlink %q doesn't point to %q, run 'ergomcutool update-project'", link, d.Path)
+			default:
+				addIssue("error", d.Var, "symlink %q: %s", link, status)
+			}
+		}
+		if !used[d.Var] && !deps.IsReferenced(&d, paths) {
+			addIssue("warning", d.Var, "variable is never referenced in the project sources and include directories")
+		}
+	}
+
+	dangling, err := deps.DanglingLinks("_external")
+	if err != nil {
+		log.Fatalf("error: failed to read '_external': %v\n", err)
+	}
+	for _, link := range dangling {
+		if !links[link] {
+			addIssue("error", "", "dangling symlink %q", link)
+		}
+	}
+
+	if deps_Format == formatTable && len(issues) == 0 {
+		fmt.Printf("%d external dependencies: no issues found.\n", len(pc.ExternalDependencies))
+		return
+	}
+	rows := make([][]string, 0, len(issues))
+	for _, issue := range issues {
+		rows = append(rows, []string{issue.Severity, issue.Var, issue.Message})
+	}
+	printRecords(deps_Format, []string{"SEVERITY", "VAR", "MESSAGE"}, rows, issues)
+	if len(issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+func depsGraph(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	pc := readProjectDeps()
+	libs, err := deps.Resolve(".", pc.ExternalDependencies)
+	if err != nil {
+		log.Fatalf("error: failed to resolve external dependencies: %v.\n", err)
+	}
+	roots := make([]string, 0, len(pc.ExternalDependencies))
+	for _, d := range pc.ExternalDependencies {
+		roots = append(roots, d.Var)
+	}
+	fmt.Print(deps.Graph(*pc.ProjectName, roots, libs))
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/doctor"
+	"github.com/mcu-art/ergomcutool/gitdep"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the ergomcutool environment",
+	Long: `Diagnose the ergomcutool environment: check that the configured
+tools exist and respond to '--version', that the openocd scripts are found
+and, if run from the project root, that the CubeMX user action scripts
+in the .ioc file are executable and the '_external' symlinks are valid.
+Exits with code 1 if any check fails.`,
+	Run: doctorRun,
+}
+
+var doctor_Format string
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVarP(&doctor_Format, "format", "o", formatTable,
+		"Output format: table, csv or json")
+}
+
+// doctorChecks runs the checks for the project in CWD.
+func doctorChecks() []doctor.Result {
+	discard := log.New(io.Discard, "", 0)
+	// The configuration is not validated, doctor reports each setting
+	tool, err := config.LoadUnvalidated(config.UserConfigDir, ".", config.DefaultOverrides, discard)
+	if errors.Is(err, config.ErrNotInitialized) {
+		return []doctor.Result{{Check: "user config", Status: doctor.StatusFail,
+			Message: fmt.Sprintf("%q doesn't exist", config.UserConfigFilePath),
+			Hint:    "Run 'ergomcutool init'."}}
+	}
+	if err != nil {
+		return []doctor.Result{{Check: "config", Status: doctor.StatusFail,
+			Message: err.Error(), Hint: "Fix ergomcutool_config.yaml (user or local)."}}
+	}
+	opts := doctor.Options{Tool: tool, ProjectDir: "."}
+	r := make([]doctor.Result, 0, 20)
+	if utils.FileExists(config.ProjectFilePath) {
+		pc, err := proj.Read(".", gitdep.CacheDir(config.UserConfigDir), tool, discard)
+		var validationErr *proj.ValidationError
+		switch {
+		case err == nil:
+			r = append(r, doctor.Result{Check: "project", Status: doctor.StatusPass,
+				Message: config.ProjectFilePath})
+			opts.Project = pc
+		case errors.As(err, &validationErr):
+			// The partially read project is still checked
+			r = append(r, doctor.Result{Check: "project", Status: doctor.StatusFail,
+				Message: validationErr.Err.Error(), Hint: fmt.Sprintf("Fix %q.", config.ProjectFilePath)})
+			opts.Project = pc
+		default:
+			r = append(r, doctor.Result{Check: "project", Status: doctor.StatusFail,
+				Message: fmt.Sprintf("failed to read %q: %v", config.ProjectFilePath, err)})
+		}
+	}
+	return append(r, doctor.Run(opts)...)
+}
+
+func doctorRun(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(doctor_Format)
+	results := doctorChecks()
+	counts := doctor.Counts(results)
+
+	rows := make([][]string, 0, len(results))
+	for _, res := range results {
+		row := []string{string(res.Status), res.Check, res.Message}
+		if doctor_Format != formatTable {
+			row = append(row, res.Hint)
+		}
+		rows = append(rows, row)
+	}
+	if doctor_Format == formatTable {
+		printRecords(formatTable, []string{"STATUS", "CHECK", "MESSAGE"}, rows, nil)
+		hinted := false
+		for _, res := range results {
+			if res.Hint == "" || res.Status == doctor.StatusPass {
+				continue
+			}
+			if !hinted {
+				fmt.Printf("\nHints:\n")
+				hinted = true
+			}
+			fmt.Printf("  %s: %s\n", res.Check, res.Hint)
+		}
+		fmt.Printf("\n%d passed, %d warning(s), %d failed.\n",
+			counts[doctor.StatusPass], counts[doctor.StatusWarn], counts[doctor.StatusFail])
+	} else {
+		printRecords(doctor_Format, []string{"STATUS", "CHECK", "MESSAGE", "HINT"}, rows, results)
+	}
+	if counts[doctor.StatusFail] > 0 {
+		os.Exit(1)
+	}
+}
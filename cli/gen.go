@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/mcu-art/ergomcutool/cgen"
+	"github.com/mcu-art/ergomcutool/changes"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/spf13/cobra"
+)
+
+var genCmd = &cobra.Command{
+	Use:   "gen",
+	Short: "Generate source files from the .ioc file",
+}
+
+var genPinmapCmd = &cobra.Command{
+	Use:   "pinmap",
+	Short: "Generate the board pin-map header 'ergomcutool/generated/board_pins.h'",
+	Run:   genPinmap,
+}
+
+var genClocksCmd = &cobra.Command{
+	Use:   "clocks",
+	Short: "Generate the clock configuration header 'ergomcutool/generated/clock_config.h'",
+	Run:   genClocks,
+}
+
+var (
+	gen_IocFile string
+)
+
+func init() {
+	rootCmd.AddCommand(genCmd)
+	genCmd.PersistentFlags().StringVarP(
+		&gen_IocFile, "ioc", "i", "", "Specify custom path to the .ioc file")
+	genCmd.AddCommand(genPinmapCmd)
+	genCmd.AddCommand(genClocksCmd)
+}
+
+func genPinmap(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	runHeaderGenerator(findProjectIocFile(gen_IocFile), config.PinMapHeaderPath, pinMapHeader)
+}
+
+func genClocks(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	runHeaderGenerator(findProjectIocFile(gen_IocFile), config.ClockHeaderPath, clockConfigHeader)
+}
+
+// headerGenerator creates the header contents from the parsed .ioc file.
+type headerGenerator func(parsed *iocfile.ParsedIoc, iocFileName string) (string, error)
+
+func pinMapHeader(parsed *iocfile.ParsedIoc, iocFileName string) (string, error) {
+	return cgen.PinMapHeader(parsed, iocFileName), nil
+}
+
+func clockConfigHeader(parsed *iocfile.ParsedIoc, iocFileName string) (string, error) {
+	if parsed.ClocksErr != nil {
+		return "", fmt.Errorf("failed to read the clock tree: %w", parsed.ClocksErr)
+	}
+	return cgen.ClockConfigHeader(parsed.Clocks, iocFileName), nil
+}
+
+// runHeaderGenerator generates the header and reports the result.
+func runHeaderGenerator(iocPath, dest string, generate headerGenerator) {
+	written, err := generateHeader(newChangeSet(false), iocPath, dest, generate)
+	if err != nil {
+		log.Fatalf("error: %v\n", err)
+	}
+	if written {
+		log.Printf("%q was successfully generated.\n", dest)
+	} else {
+		log.Printf("%q is up to date.\n", dest)
+	}
+}
+
+// generateHeader generates a header from the specified .ioc file.
+// The header is only written through the change set if its contents have changed.
+func generateHeader(c *changes.Set, iocPath, dest string, generate headerGenerator) (bool, error) {
+	ioc, err := iocfile.FromFile(iocPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the .ioc file %q: %w", iocPath, err)
+	}
+	parsed, err := ioc.Parse()
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the .ioc file %q: %w", iocPath, err)
+	}
+	header, err := generate(parsed, filepath.Base(iocPath))
+	if err != nil {
+		return false, fmt.Errorf("%q: %w", iocPath, err)
+	}
+	written, err := c.WriteFile(dest, []byte(header))
+	if err != nil {
+		return false, fmt.Errorf("failed to write %q: %w", dest, err)
+	}
+	return written, nil
+}
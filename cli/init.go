@@ -11,20 +11,34 @@ import (
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize ergomcutool globally",
-	Run:   InitErgomcutool,
+	Long: `Initialize ergomcutool globally: create the user configuration directory.
+The ARM toolchain and openocd are searched for in PATH and in the common
+install locations (/opt/gcc-arm-none-eabi-*, xPack, STM32CubeIDE),
+the found paths are written into the user configuration.
+If several candidates are found, you are asked to choose one,
+the newest version is used in non-interactive mode.`,
+	Run: InitErgomcutool,
 }
 
-var initCmdForce bool
+var (
+	initCmdForce          bool
+	initCmdNonInteractive bool
+)
 
 func init() {
 	rootCmd.AddCommand(initCmd)
 	initCmd.PersistentFlags().BoolVarP(&initCmdForce, "force", "f", false, "Replace current user config directory if exists")
+	initCmd.Flags().BoolVar(&initCmdNonInteractive, "non-interactive", false,
+		"Use the newest detected toolchain and openocd without asking")
 }
 
 func InitErgomcutool(cmd *cobra.Command, args []string) {
 	if len(args) > 0 {
 		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
 	}
+	if config.DefaultOverrides.UserConfigFile != "" {
+		log.Fatalf("error: --config can't be used with 'init', the configuration file is only read.\n")
+	}
 
 	userConfigDirExists := config.CheckUserConfigDirExists()
 	if userConfigDirExists {
@@ -41,7 +55,8 @@ If you want to delete current user settings and re-initialize the tool, use --fo
 `, config.UserConfigDir)
 		}
 	}
-	err := config.CreateUserConfig()
+	interactive := !initCmdNonInteractive && isTerminal(os.Stdin)
+	err := config.CreateUserConfig(interactive)
 	if err != nil {
 		log.Fatalf("error: failed to create ergomcutool config: %v\n", err)
 	}
@@ -52,3 +67,9 @@ If you want to delete current user settings and re-initialize the tool, use --fo
 		log.Printf("ergomcutool was successfully initialized.")
 	}
 }
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/spf13/cobra"
+)
+
+var iocClocksCmd = &cobra.Command{
+	Use:   "clocks",
+	Short: "Print the clock tree: SYSCLK, HCLK, APB bus and peripheral kernel clocks",
+	Run:   printIocClocks,
+}
+
+var (
+	iocClocks_Header bool
+)
+
+func init() {
+	iocCmd.AddCommand(iocClocksCmd)
+	iocClocksCmd.Flags().BoolVarP(&iocClocks_Header, "header", "", false,
+		"Also generate 'ergomcutool/generated/clock_config.h'")
+}
+
+func printIocClocks(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(ioc_Format)
+	path, _, parsed := readProjectIoc()
+	if parsed.ClocksErr != nil {
+		log.Fatalf("error: failed to read the clock tree of %q: %v.\n", path, parsed.ClocksErr)
+	}
+	clocks := parsed.Clocks
+
+	if ioc_Format == formatTable {
+		printClockTree(clocks)
+	} else {
+		header := []string{"GROUP", "NAME", "VALUE"}
+		rows := make([][]string, 0, 100)
+		rows = append(rows, []string{"source", "SYSCLK", clocks.SysclkSource})
+		rows = append(rows, []string{"source", "PLL", clocks.PllSource})
+		groups := []struct {
+			name   string
+			clocks []iocfile.Clock
+		}{
+			{"bus", clocks.Buses},
+			{"oscillator", clocks.Oscillators},
+			{"pll", clocks.Pll},
+			{"peripheral", clocks.Peripherals},
+		}
+		for _, g := range groups {
+			for _, c := range g.clocks {
+				rows = append(rows, []string{g.name, c.Name, fmt.Sprint(c.Frequency)})
+			}
+		}
+		for _, s := range clocks.Settings {
+			rows = append(rows, []string{"setting", s.Name, s.Value})
+		}
+		printRecords(ioc_Format, header, rows, clocks)
+	}
+
+	if iocClocks_Header {
+		runHeaderGenerator(path, config.ClockHeaderPath, clockConfigHeader)
+	}
+}
+
+// formatFrequency formats the frequency in Hz for humans, e.g. '170 MHz'.
+func formatFrequency(f uint64) string {
+	switch {
+	case f >= 1000000 && f%1000 == 0:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", float64(f)/1e6), "0"), ".") + " MHz"
+	case f >= 1000:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", float64(f)/1e3), "0"), ".") + " kHz"
+	default:
+		return fmt.Sprintf("%d Hz", f)
+	}
+}
+
+func printClockTree(clocks *iocfile.ClockTree) {
+	fmt.Printf("SYSCLK source: %s\n", clocks.SysclkSource)
+	if clocks.PllSource != "" {
+		fmt.Printf("PLL source:    %s\n", clocks.PllSource)
+	}
+	groups := []struct {
+		title  string
+		clocks []iocfile.Clock
+	}{
+		{"Buses", clocks.Buses},
+		{"Oscillators", clocks.Oscillators},
+		{"PLL outputs", clocks.Pll},
+		{"Peripheral kernel clocks", clocks.Peripherals},
+	}
+	for _, g := range groups {
+		if len(g.clocks) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s:\n", g.title)
+		rows := make([][]string, 0, len(g.clocks))
+		for _, c := range g.clocks {
+			rows = append(rows, []string{"  " + c.Name, formatFrequency(c.Frequency)})
+		}
+		printRecords(formatTable, nil, rows, nil)
+	}
+	if len(clocks.Settings) > 0 {
+		fmt.Printf("\nSettings:\n")
+		rows := make([][]string, 0, len(clocks.Settings))
+		for _, s := range clocks.Settings {
+			rows = append(rows, []string{"  " + s.Name, s.Value})
+		}
+		printRecords(formatTable, nil, rows, nil)
+	}
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/spf13/cobra"
+)
+
+var iocDiffCmd = &cobra.Command{
+	Use:   "diff <old.ioc> <new.ioc> | --git <rev> [file.ioc]",
+	Short: "Print semantic differences between two .ioc revisions",
+	Long: `Print semantic differences between two .ioc revisions:
+added and removed peripherals, pin reassignments, clock, DMA, interrupt,
+middleware and project setting changes, grouped by category.
+With --git, the old revision is read from git ('git show <rev>:<file>')
+and compared with the current file.`,
+	Run: iocDiff,
+}
+
+var (
+	iocDiff_GitRev string
+)
+
+func init() {
+	iocCmd.AddCommand(iocDiffCmd)
+	iocDiffCmd.Flags().StringVarP(
+		&iocDiff_GitRev, "git", "g", "", "Compare the current .ioc file with the specified git revision")
+}
+
+func iocDiff(cmd *cobra.Command, args []string) {
+	validateOutputFormat(ioc_Format)
+	var oldIoc, newIoc *iocfile.Ioc
+	var err error
+	if iocDiff_GitRev != "" {
+		if len(args) > 1 {
+			log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+		}
+		path := ioc_File
+		if len(args) == 1 {
+			path = args[0]
+		}
+		path = findProjectIocFile(path)
+		oldIoc, err = readIocFromGit(iocDiff_GitRev, path)
+		if err != nil {
+			log.Fatalf("error: %v\n", err)
+		}
+		newIoc = readIocOrExit(path)
+	} else {
+		if len(args) != 2 {
+			log.Fatalf("error: two .ioc files or --git flag must be specified.\n")
+		}
+		oldIoc = readIocOrExit(args[0])
+		newIoc = readIocOrExit(args[1])
+	}
+
+	changes, err := iocfile.Diff(oldIoc, newIoc)
+	if err != nil {
+		log.Fatalf("error: failed to compare the .ioc files: %v\n", err)
+	}
+
+	if ioc_Format == formatTable {
+		printIocChanges(changes)
+		return
+	}
+	header := []string{"CATEGORY", "KIND", "SUBJECT", "KEY", "OLD", "NEW"}
+	rows := make([][]string, 0, len(changes))
+	for _, c := range changes {
+		rows = append(rows, []string{c.Category, string(c.Kind), c.Subject, c.Key, c.Old, c.New})
+	}
+	printRecords(ioc_Format, header, rows, changes)
+}
+
+func readIocOrExit(path string) *iocfile.Ioc {
+	ioc, err := iocfile.FromFile(path)
+	if err != nil {
+		log.Fatalf("error: failed to read the .ioc file %q: %v.\n", path, err)
+	}
+	return ioc
+}
+
+// readIocFromGit reads the specified revision of the .ioc file via 'git show'.
+func readIocFromGit(rev, path string) (*iocfile.Ioc, error) {
+	dir, file := filepath.Split(path)
+	c := exec.Command("git", "show", rev+":./"+file)
+	if dir != "" {
+		c.Dir = dir
+	}
+	out, err := c.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("'git show %s:%s' failed: %s",
+				rev, path, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("failed to run git: %w", err)
+	}
+	ioc, err := iocfile.FromBytes(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q at revision %q: %w", path, rev, err)
+	}
+	return ioc, nil
+}
+
+// changeSigns are used in the human-readable diff output.
+var changeSigns = map[iocfile.ChangeKind]string{
+	iocfile.ChangeAdded:    "+",
+	iocfile.ChangeRemoved:  "-",
+	iocfile.ChangeModified: "~",
+}
+
+// printIocChanges prints the changes grouped by category.
+func printIocChanges(changes []iocfile.Change) {
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+		return
+	}
+	category := ""
+	for _, c := range changes {
+		if c.Category != category {
+			if category != "" {
+				fmt.Println()
+			}
+			category = c.Category
+			fmt.Printf("%s:\n", strings.ToUpper(category[:1])+category[1:])
+		}
+		// Entry keys already contain the subject, pin keys don't
+		line := fmt.Sprintf("  %s %s", changeSigns[c.Kind], c.Subject)
+		if strings.HasPrefix(c.Key, c.Subject+".") {
+			line = fmt.Sprintf("  %s %s", changeSigns[c.Kind], c.Key)
+		} else if c.Key != "" {
+			line += "." + c.Key
+		}
+		switch c.Kind {
+		case iocfile.ChangeAdded:
+			if c.New != "" {
+				line += " = " + c.New
+			}
+		case iocfile.ChangeRemoved:
+			if c.Old != "" {
+				line += " (was " + c.Old + ")"
+			}
+		default:
+			line += fmt.Sprintf(": %s -> %s", c.Old, c.New)
+		}
+		fmt.Println(line)
+	}
+}
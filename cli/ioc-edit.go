@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/spf13/cobra"
+)
+
+var iocGetCmd = &cobra.Command{
+	Use:   "get KEY...",
+	Short: "Print the values of the .ioc file entries",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   iocGet,
+}
+
+var iocSetCmd = &cobra.Command{
+	Use:   "set KEY=VALUE...",
+	Short: "Set the values of the .ioc file entries, missing entries are added",
+	Long: `Set the values of the .ioc file entries.
+Keys are matched exactly, missing entries are added in the alphabetical
+order used by STM32CubeMX. The original file is backed up
+into '_non_persistent/backups/ioc/' next to it before it is modified.
+Only the entries are read, so a file that fails to parse can be fixed.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  iocSet,
+}
+
+var iocUnsetCmd = &cobra.Command{
+	Use:   "unset KEY...",
+	Short: "Remove the .ioc file entries",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   iocUnset,
+}
+
+func init() {
+	iocCmd.AddCommand(iocGetCmd)
+	iocCmd.AddCommand(iocSetCmd)
+	iocCmd.AddCommand(iocUnsetCmd)
+}
+
+func iocGet(cmd *cobra.Command, args []string) {
+	_, ioc := readProjectIocEntries()
+	missing := false
+	for _, key := range args {
+		v, ok := ioc.Get(key)
+		if !ok {
+			log.Printf("error: entry %q not found.\n", key)
+			missing = true
+			continue
+		}
+		if len(args) > 1 {
+			fmt.Printf("%s=%s\n", key, v)
+		} else {
+			fmt.Println(v)
+		}
+	}
+	if missing {
+		os.Exit(1)
+	}
+}
+
+func iocSet(cmd *cobra.Command, args []string) {
+	path, ioc := readProjectIocEntries()
+	modified := false
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			log.Fatalf("error: invalid argument %q, KEY=VALUE expected.\n", arg)
+		}
+		old, exists := ioc.Get(key)
+		if exists && old == value {
+			continue
+		}
+		if !exists {
+			log.Printf("Adding new entry %q.\n", key)
+		}
+		ioc.Set(key, value)
+		modified = true
+	}
+	if !modified {
+		log.Printf("Nothing to change, %q left intact.\n", path)
+		return
+	}
+	writeIocWithBackup(path, ioc)
+}
+
+func iocUnset(cmd *cobra.Command, args []string) {
+	path, ioc := readProjectIocEntries()
+	modified := false
+	for _, key := range args {
+		if err := ioc.Delete(key); err != nil {
+			log.Printf("warning: entry %q not found.\n", key)
+			continue
+		}
+		modified = true
+	}
+	if !modified {
+		log.Printf("Nothing to remove, %q left intact.\n", path)
+		return
+	}
+	writeIocWithBackup(path, ioc)
+}
+
+// readProjectIocEntries reads the project .ioc file without parsing the pins
+// and the clocks, so that the entries of a broken file can still be fixed.
+func readProjectIocEntries() (string, *iocfile.Ioc) {
+	path := findProjectIocFile(ioc_File)
+	ioc, err := iocfile.FromFile(path)
+	if err != nil {
+		log.Fatalf("error: failed to read the .ioc file %q: %v.\n", path, err)
+	}
+	return path, ioc
+}
+
+// writeIocWithBackup backs up the original .ioc file into the backups
+// of its project and replaces it with the modified one.
+func writeIocWithBackup(path string, ioc *iocfile.Ioc) {
+	backupDir := filepath.Join(filepath.Dir(path), config.BackupsDir, "ioc")
+	backup, err := utils.BackupFile(path, backupDir, filepath.Base(path),
+		config.IocBackupsLimit, false, config.DefaultDirPermissions)
+	if err != nil {
+		log.Fatalf("error: failed to back up %q: %v\n", path, err)
+	}
+	if verbose {
+		log.Printf("* %q was backed up to %q.\n", path, backup)
+	}
+	if _, err = newChangeSet(false).WriteFile(path, ioc.Bytes()); err != nil {
+		log.Fatalf("error: failed to write %q: %v\n", path, err)
+	}
+	log.Printf("%q was successfully updated.\n", path)
+}
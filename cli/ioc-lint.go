@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/spf13/cobra"
+)
+
+var iocLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the .ioc file for common configuration mistakes",
+	Long: `Check the .ioc file for common configuration mistakes
+before the code is generated by STM32CubeMX.
+The command exits with a non-zero code if errors are found
+(or warnings, if --strict is specified), so it can be used in CI.`,
+	Run: iocLint,
+}
+
+var (
+	iocLint_Disable   []string
+	iocLint_Strict    bool
+	iocLint_ListRules bool
+)
+
+func init() {
+	iocCmd.AddCommand(iocLintCmd)
+	iocLintCmd.Flags().StringSliceVarP(&iocLint_Disable, "disable", "d", nil,
+		"Comma-separated list of rules to disable")
+	iocLintCmd.Flags().BoolVarP(&iocLint_Strict, "strict", "", false,
+		"Exit with a non-zero code if warnings are found")
+	iocLintCmd.Flags().BoolVarP(&iocLint_ListRules, "list-rules", "", false,
+		"Print the available rules and exit")
+}
+
+func iocLint(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(ioc_Format)
+
+	rules := iocfile.DefaultLintRules(iocfile.LintOptions{
+		UAScriptBeforePath: config.CubeMXBeforeGenerateScript,
+		UAScriptAfterPath:  config.CubeMXAfterGenerateScript,
+	})
+	if iocLint_ListRules {
+		rows := make([][]string, 0, len(rules))
+		for _, rule := range rules {
+			rows = append(rows, []string{rule.Name, string(rule.Severity), rule.Description})
+		}
+		printRecords(formatTable, []string{"RULE", "SEVERITY", "DESCRIPTION"}, rows, nil)
+		return
+	}
+	rules = disableLintRules(rules, iocLint_Disable)
+
+	path, ioc, _ := readProjectIoc()
+	issues, err := ioc.Lint(rules)
+	if err != nil {
+		log.Fatalf("error: failed to lint %q: %v\n", path, err)
+	}
+
+	numErrors, numWarnings := 0, 0
+	for _, issue := range issues {
+		if issue.Severity == iocfile.SeverityError {
+			numErrors++
+		} else {
+			numWarnings++
+		}
+	}
+
+	if ioc_Format == formatTable && len(issues) == 0 {
+		fmt.Printf("%s: no issues found.\n", path)
+	} else {
+		header := []string{"SEVERITY", "RULE", "KEY", "MESSAGE"}
+		rows := make([][]string, 0, len(issues))
+		for _, issue := range issues {
+			rows = append(rows, []string{string(issue.Severity), issue.Rule, issue.Key, issue.Message})
+		}
+		printRecords(ioc_Format, header, rows, issues)
+	}
+
+	if numErrors > 0 || (iocLint_Strict && numWarnings > 0) {
+		if ioc_Format == formatTable {
+			fmt.Printf("\n%d error(s), %d warning(s).\n", numErrors, numWarnings)
+		}
+		os.Exit(1)
+	}
+}
+
+// disableLintRules removes the rules with the specified names.
+func disableLintRules(rules []iocfile.LintRule, disabled []string) []iocfile.LintRule {
+	if len(disabled) == 0 {
+		return rules
+	}
+	known := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		known[rule.Name] = true
+	}
+	skip := make(map[string]bool, len(disabled))
+	for _, name := range disabled {
+		if !known[name] {
+			log.Fatalf("error: unknown lint rule %q, use --list-rules to see the available rules.\n", name)
+		}
+		skip[name] = true
+	}
+	r := make([]iocfile.LintRule, 0, len(rules))
+	for _, rule := range rules {
+		if !skip[rule.Name] {
+			r = append(r, rule)
+		}
+	}
+	return r
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/spf13/cobra"
+)
+
+var iocCmd = &cobra.Command{
+	Use:   "ioc",
+	Short: "Inspect the STM32CubeMX .ioc file",
+}
+
+var iocPinsCmd = &cobra.Command{
+	Use:   "pins",
+	Short: "Print the pin assignments",
+	Run:   printIocPins,
+}
+
+var iocPeripheralsCmd = &cobra.Command{
+	Use:   "peripherals",
+	Short: "Print the enabled peripherals",
+	Run:   printIocPeripherals,
+}
+
+var (
+	ioc_File   string
+	ioc_Format string
+)
+
+func init() {
+	rootCmd.AddCommand(iocCmd)
+	iocCmd.PersistentFlags().StringVarP(
+		&ioc_File, "ioc", "i", "", "Specify custom path to the .ioc file")
+	iocCmd.PersistentFlags().StringVarP(
+		&ioc_Format, "format", "o", formatTable, "Output format: table, csv or json")
+
+	iocCmd.AddCommand(iocPinsCmd)
+	iocCmd.AddCommand(iocPeripheralsCmd)
+}
+
+// findProjectIocFile returns 'path' if it is not empty,
+// otherwise the .ioc file found in the current directory.
+func findProjectIocFile(path string) string {
+	if path != "" {
+		return path
+	}
+	cwd, _ := os.Getwd()
+	iocFiles, err := iocfile.FindIocFiles(cwd)
+	if err != nil {
+		log.Fatalf("error: failed to get file list of the directory %q: %v.\n", cwd, err)
+	}
+	if len(iocFiles) == 0 {
+		log.Fatalf("error: no .ioc file found in %q, use --ioc flag to specify it.\n", cwd)
+	}
+	if len(iocFiles) > 1 {
+		log.Printf("warning: more than one .ioc file found, %q will be used.\n", iocFiles[0])
+	}
+	return iocFiles[0]
+}
+
+// readProjectIoc reads and parses the project .ioc file.
+func readProjectIoc() (string, *iocfile.Ioc, *iocfile.ParsedIoc) {
+	path := findProjectIocFile(ioc_File)
+	ioc, err := iocfile.FromFile(path)
+	if err != nil {
+		log.Fatalf("error: failed to read the .ioc file %q: %v.\n", path, err)
+	}
+	parsed, err := ioc.Parse()
+	if err != nil {
+		log.Fatalf("error: failed to parse the .ioc file %q: %v.\n", path, err)
+	}
+	return path, ioc, parsed
+}
+
+func printIocPins(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(ioc_Format)
+	_, _, parsed := readProjectIoc()
+
+	header := []string{"PIN", "SIGNAL", "LABEL", "MODE", "PULL", "SPEED", "VIRTUAL"}
+	rows := make([][]string, 0, len(parsed.Pins))
+	for _, p := range parsed.Pins {
+		rows = append(rows, []string{p.Name, p.Signal, p.Label, p.Mode, p.Pull, p.Speed,
+			strconv.FormatBool(p.Virtual)})
+	}
+	printRecords(ioc_Format, header, rows, parsed.Pins)
+}
+
+func printIocPeripherals(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	validateOutputFormat(ioc_Format)
+	_, _, parsed := readProjectIoc()
+
+	header := []string{"PERIPHERAL", "PINS"}
+	rows := make([][]string, 0, len(parsed.Peripherals))
+	for _, p := range parsed.Peripherals {
+		rows = append(rows, []string{p.Name, strings.Join(p.Pins, " ")})
+	}
+	printRecords(ioc_Format, header, rows, parsed.Peripherals)
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the configuration and project files to the current version",
+	Long: `Migrate the user configuration file and, if run from the project root,
+the local configuration file and the project file written by an older ergomcutool
+to the current schema. The comments of the files are preserved.
+The original files are backed up to '_non_persistent/backups/migrate'
+in the user configuration directory and in the project root respectively.`,
+	Run: migrateRun,
+}
+
+var migrate_DryRun bool
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().BoolVar(&migrate_DryRun, "dry-run", false,
+		"Print the changes as a unified diff instead of making them")
+}
+
+func migrateRun(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		log.Fatalf("error: too many CLI argument(s): %+v\n", args)
+	}
+	if !utils.FileExists(config.UserConfigFilePath) {
+		log.Fatalf("error: ergomcutool configuration file doesn't exist, please run 'ergomcutool init' first.\n")
+	}
+	type file struct {
+		migrate   func() (*config.Migration, error)
+		backupDir string
+	}
+	files := []file{{
+		func() (*config.Migration, error) { return config.MigrateConfigFile(config.UserConfigFilePath) },
+		filepath.Join(config.UserConfigDir, config.MigrateBackupsDir),
+	}}
+	if utils.FileExists(config.ProjectFilePath) {
+		if utils.FileExists(config.LocalConfigFilePath) {
+			files = append(files, file{
+				func() (*config.Migration, error) { return config.MigrateConfigFile(config.LocalConfigFilePath) },
+				config.MigrateBackupsDir,
+			})
+		}
+		files = append(files, file{func() (*config.Migration, error) { return proj.Migrate(".") },
+			config.MigrateBackupsDir})
+	}
+
+	c := newChangeSet(true)
+	for _, f := range files {
+		m, err := f.migrate()
+		if errors.Is(err, config.ErrNewerVersion) {
+			log.Fatalf("error: %v.\nPlease upgrade ergomcutool.\n", err)
+		}
+		if err != nil {
+			log.Fatalf("error: %v\n", err)
+		}
+		if !m.Needed() {
+			log.Printf("%q is up to date (%s).\n", m.Path, m.FromVersion)
+			continue
+		}
+		log.Printf("%q: %s -> %s\n", m.Path, m.FromVersion, m.ToVersion)
+		for _, s := range m.Steps {
+			log.Printf("  %s\n", s)
+		}
+		if migrate_DryRun {
+			if _, err = c.WriteFile(m.Path, m.New); err != nil {
+				log.Fatalf("error: %v\n", err)
+			}
+			continue
+		}
+		backup, err := m.Write(f.backupDir)
+		if err != nil {
+			log.Fatalf("error: failed to migrate %q: %v\n", m.Path, err)
+		}
+		log.Printf("%q was migrated, the original file was saved to %q.\n", m.Path, backup)
+	}
+	if migrate_DryRun {
+		fmt.Print(c.Diff())
+	}
+}
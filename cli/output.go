@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Supported output formats of the inspection commands.
+const (
+	formatTable = "table"
+	formatCsv   = "csv"
+	formatJson  = "json"
+)
+
+// validateOutputFormat exits with an error if the format is not supported.
+func validateOutputFormat(format string) {
+	switch format {
+	case formatTable, formatCsv, formatJson:
+	default:
+		log.Fatalf("error: unsupported output format %q, use one of: %s, %s, %s.\n",
+			format, formatTable, formatCsv, formatJson)
+	}
+}
+
+// printRecords prints the rows to stdout in the specified format.
+// The header is omitted in table format if it is nil.
+// 'jsonValue' is marshalled instead of the rows in JSON format,
+// so that the JSON output keeps the original field types.
+func printRecords(format string, header []string, rows [][]string, jsonValue any) {
+	switch format {
+	case formatJson:
+		data, err := json.MarshalIndent(jsonValue, "", "  ")
+		if err != nil {
+			log.Fatalf("error: failed to marshal json: %v\n", err)
+		}
+		fmt.Println(string(data))
+	case formatCsv:
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write(header)
+		_ = w.WriteAll(rows)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Fatalf("error: failed to write csv: %v\n", err)
+		}
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if header != nil {
+			fmt.Fprintln(w, strings.Join(header, "\t"))
+		}
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,265 @@
+// cmk package generates CMake files for ergomcutool projects.
+package cmk
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ExternalDependency is an external dependency variable
+// that is defined as a CMake cache variable.
+type ExternalDependency struct {
+	Var  string
+	Path string
+}
+
+// IncludeParams are the contents of the 'ergomcutool.cmake' include file.
+// Paths may reference the external dependency variables, e.g. '${EXAMPLE_LIB}/file.c'.
+type IncludeParams struct {
+	ExternalDependencies []ExternalDependency
+	CSources             []string
+	CppSources           []string
+	AsmSources           []string
+	IncludeDirs          []string
+	Defines              []string
+	AsmDefines           []string
+	CppFlags             []string
+	// OpenocdInterface and OpenocdTarget are used by the 'prog' target.
+	// The target is not created if OpenocdTarget is empty.
+	OpenocdInterface string
+	OpenocdTarget    string
+}
+
+// ToolchainParams are the contents of the toolchain file.
+type ToolchainParams struct {
+	// ArmToolchainPath is the directory of the ARM toolchain binaries.
+	ArmToolchainPath string
+	CCompilerPath    string
+	CppCompilerPath  string
+	// TargetFlags are the MCU-specific flags, see TargetFlags.
+	TargetFlags string
+	// LinkerScript is the project path of the linker script,
+	// see CubeMXLinkerScript. It is not passed to the linker if empty.
+	LinkerScript string
+}
+
+const header = `# This file was generated by ergomcutool, do not edit it manually.
+# Run 'ergomcutool update-project' to update it.
+`
+
+// cmakePath converts a project path into a CMake path.
+// Relative paths are resolved against the project root.
+func cmakePath(path string) string {
+	path = filepath.ToSlash(path)
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "${") {
+		return path
+	}
+	return "${CMAKE_SOURCE_DIR}/" + path
+}
+
+func writeList(b *strings.Builder, command string, items []string, format func(string) string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%s(${CMAKE_PROJECT_NAME} PRIVATE\n", command)
+	for _, item := range items {
+		fmt.Fprintf(b, "    %s\n", format(item))
+	}
+	b.WriteString(")\n")
+}
+
+// quote quotes the CMake argument if necessary.
+func quote(s string) string {
+	if strings.ContainsAny(s, " \t;\"()#") {
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+	return s
+}
+
+// IncludeFile generates the 'ergomcutool.cmake' contents.
+// The file adds the project sources, include directories and definitions
+// to the '${CMAKE_PROJECT_NAME}' target and must be included
+// after the target is defined.
+func IncludeFile(p IncludeParams) string {
+	b := strings.Builder{}
+	b.WriteString(header)
+	b.WriteString("# Include it in CMakeLists.txt after the executable target is defined:\n")
+	b.WriteString("#   include(ergomcutool/generated/ergomcutool.cmake)\n")
+
+	if len(p.ExternalDependencies) > 0 {
+		b.WriteString("\n# External dependencies\n")
+		for _, d := range p.ExternalDependencies {
+			fmt.Fprintf(&b, "set(%s %q CACHE PATH \"ergomcutool external dependency\")\n",
+				d.Var, cmakePath(d.Path))
+		}
+	}
+	if len(p.CppSources) > 0 {
+		b.WriteString("\nenable_language(CXX)\n")
+	}
+
+	sources := make([]string, 0, len(p.CSources)+len(p.CppSources)+len(p.AsmSources))
+	sources = append(sources, p.CSources...)
+	sources = append(sources, p.CppSources...)
+	sources = append(sources, p.AsmSources...)
+	writeList(&b, "target_sources", sources, func(s string) string { return quote(cmakePath(s)) })
+	writeList(&b, "target_include_directories", p.IncludeDirs,
+		func(s string) string { return quote(cmakePath(s)) })
+
+	defines := make([]string, 0, len(p.Defines)+len(p.AsmDefines))
+	defines = append(defines, p.Defines...)
+	for _, d := range p.AsmDefines {
+		defines = append(defines, "$<$<COMPILE_LANGUAGE:ASM>:"+d+">")
+	}
+	writeList(&b, "target_compile_definitions", defines, quote)
+
+	cppFlags := make([]string, 0, len(p.CppFlags))
+	for _, f := range p.CppFlags {
+		cppFlags = append(cppFlags, "$<$<COMPILE_LANGUAGE:CXX>:"+f+">")
+	}
+	writeList(&b, "target_compile_options", cppFlags, quote)
+
+	if p.OpenocdTarget != "" {
+		b.WriteString("\n# Program the MCU: cmake --build <build dir> --target prog\n")
+		b.WriteString("add_custom_target(prog\n")
+		fmt.Fprintf(&b, "    COMMAND openocd -f interface/%s -f target/%s\n", p.OpenocdInterface, p.OpenocdTarget)
+		b.WriteString("        -c \"program $<TARGET_FILE:${CMAKE_PROJECT_NAME}> verify exit reset\"\n")
+		b.WriteString("    DEPENDS ${CMAKE_PROJECT_NAME}\n")
+		b.WriteString("    USES_TERMINAL\n)\n")
+	}
+	return b.String()
+}
+
+// ToolchainFile generates the CMake toolchain file.
+func ToolchainFile(p ToolchainParams) string {
+	b := strings.Builder{}
+	b.WriteString(header)
+	b.WriteString("# Use it with 'cmake -DCMAKE_TOOLCHAIN_FILE=ergomcutool/generated/gcc-arm-none-eabi.cmake'.\n\n")
+	b.WriteString("set(CMAKE_SYSTEM_NAME Generic)\n")
+	b.WriteString("set(CMAKE_SYSTEM_PROCESSOR arm)\n\n")
+	fmt.Fprintf(&b, "set(CMAKE_C_COMPILER %q)\n", filepath.ToSlash(p.CCompilerPath))
+	fmt.Fprintf(&b, "set(CMAKE_CXX_COMPILER %q)\n", filepath.ToSlash(p.CppCompilerPath))
+	fmt.Fprintf(&b, "set(CMAKE_ASM_COMPILER %q)\n", filepath.ToSlash(p.CCompilerPath))
+	fmt.Fprintf(&b, "set(CMAKE_OBJCOPY %q)\n",
+		filepath.ToSlash(filepath.Join(p.ArmToolchainPath, "arm-none-eabi-objcopy")))
+	fmt.Fprintf(&b, "set(CMAKE_SIZE %q)\n\n",
+		filepath.ToSlash(filepath.Join(p.ArmToolchainPath, "arm-none-eabi-size")))
+	b.WriteString("set(CMAKE_EXECUTABLE_SUFFIX_C \".elf\")\n")
+	b.WriteString("set(CMAKE_EXECUTABLE_SUFFIX_CXX \".elf\")\n")
+	b.WriteString("set(CMAKE_EXECUTABLE_SUFFIX_ASM \".elf\")\n")
+	b.WriteString("set(CMAKE_TRY_COMPILE_TARGET_TYPE STATIC_LIBRARY)\n\n")
+	fmt.Fprintf(&b, "set(TARGET_FLAGS %q)\n", p.TargetFlags)
+	b.WriteString("set(CMAKE_C_FLAGS \"${CMAKE_C_FLAGS} ${TARGET_FLAGS} -Wall -fdata-sections -ffunction-sections\")\n")
+	b.WriteString("set(CMAKE_CXX_FLAGS \"${CMAKE_C_FLAGS}\")\n")
+	b.WriteString("set(CMAKE_ASM_FLAGS \"${CMAKE_C_FLAGS} -x assembler-with-cpp -MMD -MP\")\n")
+	b.WriteString("set(CMAKE_C_FLAGS_DEBUG \"-O0 -g3\")\n")
+	b.WriteString("set(CMAKE_C_FLAGS_RELEASE \"-Os -g0\")\n")
+	b.WriteString("set(CMAKE_CXX_FLAGS_DEBUG \"-O0 -g3\")\n")
+	b.WriteString("set(CMAKE_CXX_FLAGS_RELEASE \"-Os -g0\")\n\n")
+	b.WriteString("set(CMAKE_EXE_LINKER_FLAGS \"${TARGET_FLAGS} --specs=nano.specs -Wl,--gc-sections -Wl,--print-memory-usage\")\n")
+	if p.LinkerScript != "" {
+		fmt.Fprintf(&b, "set(CMAKE_EXE_LINKER_FLAGS \"${CMAKE_EXE_LINKER_FLAGS} -T \\\"%s\\\"\")\n",
+			cmakePath(p.LinkerScript))
+	}
+	b.WriteString("set(CMAKE_EXE_LINKER_FLAGS \"${CMAKE_EXE_LINKER_FLAGS} -Wl,--start-group -lc -lm -Wl,--end-group\")\n")
+	return b.String()
+}
+
+// projectPath converts the CMake path into a project path,
+// e.g. '${CMAKE_SOURCE_DIR}/Core/Inc' becomes 'Core/Inc'.
+// Returns false if the path references other CMake variables.
+func projectPath(path string) (string, bool) {
+	path = strings.Trim(path, `"\`)
+	for _, v := range []string{"${CMAKE_SOURCE_DIR}", "${CMAKE_CURRENT_SOURCE_DIR}/../.."} {
+		if rest, ok := strings.CutPrefix(path, v); ok {
+			path = strings.TrimPrefix(rest, "/")
+			break
+		}
+	}
+	if path == "" || strings.Contains(path, "${") {
+		return "", false
+	}
+	return path, true
+}
+
+var linkerScriptRe = regexp.MustCompile(`-T\s*\\?"?([^"\s\\)]+\.ld)`)
+
+// CubeMXLinkerScript returns the project path of the linker script
+// passed by the STM32CubeMX-generated toolchain file, e.g.
+// '-T "${CMAKE_SOURCE_DIR}/STM32G431CBUx_FLASH.ld"'. Returns false if there is none.
+func CubeMXLinkerScript(toolchainFile string) (string, bool) {
+	m := linkerScriptRe.FindStringSubmatch(toolchainFile)
+	if m == nil {
+		return "", false
+	}
+	return projectPath(m[1])
+}
+
+// CubeMXList returns the items of the list set in the STM32CubeMX-generated
+// 'cmake/stm32cubemx/CMakeLists.txt', e.g. 'MX_Include_Dirs' or 'MX_Defines_Syms'.
+// The paths are converted into project paths, the items that reference
+// other CMake variables or generator expressions are skipped.
+func CubeMXList(listsFile, name string) []string {
+	m := regexp.MustCompile(`set\(\s*` + regexp.QuoteMeta(name) + `\s([^)]*)\)`).FindStringSubmatch(listsFile)
+	if m == nil {
+		return nil
+	}
+	r := make([]string, 0, 20)
+	for _, item := range strings.Fields(m[1]) {
+		if strings.HasPrefix(item, "$<") {
+			continue
+		}
+		if path, ok := projectPath(item); ok {
+			r = append(r, path)
+		}
+	}
+	return r
+}
+
+// targetFamilies maps STM32 family prefixes to the compiler target flags.
+// Longer prefixes are checked first.
+var targetFamilies = []struct {
+	prefix string
+	flags  string
+}{
+	{"STM32WB0", "-mcpu=cortex-m0plus"},
+	{"STM32WBA", "-mcpu=cortex-m33 -mfpu=fpv5-sp-d16 -mfloat-abi=hard"},
+	{"STM32WB", "-mcpu=cortex-m4 -mfpu=fpv4-sp-d16 -mfloat-abi=hard"},
+	{"STM32WL", "-mcpu=cortex-m4"},
+	{"STM32F0", "-mcpu=cortex-m0"},
+	{"STM32F1", "-mcpu=cortex-m3"},
+	{"STM32F2", "-mcpu=cortex-m3"},
+	{"STM32F3", "-mcpu=cortex-m4 -mfpu=fpv4-sp-d16 -mfloat-abi=hard"},
+	{"STM32F4", "-mcpu=cortex-m4 -mfpu=fpv4-sp-d16 -mfloat-abi=hard"},
+	{"STM32F7", "-mcpu=cortex-m7 -mfpu=fpv5-d16 -mfloat-abi=hard"},
+	{"STM32G0", "-mcpu=cortex-m0plus"},
+	{"STM32G4", "-mcpu=cortex-m4 -mfpu=fpv4-sp-d16 -mfloat-abi=hard"},
+	{"STM32C0", "-mcpu=cortex-m0plus"},
+	{"STM32H5", "-mcpu=cortex-m33 -mfpu=fpv5-sp-d16 -mfloat-abi=hard"},
+	{"STM32H7", "-mcpu=cortex-m7 -mfpu=fpv5-d16 -mfloat-abi=hard"},
+	{"STM32L0", "-mcpu=cortex-m0plus"},
+	{"STM32L1", "-mcpu=cortex-m3"},
+	{"STM32L4", "-mcpu=cortex-m4 -mfpu=fpv4-sp-d16 -mfloat-abi=hard"},
+	{"STM32L5", "-mcpu=cortex-m33 -mfpu=fpv5-sp-d16 -mfloat-abi=hard"},
+	{"STM32U0", "-mcpu=cortex-m0plus"},
+	{"STM32U5", "-mcpu=cortex-m33 -mfpu=fpv5-sp-d16 -mfloat-abi=hard"},
+}
+
+// TargetFlags returns the compiler target flags for the device,
+// e.g. '-mcpu=cortex-m4 -mthumb -mfpu=fpv4-sp-d16 -mfloat-abi=hard'
+// for 'STM32G431CBUx'. Returns false if the device family is unknown.
+func TargetFlags(deviceId string) (string, bool) {
+	id := strings.ToUpper(deviceId)
+	for _, f := range targetFamilies {
+		if strings.HasPrefix(id, f.prefix) {
+			cpu, fpu, _ := strings.Cut(f.flags, " ")
+			r := cpu + " -mthumb"
+			if fpu != "" {
+				r += " " + fpu
+			}
+			return r, true
+		}
+	}
+	return "-mthumb", false
+}
@@ -0,0 +1,113 @@
+package cmk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetFlags(t *testing.T) {
+	flags, ok := TargetFlags("STM32G431CBUx")
+	require.True(t, ok)
+	require.Equal(t, "-mcpu=cortex-m4 -mthumb -mfpu=fpv4-sp-d16 -mfloat-abi=hard", flags)
+
+	flags, ok = TargetFlags("STM32F103C8Tx")
+	require.True(t, ok)
+	require.Equal(t, "-mcpu=cortex-m3 -mthumb", flags)
+
+	flags, ok = TargetFlags("STM32WBA52CGUx")
+	require.True(t, ok)
+	require.True(t, strings.HasPrefix(flags, "-mcpu=cortex-m33"))
+
+	_, ok = TargetFlags("GD32F103")
+	require.False(t, ok)
+}
+
+func TestIncludeFile(t *testing.T) {
+	s := IncludeFile(IncludeParams{
+		ExternalDependencies: []ExternalDependency{{Var: "LIB", Path: "../lib"}},
+		CSources:             []string{"src/a.c", "${LIB}/b.c", "/abs/c.c"},
+		CppSources:           []string{"src/d.cpp"},
+		IncludeDirs:          []string{"inc dir"},
+		Defines:              []string{"FOO=1"},
+		AsmDefines:           []string{"BAR"},
+		CppFlags:             []string{"-fno-rtti"},
+		OpenocdInterface:     "stlink.cfg",
+		OpenocdTarget:        "stm32g4x.cfg",
+	})
+	require.Contains(t, s, `set(LIB "${CMAKE_SOURCE_DIR}/../lib" CACHE PATH`)
+	require.Contains(t, s, "enable_language(CXX)")
+	require.Contains(t, s, "target_sources(${CMAKE_PROJECT_NAME} PRIVATE\n"+
+		"    ${CMAKE_SOURCE_DIR}/src/a.c\n    ${LIB}/b.c\n    /abs/c.c\n    ${CMAKE_SOURCE_DIR}/src/d.cpp\n)")
+	require.Contains(t, s, `"${CMAKE_SOURCE_DIR}/inc dir"`)
+	require.Contains(t, s, "    FOO=1\n    $<$<COMPILE_LANGUAGE:ASM>:BAR>\n")
+	require.Contains(t, s, "$<$<COMPILE_LANGUAGE:CXX>:-fno-rtti>")
+	require.Contains(t, s, "-f interface/stlink.cfg -f target/stm32g4x.cfg")
+
+	s = IncludeFile(IncludeParams{CSources: []string{"a.c"}})
+	require.NotContains(t, s, "enable_language")
+	require.NotContains(t, s, "add_custom_target")
+	require.NotContains(t, s, "target_compile_definitions")
+}
+
+func TestToolchainFile(t *testing.T) {
+	s := ToolchainFile(ToolchainParams{
+		ArmToolchainPath: "/opt/arm/bin",
+		CCompilerPath:    "/opt/arm/bin/arm-none-eabi-gcc",
+		CppCompilerPath:  "/opt/arm/bin/arm-none-eabi-g++",
+		TargetFlags:      "-mcpu=cortex-m0 -mthumb",
+	})
+	require.Contains(t, s, `set(CMAKE_C_COMPILER "/opt/arm/bin/arm-none-eabi-gcc")`)
+	require.Contains(t, s, `set(CMAKE_CXX_COMPILER "/opt/arm/bin/arm-none-eabi-g++")`)
+	require.Contains(t, s, `set(CMAKE_OBJCOPY "/opt/arm/bin/arm-none-eabi-objcopy")`)
+	require.Contains(t, s, `set(TARGET_FLAGS "-mcpu=cortex-m0 -mthumb")`)
+}
+
+func TestToolchainFileLinkerScript(t *testing.T) {
+	s := ToolchainFile(ToolchainParams{
+		CCompilerPath: "arm-none-eabi-gcc",
+		LinkerScript:  "STM32G431CBUx_FLASH.ld",
+	})
+	require.Contains(t, s,
+		`set(CMAKE_EXE_LINKER_FLAGS "${CMAKE_EXE_LINKER_FLAGS} -T \"${CMAKE_SOURCE_DIR}/STM32G431CBUx_FLASH.ld\"")`)
+	require.NotContains(t, ToolchainFile(ToolchainParams{}), " -T ")
+}
+
+func TestCubeMXFiles(t *testing.T) {
+	for _, tc := range []struct {
+		toolchain string
+		script    string
+	}{
+		{`set(CMAKE_EXE_LINKER_FLAGS "${CMAKE_EXE_LINKER_FLAGS} -T \"${CMAKE_SOURCE_DIR}/STM32G431CBUx_FLASH.ld\"")`,
+			"STM32G431CBUx_FLASH.ld"},
+		{`set(CMAKE_EXE_LINKER_FLAGS "${CMAKE_EXE_LINKER_FLAGS} -T${CMAKE_SOURCE_DIR}/ld/app.ld")`, "ld/app.ld"},
+		{`set(CMAKE_EXE_LINKER_FLAGS "${TARGET_FLAGS}")`, ""},
+		{`set(CMAKE_EXE_LINKER_FLAGS "-T ${LD_DIR}/app.ld")`, ""},
+	} {
+		script, ok := CubeMXLinkerScript(tc.toolchain)
+		require.Equal(t, tc.script != "", ok, tc.toolchain)
+		require.Equal(t, tc.script, script, tc.toolchain)
+	}
+
+	lists := `cmake_minimum_required(VERSION 3.22)
+set(MX_Defines_Syms 
+	USE_HAL_DRIVER 
+	STM32G431xx
+    $<$<CONFIG:Debug>:DEBUG>
+)
+
+set(MX_Include_Dirs
+    ${CMAKE_SOURCE_DIR}/Core/Inc
+    ${CMAKE_SOURCE_DIR}/Drivers/STM32G4xx_HAL_Driver/Inc
+    ${TOOLCHAIN_DIR}/include
+)
+
+set(MX_Include_Dirs_Extra
+    ${CMAKE_SOURCE_DIR}/Extra
+)
+`
+	require.Equal(t, []string{"USE_HAL_DRIVER", "STM32G431xx"}, CubeMXList(lists, "MX_Defines_Syms"))
+	require.Equal(t, []string{"Core/Inc", "Drivers/STM32G4xx_HAL_Driver/Inc"}, CubeMXList(lists, "MX_Include_Dirs"))
+	require.Empty(t, CubeMXList(lists, "MX_Application_Src"))
+}
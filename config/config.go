@@ -3,10 +3,12 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/mcu-art/ergomcutool/assets"
@@ -38,14 +40,51 @@ var (
 
 	UserConfigFilePath = filepath.Join(UserConfigDir, UserConfigFileName)
 
+	// LocalConfigFilePath is the project-local configuration file from project root.
+	LocalConfigFilePath = filepath.Join("_non_persistent", UserConfigFileName)
+
 	// Number of makefile backup files
 	MakefileBackupsLimit = 5
 
+	// Number of .ioc backup files
+	IocBackupsLimit = 5
+
+	// Number of snapshots of the files replaced by update-project
+	UpdateSnapshotsLimit = 5
+
+	// BackupsDir is the directory for backups from project root.
+	BackupsDir = filepath.Join("_non_persistent", "backups")
+
+	// MigrateBackupsDir is the directory for the files replaced by migrate from project root.
+	MigrateBackupsDir = filepath.Join(BackupsDir, "migrate")
+
+	// Number of backups of each migrated file
+	MigrationBackupsLimit = 5
+
 	LocalErgomcuDir = "ergomcutool"
 
 	// ProjectFilePath is the path to the project file from project root.
-	ProjectFilePath   = filepath.Join(LocalErgomcuDir, "ergomcu_project.yaml")
+	ProjectFilePath = filepath.Join(LocalErgomcuDir, "ergomcu_project.yaml")
+	// LockFilePath is the lockfile of the git dependencies from project root.
+	LockFilePath      = filepath.Join(LocalErgomcuDir, "ergomcu.lock")
 	ProjectScriptsDir = filepath.Join(LocalErgomcuDir, "scripts")
+
+	// CubeMX user action scripts
+	CubeMXBeforeGenerateScript = filepath.Join(ProjectScriptsDir, "cubemx-before-generate.sh")
+	CubeMXAfterGenerateScript  = filepath.Join(ProjectScriptsDir, "cubemx-after-generate.sh")
+
+	// GeneratedDir is the directory for the files generated by ergomcutool.
+	GeneratedDir     = filepath.Join(LocalErgomcuDir, "generated")
+	PinMapHeaderPath = filepath.Join(GeneratedDir, "board_pins.h")
+	ClockHeaderPath  = filepath.Join(GeneratedDir, "clock_config.h")
+
+	// CMake files generated when the project build system is CMake
+	CMakeIncludePath   = filepath.Join(GeneratedDir, "ergomcutool.cmake")
+	CMakeToolchainPath = filepath.Join(GeneratedDir, "gcc-arm-none-eabi.cmake")
+
+	// CMake files generated by STM32CubeMX
+	CubeMXCMakeToolchainPath = filepath.Join("cmake", "gcc-arm-none-eabi.cmake")
+	CubeMXCMakeListsPath     = filepath.Join("cmake", "stm32cubemx", "CMakeLists.txt")
 )
 
 // user and local tool configuration file names
@@ -61,13 +100,14 @@ type ToolConfig_GeneralT struct {
 	DebuggerPath     *string `yaml:"debugger_path"`
 }
 
-func (g *ToolConfig_GeneralT) Validate() error {
+// Validate validates the general settings, warnings are printed to the logger.
+func (g *ToolConfig_GeneralT) Validate(logger *log.Logger) error {
 	if g.ArmToolchainPath == nil {
 		return fmt.Errorf("'arm_toolchain_path' parameter is not defined")
 	}
 	dirExists := utils.DirExists(*g.ArmToolchainPath)
 	if !dirExists {
-		log.Printf("%s general:'arm_toolchain_path' must specify an existing directory.%s",
+		logger.Printf("%s general:'arm_toolchain_path' must specify an existing directory.%s",
 			toolConfigWarningPrefix, toolConfigWarningSuffix)
 	}
 	if g.CCompilerPath == nil {
@@ -89,7 +129,8 @@ type ToolConfig_OpenOcdT struct {
 	SvdFilePath string  `yaml:"svd_file_path"`
 }
 
-func (g *ToolConfig_OpenOcdT) Validate() error {
+// Validate validates the openocd settings, warnings are printed to the logger.
+func (g *ToolConfig_OpenOcdT) Validate(logger *log.Logger) error {
 
 	if g.Interface == nil {
 		return fmt.Errorf("openocd:'interface' parameter is not defined")
@@ -104,7 +145,7 @@ func (g *ToolConfig_OpenOcdT) Validate() error {
 	}
 	exists := utils.FileExists(*g.BinPath)
 	if !exists {
-		log.Printf("%s openocd:'bin_path' must specify an existing file.%s\n", toolConfigWarningPrefix, toolConfigWarningSuffix)
+		logger.Printf("%s openocd:'bin_path' must specify an existing file.%s\n", toolConfigWarningPrefix, toolConfigWarningSuffix)
 	}
 
 	if g.ScriptsPath == nil {
@@ -112,7 +153,7 @@ func (g *ToolConfig_OpenOcdT) Validate() error {
 	}
 	exists = utils.DirExists(*g.ScriptsPath)
 	if !exists {
-		log.Printf("%s openocd:'scripts_path' must specify an existing directory.%s\n",
+		logger.Printf("%s openocd:'scripts_path' must specify an existing directory.%s\n",
 			toolConfigWarningPrefix, toolConfigWarningSuffix)
 	}
 
@@ -124,6 +165,13 @@ type ExternalDependencyT struct {
 	Path                string `yaml:"path"`
 	CreateInProjectLink bool   `yaml:"create_in_project_link"`
 	LinkName            string `yaml:"link_name"`
+	// Git is the repository URL of the dependency fetched by 'ergomcutool deps fetch',
+	// 'path' must be empty in this case
+	Git string `yaml:"git,omitempty"`
+	// Ref is the branch, tag or commit, the default branch if empty
+	Ref string `yaml:"ref,omitempty"`
+	// Commit is the locked commit of the git dependency
+	Commit string `yaml:"-"`
 }
 
 // MergeSpecial merges two external dependencies.
@@ -132,7 +180,8 @@ type ExternalDependencyT struct {
 // 'Var' fields are supposed to be equal and are not modified.
 // 'projectSetting.CreateInProjectLink' is only modified if
 // 'configSetting.CreateInProjectLink==true'
-// Precedence has: 'configSetting.Path',
+// Precedence has: 'configSetting.Path', it replaces the git repository
+// of the project dependency, e.g. with a local working copy.
 // 'projectSetting.LinkName' is only modified if
 // 'configSetting.LinkName' is not empty.
 func (projectSetting *ExternalDependencyT) MergeSpecial(
@@ -145,19 +194,28 @@ func (projectSetting *ExternalDependencyT) MergeSpecial(
 	}
 	if configSetting.Path != "" {
 		projectSetting.Path = configSetting.Path
+		projectSetting.Git = ""
+		projectSetting.Ref = ""
+		projectSetting.Commit = ""
 	}
 	if configSetting.LinkName != "" {
 		projectSetting.LinkName = configSetting.LinkName
 	}
 }
 
-// Validate validates the external dependency.
-func (g *ExternalDependencyT) Validate() error {
+// Validate validates the external dependency, relative paths are
+// relative to the project root. Warnings are printed to the logger.
+func (g *ExternalDependencyT) Validate(root string, logger *log.Logger) error {
 
 	if g.Var == "" {
 		return fmt.Errorf("external_dependencies:'var' parameter is not defined")
 	}
 
+	if g.Git != "" && g.Path == "" {
+		// Not fetched yet, update-project reports it
+		return nil
+	}
+
 	if g.Path == "" {
 		return fmt.Errorf("external_dependencies:'path' parameter is not defined for %q",
 			g.Var)
@@ -174,9 +232,13 @@ func (g *ExternalDependencyT) Validate() error {
 		}
 	}
 
-	exists := utils.DirExists(g.Path)
-	if !exists {
-		log.Printf("%s external_dependencies:'path' %q must specify an existing directory.%s\n",
+	path := g.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	exists := utils.DirExists(path)
+	if !exists && g.Git == "" {
+		logger.Printf("%s external_dependencies:'path' %q must specify an existing directory.%s\n",
 			toolConfigWarningPrefix, g.Path, toolConfigWarningSuffix)
 	}
 	return nil
@@ -203,6 +265,8 @@ type IntellisenseT struct {
 	// Skip adding source directories to VSCode intellisense
 	// ("C_Cpp_Runner.includePaths" in .vscode/settings.json)
 	SkipAddingSourceDirectories bool `yaml:"skip_adding_source_directories"`
+	// Skip generating 'compile_commands.json' in the project root
+	SkipCompileCommands bool `yaml:"skip_compile_commands"`
 }
 
 // Validate validates the IntellisenseT options.
@@ -223,12 +287,16 @@ func (g *ToolConfigT) String() string {
 	return string(data)
 }
 
-// readConfigFile reads user or local configuration file into **config**
-func readConfigFile(file string, config *ToolConfigT) error {
+// readConfigFile reads user or local configuration file into **config**,
+// the warning about an outdated file is printed to the logger.
+func readConfigFile(file string, config *ToolConfigT, logger *log.Logger) error {
 	data, err := os.ReadFile(file)
 	if err != nil {
 		return err
 	}
+	if err = CheckVersion(file, data, logger); err != nil {
+		return err
+	}
 
 	err = yaml.Unmarshal(data, config)
 	if err != nil {
@@ -238,12 +306,23 @@ func readConfigFile(file string, config *ToolConfigT) error {
 	return err
 }
 
+// ReadExternalDependencies reads the external dependencies
+// from the configuration file. Returns nil if the file doesn't exist.
+func ReadExternalDependencies(file string) ([]ExternalDependencyT, error) {
+	c := &ToolConfigT{}
+	err := readConfigFile(file, c, log.New(io.Discard, "", 0))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return c.ExternalDependencies, err
+}
+
 // copyTextFileEx copies text file from src to dest, prepends optional prefix
 // to each line.
 func copyTextFileEx(src, dest string, prefix string, filePerm uint32) error {
 	data, err := os.ReadFile(src)
 	if err != nil {
-		log.Fatalf("failed to read file %q: %v\n", src, err)
+		return fmt.Errorf("failed to read file %q: %w", src, err)
 	}
 	if prefix != "" {
 		var sb strings.Builder
@@ -288,19 +367,50 @@ func CheckUserConfigDirExists() bool {
 }
 
 // CreateUserConfig creates user config directory and its contents.
+// The toolchain and openocd found on the machine are written
+// into the user config, the newest ones are used unless 'interactive' is true
+// and the user chooses another candidate.
 // If the directory already exists, it returns error.
-func CreateUserConfig() error {
+func CreateUserConfig(interactive bool) error {
 	if utils.DirExists(UserConfigDir) {
 		return fmt.Errorf("user configuration directory already exists")
 	}
 	// Copy assets from the embedded FS
 	copyAssetsIntoUserConfigDir()
-	return nil
+
+	settings := detectedSettings(interactive)
+	if len(settings) == 0 {
+		return nil
+	}
+	// Not UserConfigFilePath: the user configuration directory is initialized
+	path := filepath.Join(UserConfigDir, UserConfigFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if data, err = SetYamlValue(data, k, settings[k]); err != nil {
+			return fmt.Errorf("failed to set %q: %w", k, err)
+		}
+	}
+	return os.WriteFile(path, data, fs.FileMode(DefaultFilePermissions))
 }
 
 // EnsureUserConfigExists checks that ergomcutool user config directory
-// exists. If not, it prints error message and exists with an error.
+// exists, or the user configuration file if it is replaced by '--config'.
+// If not, it prints error message and exists with an error.
 func EnsureUserConfigExists() {
+	if DefaultOverrides.UserConfigFile != "" {
+		if !utils.FileExists(DefaultOverrides.UserConfigFile) {
+			log.Fatalf("error: user configuration file %q doesn't exist.\n", DefaultOverrides.UserConfigFile)
+		}
+		return
+	}
 	if !utils.DirExists(UserConfigDir) {
 		log.Fatalf(`error: ergomcutool is not initialized yet.
 Run 'ergomcutool init' first.
@@ -308,91 +418,143 @@ Run 'ergomcutool init' first.
 	}
 }
 
-// ParseErgomcutoolConfig parses ergomcutool configuration,
-// both user and local configurations are taken into account.
-// 'createLocalConfigIfNotExists': if true, creates a local configuration
-// file in CWD that is a commented-out copy of the current user configuration.
-func ParseErgomcutoolConfig(createLocalConfigIfNotExists bool) {
-	// Read user config file
-	userConfigFilePath := filepath.Join(UserConfigDir, UserConfigFileName)
-	err := readConfigFile(userConfigFilePath, ToolConfig)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Fatalf("error: ergomcutool configuration file doesn't exist, please run 'ergomcutool init' first.\n")
-		} else {
-			log.Fatalf("error: failed to read user configuration file: %+v\n", err)
-		}
-	}
+// ErrNotInitialized is returned if the user configuration file doesn't exist.
+var ErrNotInitialized = errors.New("ergomcutool is not initialized")
 
-	localConfigFilePath := filepath.Join("_non_persistent", UserConfigFileName)
-	// Override with values taken from local config file
-	err = readConfigFile(localConfigFilePath, ToolConfig)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			if createLocalConfigIfNotExists {
-				// Write a default configuration file
-				// Check if path exists
-				dirPath := filepath.Dir(localConfigFilePath)
-				if err = os.MkdirAll(dirPath, fs.FileMode(DefaultDirPermissions)); err != nil {
-					log.Fatalf("Failed to create local directory %q: %v\n",
-						dirPath, err)
-				}
-				if err = copyTextFileEx(
-					userConfigFilePath,
-					localConfigFilePath, "", // "# "
-					DefaultFilePermissions); err != nil {
-					log.Fatalf("Failed to write local configuration to file %q: %v\n",
-						localConfigFilePath, err)
-				}
-
-				// Re-read file
-				if err = readConfigFile(localConfigFilePath, ToolConfig); err != nil {
-					log.Fatalf("Failed to read local configuration file: %+v\n", err)
-				}
-			}
-		} else {
-			log.Fatalf("Failed to read local configuration file: %+v\n", err)
-		}
-	}
+// ValidationError is returned if the configuration is invalid.
+type ValidationError struct {
+	Err error
+}
 
-	// Override with values taken from environment variables
-	// Currently, no variables are used
+func (e *ValidationError) Error() string {
+	return "configuration validation failed: " + e.Err.Error()
+}
 
-	// Override with values taken from CLI
-	// Currently, no CLI variables are used
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
 
-	// Validate
-	msgPrefix := "error: ergomcutool configuration validation failed:"
-	msgSuffix := "Fix the configuration errors and try again."
-	if ToolConfig.General == nil {
-		log.Fatalf("%s 'general' section is missing.\n%s\n", msgPrefix, msgSuffix)
+// Validate validates the configuration, warnings are printed to the logger.
+// External dependencies are validated with the project.
+func (c *ToolConfigT) Validate(logger *log.Logger) error {
+	if c.General == nil {
+		return &ValidationError{fmt.Errorf("'general' section is missing")}
 	}
-	if err = ToolConfig.General.Validate(); err != nil {
-		log.Fatalf("%s %v.\n%s\n",
-			msgPrefix, err, msgSuffix)
+	if err := c.General.Validate(logger); err != nil {
+		return &ValidationError{err}
 	}
-
-	if ToolConfig.Openocd == nil {
-		log.Fatalf("%s 'openocd' section is missing.\n%s\n", msgPrefix, msgSuffix)
+	if c.Openocd == nil {
+		return &ValidationError{fmt.Errorf("'openocd' section is missing")}
 	}
-	if err = ToolConfig.Openocd.Validate(); err != nil {
-		log.Fatalf("%s %v.\n%s\n",
-			msgPrefix, err, msgSuffix)
+	if err := c.Openocd.Validate(logger); err != nil {
+		return &ValidationError{err}
 	}
-
-	if ToolConfig.BuildOptions != nil {
-		err = ToolConfig.BuildOptions.Validate()
-		if err != nil {
-			log.Fatalf("%s 'build_options' validation failed: %v.\n%s\n", msgPrefix, err, msgSuffix)
+	if c.BuildOptions != nil {
+		if err := c.BuildOptions.Validate(); err != nil {
+			return &ValidationError{fmt.Errorf("'build_options' validation failed: %w", err)}
 		}
 	}
+	if err := c.Intellisense.Validate(); err != nil {
+		return &ValidationError{fmt.Errorf("'intellisense' validation failed: %w", err)}
+	}
+	return nil
+}
+
+// Load reads the user configuration from userConfigDir and overrides it
+// with the local configuration of the project in projectDir.
+// 'createLocal': if true, creates the local configuration file
+// as a copy of the user configuration if it doesn't exist.
+// DefaultOverrides are applied over the configuration files.
+// Returns ErrNotInitialized if the user configuration doesn't exist
+// and *ValidationError if the configuration is invalid.
+func Load(userConfigDir, projectDir string, createLocal bool, logger *log.Logger) (*ToolConfigT, error) {
+	return LoadWithOverrides(userConfigDir, projectDir, createLocal, DefaultOverrides, logger)
+}
 
+// LoadWithOverrides is Load with the overrides applied instead of DefaultOverrides.
+func LoadWithOverrides(userConfigDir, projectDir string, createLocal bool,
+	overrides *Overrides, logger *log.Logger) (*ToolConfigT, error) {
+	c, err := load(userConfigDir, projectDir, createLocal, overrides, logger)
+	if err != nil {
+		return nil, err
+	}
 	// Do not validate external dependencies here,
 	// they should be validated in the update-project cmd
+	if err = c.Validate(logger); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-	// Validate intellisense
-	err = ToolConfig.Intellisense.Validate()
+// LoadUnvalidated is LoadWithOverrides without creating the local
+// configuration and without validating the result, the settings missing
+// in all the layers are nil. It lets the caller report each invalid setting.
+func LoadUnvalidated(userConfigDir, projectDir string, overrides *Overrides,
+	logger *log.Logger) (*ToolConfigT, error) {
+	return load(userConfigDir, projectDir, false, overrides, logger)
+}
+
+// load reads the configuration layers and applies the overrides.
+func load(userConfigDir, projectDir string, createLocal bool,
+	overrides *Overrides, logger *log.Logger) (*ToolConfigT, error) {
+	c := &ToolConfigT{}
+	userConfigFilePath := UserConfigFile(userConfigDir, overrides)
+	err := readConfigFile(userConfigFilePath, c, logger)
+	if errors.Is(err, os.ErrNotExist) {
+		if overrides.UserConfigFile != "" {
+			return nil, fmt.Errorf("user configuration file %q doesn't exist", userConfigFilePath)
+		}
+		return nil, ErrNotInitialized
+	}
 	if err != nil {
-		log.Fatalf("%s 'intellisense' validation failed: %v.\n%s\n", msgPrefix, err, msgSuffix)
+		return nil, fmt.Errorf("failed to read user configuration file: %w", err)
+	}
+
+	// Override with values taken from local config file
+	localConfigFilePath := filepath.Join(projectDir, LocalConfigFilePath)
+	err = readConfigFile(localConfigFilePath, c, logger)
+	if errors.Is(err, os.ErrNotExist) && createLocal {
+		// Write a default configuration file
+		dirPath := filepath.Dir(localConfigFilePath)
+		if err = os.MkdirAll(dirPath, fs.FileMode(DefaultDirPermissions)); err != nil {
+			return nil, fmt.Errorf("failed to create local directory %q: %w", dirPath, err)
+		}
+		if err = copyTextFileEx(userConfigFilePath, localConfigFilePath, "", // "# "
+			DefaultFilePermissions); err != nil {
+			return nil, fmt.Errorf("failed to write local configuration to file %q: %w",
+				localConfigFilePath, err)
+		}
+		err = readConfigFile(localConfigFilePath, c, logger)
 	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read local configuration file: %w", err)
+	}
+
+	// Override with values taken from environment variables and CLI
+	if err = overrides.Apply(c, logger); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseErgomcutoolConfig parses ergomcutool configuration of the project in CWD
+// into ToolConfig, both user and local configurations are taken into account.
+// 'createLocalConfigIfNotExists': if true, creates a local configuration
+// file in CWD that is a commented-out copy of the current user configuration.
+// It doesn't return error but exits instead.
+func ParseErgomcutoolConfig(createLocalConfigIfNotExists bool) {
+	c, err := Load(UserConfigDir, ".", createLocalConfigIfNotExists, log.Default())
+	var validationErr *ValidationError
+	switch {
+	case errors.Is(err, ErrNotInitialized):
+		log.Fatalf("error: ergomcutool configuration file doesn't exist, please run 'ergomcutool init' first.\n")
+	case errors.Is(err, ErrNewerVersion):
+		log.Fatalf("error: %v.\nPlease upgrade ergomcutool.\n", err)
+	case errors.As(err, &validationErr):
+		log.Fatalf("error: ergomcutool configuration validation failed: %v.\nFix the configuration errors and try again.\n",
+			validationErr.Err)
+	case err != nil:
+		log.Fatalf("error: %v\n", err)
+	}
+	ToolConfig = c
 }
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// ToolchainSearchPatterns are the glob patterns of the 'bin' directories
+// of the ARM GNU toolchains installed outside of PATH.
+var ToolchainSearchPatterns = []string{
+	"/opt/gcc-arm-none-eabi-*/bin",
+	"/opt/arm-gnu-toolchain-*/bin",
+	"/usr/local/gcc-arm-none-eabi-*/bin",
+	"/opt/xpack-arm-none-eabi-gcc-*/bin",
+	"~/opt/xPacks/arm-none-eabi-gcc/*/bin",
+	"~/.local/xPacks/@xpack-dev-tools/arm-none-eabi-gcc/*/.content/bin",
+	// Toolchains bundled with STM32CubeIDE
+	"/opt/st/stm32cubeide_*/plugins/com.st.stm32cube.ide.mcu.externaltools.gnu-tools-for-stm32.*/tools/bin",
+	"~/st/stm32cubeide_*/plugins/com.st.stm32cube.ide.mcu.externaltools.gnu-tools-for-stm32.*/tools/bin",
+}
+
+// OpenocdSearchPatterns are the glob patterns of the openocd executables
+// installed outside of PATH.
+var OpenocdSearchPatterns = []string{
+	"/opt/openocd*/bin/openocd",
+	"/opt/xpack-openocd-*/bin/openocd",
+	"~/opt/xPacks/openocd/*/bin/openocd",
+	"~/.local/xPacks/@xpack-dev-tools/openocd/*/.content/bin/openocd",
+	"/opt/st/stm32cubeide_*/plugins/com.st.stm32cube.ide.mcu.externaltools.openocd.*/tools/bin/openocd",
+	"~/st/stm32cubeide_*/plugins/com.st.stm32cube.ide.mcu.externaltools.openocd.*/tools/bin/openocd",
+}
+
+// openocdScriptsDirs are the scripts directories relative to the openocd executable,
+// followed by the system-wide ones.
+var openocdScriptsDirs = []string{
+	"../share/openocd/scripts",
+	"../openocd/scripts",
+	"../scripts",
+	"/usr/share/openocd/scripts",
+	"/usr/local/share/openocd/scripts",
+}
+
+// probeTimeout limits the duration of each version probe.
+var probeTimeout = 5 * time.Second
+
+// ToolchainCandidate is a detected ARM GNU toolchain.
+type ToolchainCandidate struct {
+	BinDir      string
+	CCompiler   string
+	CppCompiler string
+	// Debugger is 'arm-none-eabi-gdb' of the toolchain if it exists,
+	// 'gdb-multiarch' from PATH otherwise, empty if neither is found.
+	Debugger string
+	Version  string
+}
+
+// OpenocdCandidate is a detected openocd installation.
+type OpenocdCandidate struct {
+	BinPath string
+	// ScriptsPath is empty if the scripts directory wasn't found
+	ScriptsPath string
+	Version     string
+}
+
+var versionRe = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// probeVersion runs the binary with the argument and returns
+// the first version number found in its output.
+func probeVersion(bin, arg string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, arg).CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		if v := versionRe.FindString(scanner.Text()); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// CompareVersions compares the dotted version numbers,
+// e.g. '10.3.1' < '13.2'. Empty versions are the oldest.
+func CompareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		na, nb := -1, -1
+		if i < len(pa) {
+			if n, err := strconv.Atoi(pa[i]); err == nil {
+				na = n
+			}
+		}
+		if i < len(pb) {
+			if n, err := strconv.Atoi(pb[i]); err == nil {
+				nb = n
+			}
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// searchPaths returns the paths matching the patterns, '~' is expanded.
+func searchPaths(patterns []string) []string {
+	r := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		expanded, err := homedir.Expand(p)
+		if err != nil {
+			continue
+		}
+		matches, _ := filepath.Glob(expanded)
+		r = append(r, matches...)
+	}
+	return r
+}
+
+// isExecutable returns true if the path is an executable file.
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode().Perm()&0o111 != 0
+}
+
+// uniqueKey returns the path with the symlinks resolved,
+// so that the same installation is only listed once.
+func uniqueKey(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// DetectToolchains returns the ARM GNU toolchains found in PATH
+// and in ToolchainSearchPatterns, the newest first.
+func DetectToolchains() []ToolchainCandidate {
+	dirs := make([]string, 0, 10)
+	if gcc, err := exec.LookPath("arm-none-eabi-gcc"); err == nil {
+		dirs = append(dirs, filepath.Dir(gcc))
+	}
+	dirs = append(dirs, searchPaths(ToolchainSearchPatterns)...)
+
+	gdbMultiarch, _ := exec.LookPath("gdb-multiarch")
+	r := make([]ToolchainCandidate, 0, len(dirs))
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		gcc := filepath.Join(dir, "arm-none-eabi-gcc")
+		if !isExecutable(gcc) || seen[uniqueKey(gcc)] {
+			continue
+		}
+		seen[uniqueKey(gcc)] = true
+		c := ToolchainCandidate{
+			BinDir:      dir,
+			CCompiler:   gcc,
+			CppCompiler: filepath.Join(dir, "arm-none-eabi-g++"),
+			Debugger:    gdbMultiarch,
+			Version:     probeVersion(gcc, "-dumpversion"),
+		}
+		if gdb := filepath.Join(dir, "arm-none-eabi-gdb"); isExecutable(gdb) {
+			c.Debugger = gdb
+		}
+		r = append(r, c)
+	}
+	sort.SliceStable(r, func(i, j int) bool { return CompareVersions(r[i].Version, r[j].Version) > 0 })
+	return r
+}
+
+// DetectOpenocd returns the openocd installations found in PATH
+// and in OpenocdSearchPatterns, the newest first.
+func DetectOpenocd() []OpenocdCandidate {
+	bins := make([]string, 0, 10)
+	if bin, err := exec.LookPath("openocd"); err == nil {
+		bins = append(bins, bin)
+	}
+	bins = append(bins, searchPaths(OpenocdSearchPatterns)...)
+
+	r := make([]OpenocdCandidate, 0, len(bins))
+	seen := map[string]bool{}
+	for _, bin := range bins {
+		if !isExecutable(bin) || seen[uniqueKey(bin)] {
+			continue
+		}
+		seen[uniqueKey(bin)] = true
+		c := OpenocdCandidate{BinPath: bin, Version: probeVersion(bin, "--version")}
+		// The scripts are located relative to the resolved executable
+		binDir := filepath.Dir(uniqueKey(bin))
+		for _, dir := range openocdScriptsDirs {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(binDir, dir)
+			}
+			if info, err := os.Stat(filepath.Join(dir, "interface")); err == nil && info.IsDir() {
+				c.ScriptsPath = dir
+				break
+			}
+		}
+		r = append(r, c)
+	}
+	sort.SliceStable(r, func(i, j int) bool { return CompareVersions(r[i].Version, r[j].Version) > 0 })
+	return r
+}
+
+// chooseCandidate returns the index of the candidate selected by the user,
+// 0 (the newest) by default or if 'interactive' is false.
+func chooseCandidate(what string, descriptions []string, interactive bool) int {
+	if len(descriptions) == 0 {
+		return -1
+	}
+	fmt.Printf("Detected %s:\n", what)
+	for i, d := range descriptions {
+		fmt.Printf("  %d) %s\n", i+1, d)
+	}
+	if !interactive || len(descriptions) == 1 {
+		fmt.Printf("Using %d) %s\n", 1, descriptions[0])
+		return 0
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Choose one [1-%d, default 1]: ", len(descriptions))
+		text, err := reader.ReadString('\n')
+		text = strings.TrimSpace(text)
+		if n, convErr := strconv.Atoi(text); convErr == nil && n >= 1 && n <= len(descriptions) {
+			return n - 1
+		}
+		if text == "" || err != nil {
+			return 0
+		}
+	}
+}
+
+func versionOrUnknown(v string) string {
+	if v == "" {
+		return "unknown version"
+	}
+	return v
+}
+
+// detectedSettings detects the toolchain and openocd and returns
+// the settings of the user configuration to be replaced.
+// If 'interactive' is true, the user chooses from the found candidates.
+func detectedSettings(interactive bool) map[string]string {
+	r := map[string]string{}
+	toolchains := DetectToolchains()
+	descriptions := make([]string, 0, len(toolchains))
+	for _, c := range toolchains {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", c.BinDir, versionOrUnknown(c.Version)))
+	}
+	if i := chooseCandidate("ARM toolchains", descriptions, interactive); i >= 0 {
+		c := toolchains[i]
+		r["general.arm_toolchain_path"] = c.BinDir
+		r["general.c_compiler_path"] = c.CCompiler
+		r["general.cpp_compiler_path"] = c.CppCompiler
+		if c.Debugger != "" {
+			r["general.debugger_path"] = c.Debugger
+		}
+	} else {
+		fmt.Printf("No ARM toolchain found, edit %q to configure it.\n", UserConfigFilePath)
+	}
+
+	openocds := DetectOpenocd()
+	descriptions = descriptions[:0]
+	for _, c := range openocds {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", c.BinPath, versionOrUnknown(c.Version)))
+	}
+	if i := chooseCandidate("openocd installations", descriptions, interactive); i >= 0 {
+		c := openocds[i]
+		r["openocd.bin_path"] = c.BinPath
+		if c.ScriptsPath != "" {
+			r["openocd.scripts_path"] = c.ScriptsPath
+		}
+	} else {
+		fmt.Printf("No openocd found, edit %q to configure it.\n", UserConfigFilePath)
+	}
+	return r
+}
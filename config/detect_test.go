@@ -0,0 +1,98 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		r    int
+	}{
+		{"10.3.1", "13.2", -1},
+		{"13.2", "10.3.1", 1},
+		{"13.2", "13.2.0", -1},
+		{"13.2.0", "13.2.0", 0},
+		{"9.2", "10.1", -1},
+		// Empty and unknown versions are the oldest
+		{"", "1.0", -1},
+		{"1.0", "", 1},
+		{"", "", 0},
+		{"unknown", "0.1", -1},
+		{"unknown", "", 0},
+	} {
+		require.Equal(t, tc.r, CompareVersions(tc.a, tc.b), "%q vs %q", tc.a, tc.b)
+	}
+}
+
+// writeExecutable writes a shell script printing the version.
+func writeExecutable(t *testing.T, path, version string) {
+	require.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.Nil(t, os.WriteFile(path, []byte("#!/bin/sh\necho "+version+"\n"), 0o755))
+}
+
+func TestDetectToolchains(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not executable on windows")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", t.TempDir())
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	root := t.TempDir()
+	writeExecutable(t, filepath.Join(root, "gcc-10", "bin", "arm-none-eabi-gcc"), "10.3.1")
+	writeExecutable(t, filepath.Join(home, "xpack", "13.2.1", "bin", "arm-none-eabi-gcc"), "13.2.1")
+	writeExecutable(t, filepath.Join(home, "xpack", "13.2.1", "bin", "arm-none-eabi-gdb"), "13.2")
+	writeExecutable(t, filepath.Join(root, "broken", "bin", "arm-none-eabi-gcc"), "")
+	// Not executable
+	require.Nil(t, os.MkdirAll(filepath.Join(root, "noexec", "bin"), 0o755))
+	require.Nil(t, os.WriteFile(filepath.Join(root, "noexec", "bin", "arm-none-eabi-gcc"), nil, 0o644))
+	// The same installation through a symlink is listed once
+	require.Nil(t, os.Symlink(filepath.Join(root, "gcc-10"), filepath.Join(root, "gcc-link")))
+
+	old := ToolchainSearchPatterns
+	defer func() { ToolchainSearchPatterns = old }()
+	ToolchainSearchPatterns = []string{filepath.Join(root, "*", "bin"), "~/xpack/*/bin"}
+
+	paths := searchPaths(ToolchainSearchPatterns)
+	require.Len(t, paths, 5)
+	require.Contains(t, paths, filepath.Join(home, "xpack", "13.2.1", "bin"))
+
+	r := DetectToolchains()
+	require.Len(t, r, 3)
+	require.Equal(t, "13.2.1", r[0].Version)
+	require.Equal(t, filepath.Join(home, "xpack", "13.2.1", "bin", "arm-none-eabi-gdb"), r[0].Debugger)
+	require.Equal(t, filepath.Join(home, "xpack", "13.2.1", "bin", "arm-none-eabi-g++"), r[0].CppCompiler)
+	require.Equal(t, "10.3.1", r[1].Version)
+	require.Equal(t, filepath.Join(root, "gcc-10", "bin"), r[1].BinDir)
+	require.Equal(t, "", r[1].Debugger)
+	require.Equal(t, "", r[2].Version)
+	require.Equal(t, filepath.Join(root, "broken", "bin"), r[2].BinDir)
+}
+
+func TestDetectOpenocd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not executable on windows")
+	}
+	t.Setenv("PATH", t.TempDir())
+	root := t.TempDir()
+	writeExecutable(t, filepath.Join(root, "openocd-0.12", "bin", "openocd"), "Open On-Chip Debugger 0.12.0")
+	require.Nil(t, os.MkdirAll(filepath.Join(root, "openocd-0.12", "share", "openocd", "scripts", "interface"), 0o755))
+
+	old := OpenocdSearchPatterns
+	defer func() { OpenocdSearchPatterns = old }()
+	OpenocdSearchPatterns = []string{filepath.Join(root, "openocd*", "bin", "openocd")}
+	r := DetectOpenocd()
+	require.Len(t, r, 1)
+	require.Equal(t, "0.12.0", r[0].Version)
+	require.Equal(t, filepath.Join(root, "openocd-0.12", "bin", "..", "share", "openocd", "scripts"),
+		r[0].ScriptsPath)
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OriginDefault is the origin of the settings not defined by any layer.
+const OriginDefault = "default"
+
+// Setting is a setting of the effective configuration.
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Origin is the layer that defined the value: the configuration file,
+	// the environment variable ('env ERGOMCUTOOL_...'), '--set' or 'default'
+	Origin string `json:"origin"`
+}
+
+// IsOverride returns true if the value comes from an environment variable or '--set'.
+func (s *Setting) IsOverride() bool {
+	return s.Origin == OriginCLI || strings.HasPrefix(s.Origin, OriginEnvPrefix)
+}
+
+// UserConfigFile returns the user configuration file applying the overrides,
+// e.g. UserConfigFile(UserConfigDir, DefaultOverrides) is the file read by Load.
+func UserConfigFile(userConfigDir string, overrides *Overrides) string {
+	if overrides.UserConfigFile != "" {
+		return overrides.UserConfigFile
+	}
+	return filepath.Join(userConfigDir, UserConfigFileName)
+}
+
+// fileOrigins records the file as the origin of the settings it defines.
+func fileOrigins(data []byte, file string, origins map[string]string) error {
+	for _, k := range Keys() {
+		if _, ok, _ := GetYamlValue(data, k); ok {
+			origins[k] = file
+		}
+	}
+	deps := struct {
+		ExternalDependencies []map[string]any `yaml:"external_dependencies"`
+	}{}
+	if err := yaml.Unmarshal(data, &deps); err != nil {
+		return err
+	}
+	// The list replaces the dependencies of the previous files
+	if deps.ExternalDependencies != nil {
+		for k := range origins {
+			if strings.HasPrefix(k, dependenciesKey+".") {
+				delete(origins, k)
+			}
+		}
+	}
+	for _, d := range deps.ExternalDependencies {
+		v, _ := d["var"].(string)
+		for _, f := range dependencyFields {
+			if _, ok := d[f]; ok && v != "" {
+				origins[dependenciesKey+"."+v+"."+f] = file
+			}
+		}
+	}
+	return nil
+}
+
+// Effective loads the configuration like LoadWithOverrides without validating it
+// and returns every setting with the layer that defined its value.
+func Effective(userConfigDir, projectDir string, overrides *Overrides, logger *log.Logger) ([]Setting, error) {
+	c := &ToolConfigT{}
+	origins := map[string]string{}
+	userFile := UserConfigFile(userConfigDir, overrides)
+	for _, file := range []string{userFile, filepath.Join(projectDir, LocalConfigFilePath)} {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			if file != userFile {
+				continue
+			}
+			if overrides.UserConfigFile == "" {
+				return nil, ErrNotInitialized
+			}
+			return nil, fmt.Errorf("user configuration file %q doesn't exist", userFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = CheckVersion(file, data, logger); err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+		}
+		if err = fileOrigins(data, file, origins); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+		}
+	}
+	err := overrides.apply(c, logger, func(key, origin string) { origins[key] = origin })
+	if err != nil {
+		return nil, err
+	}
+
+	keys := Keys()
+	for _, d := range c.ExternalDependencies {
+		for _, f := range dependencyFields {
+			keys = append(keys, dependenciesKey+"."+d.Var+"."+f)
+		}
+	}
+	r := make([]Setting, 0, len(keys))
+	for _, k := range keys {
+		value, err := c.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		origin := origins[k]
+		if origin == "" {
+			origin = OriginDefault
+		}
+		r = append(r, Setting{Key: k, Value: value, Origin: origin})
+	}
+	return r, nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffective(t *testing.T) {
+	userDir := t.TempDir()
+	projectDir := t.TempDir()
+	userFile := filepath.Join(userDir, UserConfigFileName)
+	localFile := filepath.Join(projectDir, LocalConfigFilePath)
+	require.Nil(t, os.WriteFile(userFile, []byte(`general:
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+openocd:
+  interface: stlink.cfg
+  bin_path: /usr/bin
+external_dependencies:
+  - var: MY_LIB
+    path: ~/lib
+    create_in_project_link: true
+  - var: USER_LIB
+    path: ~/user_lib
+`), 0644))
+	require.Nil(t, os.MkdirAll(filepath.Dir(localFile), 0755))
+	require.Nil(t, os.WriteFile(localFile, []byte(`general:
+  cpp_compiler_path: /opt/gcc/bin/arm-none-eabi-g++
+external_dependencies:
+  - var: MY_LIB
+    link_name: lib
+`), 0644))
+
+	o := &Overrides{
+		Env: []string{
+			"ERGOMCUTOOL_OPENOCD_BIN_PATH=/opt/openocd/bin",
+			"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_REF=v1",
+		},
+		Settings: []string{"openocd.interface=jlink.cfg"},
+	}
+	settings, err := Effective(userDir, projectDir, o, log.New(io.Discard, "", 0))
+	require.Nil(t, err)
+	byKey := map[string]Setting{}
+	for _, s := range settings {
+		byKey[s.Key] = s
+	}
+	for _, tc := range []struct {
+		key    string
+		value  string
+		origin string
+	}{
+		{"general.c_compiler_path", "/usr/bin/arm-none-eabi-gcc", userFile},
+		{"general.cpp_compiler_path", "/opt/gcc/bin/arm-none-eabi-g++", localFile},
+		{"general.debugger_path", "", OriginDefault},
+		{"openocd.bin_path", "/opt/openocd/bin", OriginEnvPrefix + "ERGOMCUTOOL_OPENOCD_BIN_PATH"},
+		{"openocd.interface", "jlink.cfg", OriginCLI},
+		// The local list replaces the dependencies of the user configuration
+		{"external_dependencies.MY_LIB.path", "", OriginDefault},
+		{"external_dependencies.MY_LIB.create_in_project_link", "false", OriginDefault},
+		{"external_dependencies.MY_LIB.link_name", "lib", localFile},
+		{"external_dependencies.MY_LIB.ref", "v1", OriginEnvPrefix + "ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_REF"},
+		{"external_dependencies.MY_LIB.git", "", OriginDefault},
+	} {
+		s, ok := byKey[tc.key]
+		require.True(t, ok, tc.key)
+		require.Equal(t, tc.value, s.Value, tc.key)
+		require.Equal(t, tc.origin, s.Origin, tc.key)
+	}
+
+	_, ok := byKey["external_dependencies.USER_LIB.path"]
+	require.False(t, ok)
+
+	// The user configuration file replaced by the overrides
+	overrideFile := filepath.Join(t.TempDir(), "ci.yaml")
+	require.Nil(t, os.WriteFile(overrideFile, []byte(`openocd:
+  interface: cmsis-dap.cfg
+external_dependencies:
+  - var: CI_LIB
+    path: /ci/lib
+`), 0644))
+	settings, err = Effective(t.TempDir(), t.TempDir(), &Overrides{UserConfigFile: overrideFile, Env: []string{}},
+		log.New(io.Discard, "", 0))
+	require.Nil(t, err)
+	byKey = map[string]Setting{}
+	for _, s := range settings {
+		byKey[s.Key] = s
+	}
+	require.Equal(t, Setting{"openocd.interface", "cmsis-dap.cfg", overrideFile}, byKey["openocd.interface"])
+	require.Equal(t, Setting{"external_dependencies.CI_LIB.path", "/ci/lib", overrideFile},
+		byKey["external_dependencies.CI_LIB.path"])
+	require.Equal(t, Setting{"external_dependencies.CI_LIB.link_name", "", OriginDefault},
+		byKey["external_dependencies.CI_LIB.link_name"])
+
+	_, err = Effective(t.TempDir(), projectDir, &Overrides{Env: []string{}}, log.New(io.Discard, "", 0))
+	require.ErrorIs(t, err, ErrNotInitialized)
+	_, err = Effective(userDir, projectDir, &Overrides{UserConfigFile: filepath.Join(userDir, "missing.yaml")},
+		log.New(io.Discard, "", 0))
+	require.NotNil(t, err)
+}
+
+func TestLoadUnvalidated(t *testing.T) {
+	userDir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(userDir, UserConfigFileName), []byte(`general:
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc
+build_options:
+  debug: "2"
+`), 0644))
+	logger := log.New(io.Discard, "", 0)
+	o := &Overrides{Env: []string{"ERGOMCUTOOL_OPENOCD_INTERFACE=stlink.cfg"}}
+	_, err := LoadWithOverrides(userDir, t.TempDir(), false, o, logger)
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+
+	// The missing settings are nil, the invalid ones are kept
+	c, err := LoadUnvalidated(userDir, t.TempDir(), o, logger)
+	require.Nil(t, err)
+	require.Equal(t, "/usr/bin/arm-none-eabi-gcc", *c.General.CCompilerPath)
+	require.Nil(t, c.General.ArmToolchainPath)
+	require.Equal(t, "stlink.cfg", *c.Openocd.Interface)
+	require.Nil(t, c.Openocd.BinPath)
+	require.Equal(t, "2", *c.BuildOptions.Debug)
+
+	_, err = LoadUnvalidated(t.TempDir(), t.TempDir(), o, logger)
+	require.ErrorIs(t, err, ErrNotInitialized)
+}
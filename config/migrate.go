@@ -0,0 +1,266 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/changes"
+	"github.com/mcu-art/ergomcutool/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// VersionKey is the schema version of the configuration and project files.
+const VersionKey = "ergomcutool_version"
+
+// FileSchemaVersion is the schema version of the configuration and project files
+// written by this ergomcutool. Unlike Version, it only changes
+// when a migration step is added.
+const FileSchemaVersion = "1.1.0"
+
+// defaultSchemaVersion is the version of the files that don't specify it.
+const defaultSchemaVersion = "1.0.0"
+
+// ErrNewerVersion is returned if the file was written by a newer ergomcutool.
+var ErrNewerVersion = errors.New("the file was written by a newer version of ergomcutool")
+
+// MigrationStep migrates a YAML document to the schema of the version.
+type MigrationStep struct {
+	// Version is the schema version that the step migrates to
+	Version     string
+	Description string
+	// Migrate edits the document, the comments must be preserved
+	Migrate func(data []byte) ([]byte, error)
+}
+
+// Migration is the migration of a file.
+type Migration struct {
+	Path        string
+	FromVersion string
+	ToVersion   string
+	// Steps are the descriptions of the applied steps
+	Steps []string
+	Old   []byte
+	New   []byte
+}
+
+// Needed returns true if the migration changes the file.
+func (m *Migration) Needed() bool {
+	return !bytes.Equal(m.Old, m.New)
+}
+
+var headerVersionRe = regexp.MustCompile(`ergomcutool v(\d+(\.\d+)+)`)
+
+// SchemaVersion returns the version of the document: the 'ergomcutool_version' value,
+// the version in the header comment of the configuration files written
+// before the key was added, or 1.0.0.
+func SchemaVersion(data []byte) (string, error) {
+	v, ok, err := GetYamlValue(data, VersionKey)
+	if err != nil {
+		return "", err
+	}
+	if ok && v != "" {
+		return v, nil
+	}
+	if m := headerVersionRe.FindSubmatch(data); m != nil {
+		return string(m[1]), nil
+	}
+	return defaultSchemaVersion, nil
+}
+
+// CheckVersion checks the schema version of the loaded file against FileSchemaVersion.
+// A warning is printed to the logger if the file is older,
+// ErrNewerVersion is returned if it is newer.
+func CheckVersion(path string, data []byte, logger *log.Logger) error {
+	v, err := SchemaVersion(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	switch c := CompareVersions(v, FileSchemaVersion); {
+	case c > 0:
+		return fmt.Errorf("%q has version %s, ergomcutool supports %s: %w", path, v, FileSchemaVersion,
+			ErrNewerVersion)
+	case c < 0:
+		logger.Printf("warning: %q has version %s, run 'ergomcutool migrate' to update it to %s.\n",
+			path, v, FileSchemaVersion)
+	}
+	return nil
+}
+
+// Migrate applies the steps newer than the version of the document in order
+// and sets the version of the document to 'toVersion'.
+// The steps newer than 'toVersion' are skipped.
+// Returns ErrNewerVersion if the document version is newer than 'toVersion'.
+func Migrate(path string, data []byte, toVersion string, steps []MigrationStep) (*Migration, error) {
+	from, err := SchemaVersion(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	m := &Migration{Path: path, FromVersion: from, ToVersion: toVersion, Old: data, New: data}
+	if CompareVersions(from, toVersion) > 0 {
+		return nil, fmt.Errorf("%q has version %s, ergomcutool is %s: %w", path, from, toVersion, ErrNewerVersion)
+	}
+	ordered := append([]MigrationStep{}, steps...)
+	sort.SliceStable(ordered, func(i, j int) bool { return CompareVersions(ordered[i].Version, ordered[j].Version) < 0 })
+	for _, step := range ordered {
+		if CompareVersions(step.Version, from) <= 0 || CompareVersions(step.Version, toVersion) > 0 {
+			continue
+		}
+		migrated, err := step.Migrate(m.New)
+		if err != nil {
+			return nil, fmt.Errorf("%q: migration to %s (%s) failed: %w", path, step.Version, step.Description, err)
+		}
+		if !bytes.Equal(migrated, m.New) {
+			m.Steps = append(m.Steps, fmt.Sprintf("%s: %s", step.Version, step.Description))
+		}
+		m.New = migrated
+	}
+	if from != toVersion {
+		if _, ok, _ := GetYamlValue(m.New, VersionKey); ok {
+			if m.New, err = SetYamlValue(m.New, VersionKey, toVersion); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// MigrateFile migrates the file, see Migrate.
+func MigrateFile(path, toVersion string, steps []MigrationStep) (*Migration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Migrate(path, data, toVersion, steps)
+}
+
+// Write backs up the original file into the backup directory
+// and writes the migrated one. Returns the path to the backup.
+func (m *Migration) Write(backupDir string) (string, error) {
+	if !m.Needed() {
+		return "", nil
+	}
+	backup, err := utils.BackupFile(m.Path, backupDir, filepath.Base(m.Path),
+		MigrationBackupsLimit, false, DefaultDirPermissions)
+	if err != nil {
+		return "", err
+	}
+	// The file is replaced atomically and keeps its permissions
+	_, err = changes.New(false, DefaultDirPermissions, DefaultFilePermissions).WriteFile(m.Path, m.New)
+	return backup, err
+}
+
+// RenameKeyStep returns the step renaming the dotted key,
+// only the last key component is renamed, e.g. 'openocd.interface' to 'adapter'.
+func RenameKeyStep(version, key, newName string) MigrationStep {
+	return MigrationStep{
+		Version:     version,
+		Description: fmt.Sprintf("rename %q to %q", key, newName),
+		Migrate: func(data []byte) ([]byte, error) {
+			return RenameYamlKey(data, key, newName)
+		},
+	}
+}
+
+// AddSectionStep returns the step appending the top-level section
+// if the document doesn't have it. 'text' is the section with its comments.
+func AddSectionStep(version, section, text string) MigrationStep {
+	return MigrationStep{
+		Version:     version,
+		Description: fmt.Sprintf("add the %q section", section),
+		Migrate: func(data []byte) ([]byte, error) {
+			if _, exists := topLevelKey(data, section); exists {
+				return data, nil
+			}
+			s := strings.TrimRight(string(data), "\n")
+			return []byte(s + "\n\n\n" + strings.TrimRight(text, "\n") + "\n"), nil
+		},
+	}
+}
+
+// topLevelKey returns the top-level key node, false if the document doesn't have it.
+func topLevelKey(data []byte, key string) (*yaml.Node, bool) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 ||
+		doc.Content[0].Kind != yaml.MappingNode {
+		return nil, false
+	}
+	k, _ := findKey(doc.Content[0], key)
+	return k, k != nil
+}
+
+// RenameYamlKey renames the last component of the dotted key in the document,
+// only the key itself is changed. The document is returned unchanged
+// if it doesn't have the key.
+func RenameYamlKey(data []byte, key, newName string) ([]byte, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+	n := doc.Content[0]
+	var keyNode *yaml.Node
+	path := strings.Split(key, ".")
+	for i, k := range path {
+		if n.Kind != yaml.MappingNode {
+			return data, nil
+		}
+		if i == len(path)-1 {
+			if other, _ := findKey(n, newName); other != nil {
+				return nil, fmt.Errorf("both %q and %q exist", key, newName)
+			}
+		}
+		if keyNode, n = findKey(n, k); keyNode == nil {
+			return data, nil
+		}
+	}
+	lines := strings.Split(string(data), "\n")
+	line := lines[keyNode.Line-1]
+	start := keyNode.Column - 1
+	if !strings.HasPrefix(line[start:], keyNode.Value) {
+		return nil, fmt.Errorf("%q: quoted keys are not supported", key)
+	}
+	lines[keyNode.Line-1] = line[:start] + newName + line[start+len(keyNode.Value):]
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// Migrations are the migration steps of the configuration files.
+// The configuration files written before 1.1.0 only have the version
+// in the header comment.
+var Migrations = []MigrationStep{
+	{
+		Version:     "1.1.0",
+		Description: fmt.Sprintf("add the %q key", VersionKey),
+		Migrate: func(data []byte) ([]byte, error) {
+			if _, exists := topLevelKey(data, VersionKey); exists {
+				return data, nil
+			}
+			// Insert the key after the header comment
+			lines := strings.Split(string(data), "\n")
+			at := 0
+			for at < len(lines) && strings.HasPrefix(lines[at], "#") {
+				at++
+			}
+			key := []string{"# Version of ergomcutool that created this file", VersionKey + ": 1.1.0"}
+			if at == 0 {
+				key = append(key, "")
+			} else {
+				key = append([]string{""}, key...)
+			}
+			return []byte(strings.Join(insertLines(lines, at, key...), "\n")), nil
+		},
+	},
+}
+
+// MigrateConfigFile migrates the configuration file to FileSchemaVersion.
+func MigrateConfigFile(path string) (*Migration, error) {
+	return MigrateFile(path, FileSchemaVersion, Migrations)
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	data := []byte(`# Project file
+
+# Version of ergomcutool that created this file
+ergomcutool_version: 1.0.0
+
+openocd:
+  # The debug adapter
+  interface: stlink.cfg # inline comment
+`)
+	steps := []MigrationStep{
+		AddSectionStep("1.2.0", "images", "# Firmware images\nimages: []\n"),
+		RenameKeyStep("1.1.0", "openocd.interface", "adapter"),
+		RenameKeyStep("1.3.0", "openocd.adapter", "probe"),
+	}
+	m, err := Migrate("p.yaml", data, "1.2.0", steps)
+	require.Nil(t, err)
+	require.True(t, m.Needed())
+	require.Equal(t, "1.0.0", m.FromVersion)
+	require.Equal(t, 2, len(m.Steps))
+	require.Equal(t, `# Project file
+
+# Version of ergomcutool that created this file
+ergomcutool_version: 1.2.0
+
+openocd:
+  # The debug adapter
+  adapter: stlink.cfg # inline comment
+
+
+# Firmware images
+images: []
+`, string(m.New))
+
+	// Migrating again changes nothing
+	m, err = Migrate("p.yaml", m.New, "1.2.0", steps)
+	require.Nil(t, err)
+	require.False(t, m.Needed())
+
+	_, err = Migrate("p.yaml", data, "0.9.0", steps)
+	require.ErrorIs(t, err, ErrNewerVersion)
+}
+
+func TestRenameYamlKey(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want string
+		err  string
+	}{
+		{"renamed", "openocd:\n  interface: stlink.cfg # probe\n", "openocd:\n  adapter: stlink.cfg # probe\n", ""},
+		{"missing key", "openocd:\n  target: stm32f1x.cfg\n", "openocd:\n  target: stm32f1x.cfg\n", ""},
+		{"null parent", "openocd:\n", "openocd:\n", ""},
+		{"both keys exist", "openocd:\n  interface: stlink.cfg\n  adapter: jlink.cfg\n", "",
+			`both "openocd.interface" and "adapter" exist`},
+		{"quoted key", "openocd:\n  \"interface\": stlink.cfg\n", "",
+			`"openocd.interface": quoted keys are not supported`},
+	} {
+		data, err := RenameYamlKey([]byte(tc.data), "openocd.interface", "adapter")
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.Nil(t, err, tc.name)
+		require.Equal(t, tc.want, string(data), tc.name)
+	}
+}
+
+func TestMigrateConfigAsset(t *testing.T) {
+	asset, err := os.ReadFile(filepath.Join("..", "assets", "assets", UserConfigFileName))
+	require.Nil(t, err)
+	v, err := SchemaVersion(asset)
+	require.Nil(t, err)
+	require.Equal(t, FileSchemaVersion, v)
+	m, err := Migrate("asset.yaml", asset, FileSchemaVersion, Migrations)
+	require.Nil(t, err)
+	require.False(t, m.Needed())
+
+	// The asset of 1.0.0 only has the version in the header comment
+	current := strings.Replace(string(asset), "ergomcutool v"+FileSchemaVersion, "ergomcutool v1.0.0", 1)
+	keyLines := "\n# Version of ergomcutool that created this file\n" + VersionKey + ": 1.1.0\n"
+	require.Contains(t, current, keyLines)
+	old := strings.Replace(current, keyLines, "", 1)
+	v, err = SchemaVersion([]byte(old))
+	require.Nil(t, err)
+	require.Equal(t, "1.0.0", v)
+
+	m, err = Migrate("asset.yaml", []byte(old), "1.1.0", Migrations)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1.1.0: add the \"ergomcutool_version\" key"}, m.Steps)
+	require.Equal(t, current, string(m.New))
+}
+
+func TestCheckVersion(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := log.New(&buf, "", 0)
+	require.Nil(t, CheckVersion("c.yaml", []byte(VersionKey+": "+FileSchemaVersion+"\n"), logger))
+	require.Empty(t, buf.String())
+
+	require.Nil(t, CheckVersion("c.yaml", []byte("# ergomcutool v1.0.0\ngeneral:\n"), logger))
+	require.Contains(t, buf.String(), `"c.yaml" has version 1.0.0, run 'ergomcutool migrate'`)
+
+	err := CheckVersion("c.yaml", []byte(VersionKey+": 99.0.0\n"), logger)
+	require.ErrorIs(t, err, ErrNewerVersion)
+
+	// Load checks the user and the local configuration files
+	userDir := t.TempDir()
+	projectDir := t.TempDir()
+	userFile := filepath.Join(userDir, UserConfigFileName)
+	asset, err := os.ReadFile(filepath.Join("..", "assets", "assets", UserConfigFileName))
+	require.Nil(t, err)
+	old := strings.Replace(string(asset), VersionKey+": "+FileSchemaVersion, VersionKey+": 1.0.0", 1)
+	require.Nil(t, os.WriteFile(userFile, []byte(old), 0644))
+	buf.Reset()
+	_, err = LoadWithOverrides(userDir, projectDir, false, &Overrides{Env: []string{}}, logger)
+	require.Nil(t, err)
+	require.Contains(t, buf.String(), "has version 1.0.0, run 'ergomcutool migrate'")
+
+	localFile := filepath.Join(projectDir, LocalConfigFilePath)
+	require.Nil(t, os.MkdirAll(filepath.Dir(localFile), 0755))
+	require.Nil(t, os.WriteFile(localFile, []byte(VersionKey+": 99.0.0\n"), 0644))
+	_, err = LoadWithOverrides(userDir, projectDir, false, &Overrides{Env: []string{}}, log.New(io.Discard, "", 0))
+	require.ErrorIs(t, err, ErrNewerVersion)
+	_, err = Effective(userDir, projectDir, &Overrides{Env: []string{}}, log.New(io.Discard, "", 0))
+	require.ErrorIs(t, err, ErrNewerVersion)
+}
+
+func TestMigrateNoHeader(t *testing.T) {
+	m, err := Migrate("c.yaml", []byte("general:\n  debugger_path: gdb\n"), FileSchemaVersion, Migrations)
+	require.Nil(t, err)
+	require.Equal(t, "# Version of ergomcutool that created this file\n"+VersionKey+": 1.1.0\n\n"+
+		"general:\n  debugger_path: gdb\n", string(m.New))
+
+	// The migrated file is replaced and keeps its permissions
+	path := filepath.Join(t.TempDir(), "c.yaml")
+	require.Nil(t, os.WriteFile(path, m.Old, 0o600))
+	m.Path = path
+	backup, err := m.Write(filepath.Join(t.TempDir(), "backups"))
+	require.Nil(t, err)
+	require.FileExists(t, backup)
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, m.New, data)
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestCheckVersionRelease(t *testing.T) {
+	// A new release without migration steps doesn't outdate the files
+	defer func(v string) { Version = v }(Version)
+	Version = "9.9.9"
+	buf := bytes.Buffer{}
+	require.Nil(t, CheckVersion("c.yaml", []byte(VersionKey+": "+FileSchemaVersion+"\n"), log.New(&buf, "", 0)))
+	require.Empty(t, buf.String())
+}
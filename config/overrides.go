@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding
+// the configuration, e.g. 'ERGOMCUTOOL_OPENOCD_INTERFACE' overrides 'openocd.interface'.
+const EnvPrefix = "ERGOMCUTOOL_"
+
+// dependenciesKey is the key prefix of the external dependency settings:
+// 'external_dependencies.<VAR>.<field>'.
+const dependenciesKey = "external_dependencies"
+
+// dependencyFields are the external dependency fields that can be overridden.
+var dependencyFields = []string{"create_in_project_link", "link_name", "path", "git", "ref"}
+
+// Overrides are the configuration layers applied over the configuration files.
+type Overrides struct {
+	// UserConfigFile replaces the user configuration file if not empty
+	UserConfigFile string
+	// Env are the environment variables in 'KEY=value' form, os.Environ() if nil
+	Env []string
+	// Settings are the 'key=value' settings applied after the environment variables,
+	// e.g. 'openocd.interface=jlink.cfg'
+	Settings []string
+}
+
+// DefaultOverrides are applied by Load,
+// they are set by the '--config' and '--set' CLI flags.
+var DefaultOverrides = &Overrides{}
+
+// yamlName returns the yaml key of the struct field.
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return name
+}
+
+// fieldByYamlName returns the field of the struct value with the yaml key.
+func fieldByYamlName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Keys returns the keys of the settings, e.g. 'openocd.interface'.
+// The external dependency settings are not included, their keys are
+// 'external_dependencies.<VAR>.<field>'.
+func Keys() []string {
+	r := make([]string, 0, 20)
+	t := reflect.TypeOf(ToolConfigT{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		st := section.Type
+		if st.Kind() == reflect.Pointer {
+			st = st.Elem()
+		}
+		if st.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < st.NumField(); j++ {
+			r = append(r, yamlName(section)+"."+yamlName(st.Field(j)))
+		}
+	}
+	return r
+}
+
+// EnvName returns the environment variable overriding the setting.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// setScalar sets the string or bool value, pointers are allocated.
+func setScalar(key string, v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setScalar(key, p.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q must be either 'true' or 'false'", key)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("%q is not a scalar setting", key)
+	}
+	return nil
+}
+
+// Set sets the setting, e.g. 'openocd.interface' or 'external_dependencies.<VAR>.path'.
+// The dependency is added if the configuration doesn't have it.
+func (c *ToolConfigT) Set(key, value string) error {
+	v, err := c.field(key, true)
+	if err != nil {
+		return err
+	}
+	return setScalar(key, v, value)
+}
+
+// Get returns the value of the setting, see Set.
+func (c *ToolConfigT) Get(key string) (string, error) {
+	v, err := c.field(key, false)
+	if err != nil || !v.IsValid() {
+		return "", err
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Bool {
+		return strconv.FormatBool(v.Bool()), nil
+	}
+	return v.String(), nil
+}
+
+// field returns the field of the setting. If 'alloc' is true, the missing section
+// or dependency is added, otherwise an invalid value is returned for it.
+func (c *ToolConfigT) field(key string, alloc bool) (reflect.Value, error) {
+	unknown := fmt.Errorf("unknown setting %q", key)
+	if rest, ok := strings.CutPrefix(key, dependenciesKey+"."); ok {
+		i := strings.LastIndex(rest, ".")
+		if i <= 0 || !isDependencyField(rest[i+1:]) {
+			return reflect.Value{}, fmt.Errorf("%w, use '%s.<VAR>.<field>', the fields are: %s",
+				unknown, dependenciesKey, strings.Join(dependencyFields, ", "))
+		}
+		var d *ExternalDependencyT
+		for j := range c.ExternalDependencies {
+			if c.ExternalDependencies[j].Var == rest[:i] {
+				d = &c.ExternalDependencies[j]
+			}
+		}
+		if d == nil {
+			if !alloc {
+				return reflect.Value{}, nil
+			}
+			c.ExternalDependencies = append(c.ExternalDependencies, ExternalDependencyT{Var: rest[:i]})
+			d = &c.ExternalDependencies[len(c.ExternalDependencies)-1]
+		}
+		fv, _ := fieldByYamlName(reflect.ValueOf(d).Elem(), rest[i+1:])
+		return fv, nil
+	}
+	section, field, ok := strings.Cut(key, ".")
+	if !ok {
+		return reflect.Value{}, unknown
+	}
+	sv, found := fieldByYamlName(reflect.ValueOf(c).Elem(), section)
+	if !found {
+		return reflect.Value{}, unknown
+	}
+	if sv.Kind() == reflect.Pointer {
+		if sv.IsNil() {
+			if alloc {
+				sv.Set(reflect.New(sv.Type().Elem()))
+			} else {
+				sv = reflect.New(sv.Type().Elem())
+			}
+		}
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Struct {
+		return reflect.Value{}, unknown
+	}
+	fv, found := fieldByYamlName(sv, field)
+	if !found {
+		return reflect.Value{}, unknown
+	}
+	return fv, nil
+}
+
+func isDependencyField(field string) bool {
+	for _, f := range dependencyFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// envKey returns the setting overridden by the environment variable,
+// empty if the variable doesn't match any setting.
+func envKey(name string, keys map[string]string) string {
+	if key, ok := keys[name]; ok {
+		return key
+	}
+	rest, ok := strings.CutPrefix(name, EnvName(dependenciesKey)+"_")
+	if !ok {
+		return ""
+	}
+	for _, f := range dependencyFields {
+		suffix := "_" + strings.ToUpper(f)
+		if v, ok := strings.CutSuffix(rest, suffix); ok && v != "" {
+			return dependenciesKey + "." + v + "." + f
+		}
+	}
+	return ""
+}
+
+// Origins of the overridden settings
+const (
+	OriginEnvPrefix = "env "
+	OriginCLI       = "--set"
+)
+
+// Apply applies the environment variables and then the settings to the configuration.
+// Unknown 'ERGOMCUTOOL_*' environment variables are reported to the logger.
+func (o *Overrides) Apply(c *ToolConfigT, logger *log.Logger) error {
+	return o.apply(c, logger, func(key, origin string) {})
+}
+
+// apply is Apply that reports the origin of each applied setting.
+func (o *Overrides) apply(c *ToolConfigT, logger *log.Logger, applied func(key, origin string)) error {
+	env := o.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	keys := map[string]string{}
+	for _, k := range Keys() {
+		keys[EnvName(k)] = k
+	}
+	sorted := append([]string{}, env...)
+	sort.Strings(sorted)
+	for _, e := range sorted {
+		name, value, _ := strings.Cut(e, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		key := envKey(name, keys)
+		if key == "" {
+			logger.Printf("%s unknown environment variable %q is ignored.\n", toolConfigWarningPrefix, name)
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("environment variable %q: %w", name, err)
+		}
+		applied(key, OriginEnvPrefix+name)
+	}
+	for _, s := range o.Settings {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("setting %q must have 'key=value' form", s)
+		}
+		key = strings.TrimSpace(key)
+		if err := c.Set(key, value); err != nil {
+			return err
+		}
+		applied(key, OriginCLI)
+	}
+	return nil
+}
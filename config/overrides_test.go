@@ -0,0 +1,59 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvKey(t *testing.T) {
+	keys := map[string]string{}
+	for _, k := range Keys() {
+		keys[EnvName(k)] = k
+	}
+	for _, tc := range []struct {
+		name string
+		key  string
+	}{
+		{"ERGOMCUTOOL_OPENOCD_INTERFACE", "openocd.interface"},
+		{"ERGOMCUTOOL_GENERAL_C_COMPILER_PATH", "general.c_compiler_path"},
+		// The dependency variable may contain underscores
+		{"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_PATH", "external_dependencies.MY_LIB.path"},
+		{"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_CREATE_IN_PROJECT_LINK",
+			"external_dependencies.MY_LIB.create_in_project_link"},
+		// '_LINK_NAME' is the field, not '_NAME' of the 'MY_LIB_LINK' variable
+		{"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_LINK_NAME", "external_dependencies.MY_LIB.link_name"},
+		{"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_NAME", ""},
+		{"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_PATH", ""},
+		{"ERGOMCUTOOL_OPENOCD_UNKNOWN", ""},
+		{"ERGOMCUTOOL_", ""},
+	} {
+		require.Equal(t, tc.key, envKey(tc.name, keys), tc.name)
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	buf := bytes.Buffer{}
+	c := &ToolConfigT{}
+	o := &Overrides{
+		Env: []string{
+			"HOME=/home/user",
+			"ERGOMCUTOOL_OPENOCD_INTERFACE=jlink.cfg",
+			"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_LINK_NAME=lib",
+			"ERGOMCUTOOL_UNKNOWN=1",
+		},
+		Settings: []string{"openocd.interface=stlink.cfg"},
+	}
+	require.Nil(t, o.Apply(c, log.New(&buf, "", 0)))
+	require.Equal(t, "stlink.cfg", *c.Openocd.Interface)
+	require.Equal(t, "lib", c.ExternalDependencies[0].LinkName)
+	require.Equal(t, "MY_LIB", c.ExternalDependencies[0].Var)
+	require.Contains(t, buf.String(), `unknown environment variable "ERGOMCUTOOL_UNKNOWN" is ignored`)
+	require.NotContains(t, buf.String(), "HOME")
+
+	require.NotNil(t, (&Overrides{Env: []string{}, Settings: []string{"openocd.interface"}}).Apply(c, log.Default()))
+	require.NotNil(t, (&Overrides{Env: []string{"ERGOMCUTOOL_INTELLISENSE_SKIP_COMPILE_COMMANDS=maybe"}}).
+		Apply(c, log.Default()))
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlScalar returns the YAML representation of the scalar,
+// quoted only if necessary. Booleans and numbers are not quoted.
+func yamlScalar(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	doc := yaml.Node{}
+	if yaml.Unmarshal([]byte(value), &doc) == nil && len(doc.Content) == 1 {
+		n := doc.Content[0]
+		switch n.Tag {
+		case "!!bool", "!!int", "!!float":
+			if n.Kind == yaml.ScalarNode && n.Value == value {
+				return value, nil
+			}
+		}
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// findKey returns the key and value nodes of the mapping.
+func findKey(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// SetYamlValue sets the scalar value of the dotted key, e.g. 'general.c_compiler_path',
+// in the YAML document. Only the changed line is modified, so that
+// the comments and the formatting of the document are preserved.
+// Missing keys are inserted. An empty value is written as null.
+// The external dependency keys are 'external_dependencies.<VAR>.<field>'.
+func SetYamlValue(data []byte, key, value string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(key, dependenciesKey+"."); ok {
+		i := strings.LastIndex(rest, ".")
+		if i <= 0 {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		return setYamlDependency(data, rest[:i], rest[i+1:], value)
+	}
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	scalar, err := yamlScalar(value)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	path := strings.Split(key, ".")
+
+	var mapping *yaml.Node
+	if len(doc.Content) > 0 {
+		mapping = doc.Content[0]
+	}
+	// parentLine is the line of the key of the mapping (0-based), -1 for the document
+	parentLine, indent := -1, 0
+	for i, k := range path {
+		if mapping != nil && mapping.Kind != yaml.MappingNode && !isNull(mapping) {
+			return nil, fmt.Errorf("%q is not a mapping", strings.Join(path[:i], "."))
+		}
+		var keyNode, valueNode *yaml.Node
+		if mapping != nil && mapping.Kind == yaml.MappingNode {
+			keyNode, valueNode = findKey(mapping, k)
+			if len(mapping.Content) > 0 {
+				indent = mapping.Content[0].Column - 1
+			}
+		}
+		last := i == len(path)-1
+		if keyNode == nil {
+			// Insert the missing keys after the parent key
+			if mapping == nil || mapping.Kind != yaml.MappingNode || len(mapping.Content) == 0 {
+				indent = 2 * i
+			}
+			inserted := make([]string, 0, len(path)-i)
+			for j, rest := range path[i:] {
+				line := strings.Repeat(" ", indent+2*j) + rest + ":"
+				if i+j == len(path)-1 && scalar != "" {
+					line += " " + scalar
+				}
+				inserted = append(inserted, line)
+			}
+			at := parentLine + 1
+			if parentLine < 0 {
+				// Append to the end of the document
+				at = len(lines)
+				for at > 0 && lines[at-1] == "" {
+					at--
+				}
+			}
+			lines = insertLines(lines, at, inserted...)
+			return []byte(strings.Join(lines, "\n")), nil
+		}
+		if !last {
+			mapping, parentLine = valueNode, keyNode.Line-1
+			continue
+		}
+
+		if err = replaceValue(lines, key, keyNode, valueNode, scalar); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// replaceValue replaces the scalar value on the line of the key,
+// the line comment is kept.
+func replaceValue(lines []string, key string, keyNode, valueNode *yaml.Node, scalar string) error {
+	if valueNode.Kind != yaml.ScalarNode {
+		return fmt.Errorf("%q is not a scalar value", key)
+	}
+	line := lines[keyNode.Line-1]
+	colon := strings.Index(line[keyNode.Column-1:], ":")
+	if colon < 0 || (!isNull(valueNode) && valueNode.Line != keyNode.Line) {
+		return fmt.Errorf("%q: multi-line values are not supported", key)
+	}
+	colon += keyNode.Column - 1
+	comment := ""
+	if valueNode.LineComment != "" {
+		if c := strings.LastIndex(line, valueNode.LineComment); c > colon {
+			comment = " " + line[c:]
+		}
+	}
+	newLine := line[:colon+1]
+	if scalar != "" {
+		newLine += " " + scalar
+	}
+	lines[keyNode.Line-1] = newLine + comment
+	return nil
+}
+
+// insertLines inserts the lines before the line with the index.
+func insertLines(lines []string, at int, inserted ...string) []string {
+	return append(lines[:at], append(inserted, lines[at:]...)...)
+}
+
+// setYamlDependency sets the field of the external dependency item with the 'var',
+// the item is added if it doesn't exist.
+func setYamlDependency(data []byte, v, field, value string) ([]byte, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	scalar, err := yamlScalar(value)
+	if err != nil {
+		return nil, err
+	}
+	varScalar, err := yamlScalar(v)
+	if err != nil {
+		return nil, err
+	}
+	key := dependenciesKey + "." + v + "." + field
+	fieldLine := func(indent int) string {
+		line := strings.Repeat(" ", indent) + field + ":"
+		if scalar != "" {
+			line += " " + scalar
+		}
+		return line
+	}
+	lines := strings.Split(string(data), "\n")
+
+	var keyNode, seq *yaml.Node
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		keyNode, seq = findKey(doc.Content[0], dependenciesKey)
+	}
+	switch {
+	case keyNode == nil:
+		at := len(lines)
+		for at > 0 && lines[at-1] == "" {
+			at--
+		}
+		lines = insertLines(lines, at, dependenciesKey+":", "  - var: "+varScalar, fieldLine(4))
+	case isNull(seq):
+		lines = insertLines(lines, keyNode.Line, "  - var: "+varScalar, fieldLine(4))
+	case seq.Kind != yaml.SequenceNode || seq.Style&yaml.FlowStyle != 0:
+		return nil, fmt.Errorf("%q must be a block sequence", dependenciesKey)
+	default:
+		for _, item := range seq.Content {
+			if item.Kind != yaml.MappingNode {
+				continue
+			}
+			varKey, varValue := findKey(item, "var")
+			if varValue == nil || varValue.Value != v {
+				continue
+			}
+			if k, fieldValue := findKey(item, field); k != nil {
+				if err = replaceValue(lines, key, k, fieldValue, scalar); err != nil {
+					return nil, err
+				}
+			} else {
+				lines = insertLines(lines, varKey.Line, fieldLine(varKey.Column-1))
+			}
+			return []byte(strings.Join(lines, "\n")), nil
+		}
+		// Insert the new item before the first one
+		first := seq.Content[0]
+		indent := strings.Repeat(" ", max(first.Column-3, 0))
+		lines = insertLines(lines, first.Line-1, indent+"- var: "+varScalar, fieldLine(len(indent)+2))
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// GetYamlValue returns the scalar value of the dotted key in the YAML document,
+// false if the key doesn't exist.
+func GetYamlValue(data []byte, key string) (string, bool, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", false, err
+	}
+	if len(doc.Content) == 0 {
+		return "", false, nil
+	}
+	n := doc.Content[0]
+	for _, k := range strings.Split(key, ".") {
+		if n.Kind != yaml.MappingNode {
+			return "", false, nil
+		}
+		if _, n = findKey(n, k); n == nil {
+			return "", false, nil
+		}
+	}
+	if n.Kind != yaml.ScalarNode {
+		return "", false, fmt.Errorf("%q is not a scalar value", key)
+	}
+	if isNull(n) {
+		return "", true, nil
+	}
+	return n.Value, true, nil
+}
+
+// isNull returns true if the node is an empty value.
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
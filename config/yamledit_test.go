@@ -0,0 +1,213 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const yamlEditSample = `# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+
+intellisense:
+  skip_compile_commands: false
+`
+
+func TestSetYamlValue(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		key   string
+		value string
+		want  string
+	}{
+		{"existing key, trailing comment kept", "general.c_compiler_path", "/opt/gcc/bin/arm-none-eabi-gcc",
+			`# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /opt/gcc/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+
+intellisense:
+  skip_compile_commands: false
+`},
+		{"missing key", "general.debugger_path", "/usr/bin/gdb-multiarch",
+			`# User configuration
+general:
+  debugger_path: /usr/bin/gdb-multiarch
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+
+intellisense:
+  skip_compile_commands: false
+`},
+		{"null parent", "openocd.interface", "stlink.cfg",
+			`# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+  interface: stlink.cfg
+
+intellisense:
+  skip_compile_commands: false
+`},
+		{"missing nested key", "build_options.debug", "1",
+			`# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+
+intellisense:
+  skip_compile_commands: false
+build_options:
+  debug: 1
+`},
+		{"bool is not quoted", "intellisense.skip_compile_commands", "true",
+			`# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+
+intellisense:
+  skip_compile_commands: true
+`},
+		{"empty value is null", "general.cpp_compiler_path", "",
+			`# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path:
+
+openocd:
+
+intellisense:
+  skip_compile_commands: false
+`},
+		{"dependency item", "external_dependencies.MY_LIB.path", "~/lib",
+			`# User configuration
+general:
+  # Path to the compiler
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path: /usr/bin/arm-none-eabi-g++
+
+openocd:
+
+intellisense:
+  skip_compile_commands: false
+external_dependencies:
+  - var: MY_LIB
+    path: ~/lib
+`},
+	} {
+		data, err := SetYamlValue([]byte(yamlEditSample), tc.key, tc.value)
+		require.Nil(t, err, tc.name)
+		require.Equal(t, tc.want, string(data), tc.name)
+	}
+
+	_, err := SetYamlValue([]byte(yamlEditSample), "general", "x")
+	require.NotNil(t, err)
+	_, err = SetYamlValue([]byte(yamlEditSample), "general.c_compiler_path.x", "x")
+	require.NotNil(t, err)
+	_, err = SetYamlValue([]byte("a: [\n"), "a.b", "x")
+	require.NotNil(t, err)
+}
+
+func TestSetYamlDependency(t *testing.T) {
+	const deps = `external_dependencies:
+  - var: MY_LIB
+    path: ~/lib # local checkout
+  - var: OTHER_LIB
+    git: https://example.com/other_lib.git
+`
+	for _, tc := range []struct {
+		name  string
+		key   string
+		value string
+		want  string
+	}{
+		{"existing field", "external_dependencies.MY_LIB.path", "/opt/lib",
+			`external_dependencies:
+  - var: MY_LIB
+    path: /opt/lib # local checkout
+  - var: OTHER_LIB
+    git: https://example.com/other_lib.git
+`},
+		{"new field is inserted after var", "external_dependencies.OTHER_LIB.ref", "v1.2.0",
+			`external_dependencies:
+  - var: MY_LIB
+    path: ~/lib # local checkout
+  - var: OTHER_LIB
+    ref: v1.2.0
+    git: https://example.com/other_lib.git
+`},
+		{"bool field", "external_dependencies.MY_LIB.create_in_project_link", "true",
+			`external_dependencies:
+  - var: MY_LIB
+    create_in_project_link: true
+    path: ~/lib # local checkout
+  - var: OTHER_LIB
+    git: https://example.com/other_lib.git
+`},
+		{"new dependency", "external_dependencies.NEW_LIB.link_name", "new",
+			`external_dependencies:
+  - var: NEW_LIB
+    link_name: new
+  - var: MY_LIB
+    path: ~/lib # local checkout
+  - var: OTHER_LIB
+    git: https://example.com/other_lib.git
+`},
+	} {
+		data, err := SetYamlValue([]byte(deps), tc.key, tc.value)
+		require.Nil(t, err, tc.name)
+		require.Equal(t, tc.want, string(data), tc.name)
+	}
+}
+
+func TestGetYamlValue(t *testing.T) {
+	for _, tc := range []struct {
+		key   string
+		value string
+		found bool
+		err   bool
+	}{
+		{"general.c_compiler_path", "/usr/bin/arm-none-eabi-gcc", true, false},
+		{"intellisense.skip_compile_commands", "false", true, false},
+		{"general.debugger_path", "", false, false},
+		{"build_options.debug", "", false, false},
+		// A null value is found, but empty
+		{"general.cpp_compiler_path", "", true, false},
+		{"openocd.interface", "", false, false},
+		{"general", "", false, true},
+	} {
+		data := []byte(`general:
+  c_compiler_path: /usr/bin/arm-none-eabi-gcc # the system one
+  cpp_compiler_path:
+openocd:
+intellisense:
+  skip_compile_commands: false
+`)
+		value, found, err := GetYamlValue(data, tc.key)
+		require.Equal(t, tc.err, err != nil, tc.key)
+		require.Equal(t, tc.found, found, tc.key)
+		require.Equal(t, tc.value, value, tc.key)
+	}
+}
@@ -0,0 +1,182 @@
+// deps package resolves the external dependency graph
+// described by the 'ergomcu_lib.yaml' library manifests.
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/proj"
+	"gopkg.in/yaml.v2"
+)
+
+// ManifestFileName is the library manifest in the dependency directory.
+const ManifestFileName = "ergomcu_lib.yaml"
+
+// Manifest describes the library sources, public include directories,
+// definitions and dependencies. The paths are relative to the library directory.
+type Manifest struct {
+	Name         string         `yaml:"name"`
+	CSrc         []string       `yaml:"c_src"`
+	CSrcDirs     []proj.SrcDirT `yaml:"c_src_dirs"`
+	CIncludeDirs []string       `yaml:"c_include_dirs"`
+	CDefs        []string       `yaml:"c_defs"`
+	CppSrc       []string       `yaml:"cpp_src"`
+	CppSrcDirs   []proj.SrcDirT `yaml:"cpp_src_dirs"`
+	AsmSrc       []string       `yaml:"asm_src"`
+	Dependencies []DependencyT  `yaml:"dependencies"`
+}
+
+// DependencyT is a library dependency. The path is used
+// if the project doesn't define the dependency variable.
+type DependencyT struct {
+	Var  string `yaml:"var"`
+	Path string `yaml:"path"`
+}
+
+// Library is a node of the dependency graph.
+type Library struct {
+	Var string
+	// Dir is the library directory from the project root
+	Dir string
+	// Manifest is nil if the library has no manifest
+	Manifest *Manifest
+	// Dependencies are the variables of the libraries this one depends on
+	Dependencies []string
+}
+
+// ReadManifest reads the manifest from the library directory.
+// Returns nil and no error if the manifest doesn't exist.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = yaml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	for i, d := range m.Dependencies {
+		if d.Var == "" {
+			return nil, fmt.Errorf("dependency #%d: 'var' is missing", i+1)
+		}
+	}
+	return m, nil
+}
+
+// Resolve reads the manifests of the project dependencies and their
+// dependencies transitively. Relative paths are relative to the project root.
+// The libraries are returned in topological order,
+// each library follows its dependencies.
+// Returns an error if the dependencies form a cycle.
+func Resolve(root string, projectDeps []config.ExternalDependencyT) ([]*Library, error) {
+	paths := make(map[string]string, len(projectDeps))
+	vars := make([]string, 0, len(projectDeps))
+	for _, d := range projectDeps {
+		paths[d.Var] = d.Path
+		vars = append(vars, d.Var)
+	}
+	// mergeExternalDeps doesn't preserve the order
+	sort.Strings(vars)
+
+	r := make([]*Library, 0, len(vars))
+	visited := make(map[string]bool, len(vars))
+	stack := make([]string, 0, 8)
+
+	var visit func(v, dir string) error
+	visit = func(v, dir string) error {
+		for i, s := range stack {
+			if s == v {
+				return fmt.Errorf("dependency cycle: %s", strings.Join(append(stack[i:], v), " -> "))
+			}
+		}
+		if visited[v] {
+			return nil
+		}
+		stack = append(stack, v)
+		defer func() { stack = stack[:len(stack)-1] }()
+
+		m, err := ReadManifest(libPath(root, dir))
+		if err != nil {
+			return fmt.Errorf("%q: failed to read %s: %w", v, ManifestFileName, err)
+		}
+		lib := &Library{Var: v, Dir: dir, Manifest: m}
+		if m != nil {
+			for _, d := range m.Dependencies {
+				depDir, ok := paths[d.Var]
+				if !ok {
+					if d.Path == "" {
+						return fmt.Errorf("%q depends on %q which is not defined", v, d.Var)
+					}
+					depDir = libPath(dir, d.Path)
+					paths[d.Var] = depDir
+				}
+				if err = visit(d.Var, depDir); err != nil {
+					return err
+				}
+				lib.Dependencies = append(lib.Dependencies, d.Var)
+			}
+		}
+		visited[v] = true
+		r = append(r, lib)
+		return nil
+	}
+
+	for _, v := range vars {
+		if err := visit(v, paths[v]); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// libPath returns the project path of the library file.
+func libPath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+func libPaths(dir string, paths []string) []string {
+	r := make([]string, 0, len(paths))
+	for _, p := range paths {
+		r = append(r, libPath(dir, p))
+	}
+	return r
+}
+
+func libSrcDirs(dir string, dirs []proj.SrcDirT) []proj.SrcDirT {
+	r := make([]proj.SrcDirT, 0, len(dirs))
+	for _, d := range dirs {
+		r = append(r, proj.SrcDirT{Path: libPath(dir, d.Path), Recursive: d.Recursive,
+			Exclude: libPaths(dir, d.Exclude)})
+	}
+	return r
+}
+
+// Apply appends the library settings to the project settings
+// in the order of the libraries.
+func Apply(pc *proj.ErgomcuProjectT, libs []*Library) {
+	for _, lib := range libs {
+		m := lib.Manifest
+		if m == nil {
+			continue
+		}
+		pc.CSrc = append(pc.CSrc, libPaths(lib.Dir, m.CSrc)...)
+		pc.CSrcDirs = append(pc.CSrcDirs, libSrcDirs(lib.Dir, m.CSrcDirs)...)
+		pc.CIncludeDirs = append(pc.CIncludeDirs, libPaths(lib.Dir, m.CIncludeDirs)...)
+		pc.CDefs = append(pc.CDefs, m.CDefs...)
+		pc.CppSrc = append(pc.CppSrc, libPaths(lib.Dir, m.CppSrc)...)
+		pc.CppSrcDirs = append(pc.CppSrcDirs, libSrcDirs(lib.Dir, m.CppSrcDirs)...)
+		pc.AsmSrc = append(pc.AsmSrc, libPaths(lib.Dir, m.AsmSrc)...)
+	}
+}
@@ -0,0 +1,53 @@
+package deps
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	libs, err := Resolve(".", []config.ExternalDependencyT{
+		{Var: "PLAIN", Path: "test_data/plain"},
+		{Var: "LIB_B", Path: "test_data/lib_b"},
+		{Var: "LIB_A", Path: "test_data/lib_a"},
+	})
+	require.Nil(t, err)
+	vars := make([]string, 0, len(libs))
+	for _, l := range libs {
+		vars = append(vars, l.Var)
+	}
+	require.Equal(t, []string{"LIB_C", "LIB_B", "LIB_A", "PLAIN"}, vars)
+	require.Equal(t, filepath.Join("test_data", "lib_c"), libs[0].Dir)
+	require.Equal(t, []string{"LIB_B", "LIB_C"}, libs[2].Dependencies)
+	require.Nil(t, libs[3].Manifest)
+
+	pc := &proj.ErgomcuProjectT{CDefs: []string{"PROJECT"}}
+	Apply(pc, libs)
+	require.Equal(t, []string{"PROJECT", "LIB_A"}, pc.CDefs)
+	require.Equal(t, []string{filepath.Join("test_data", "lib_a", "src", "a.c")}, pc.CSrc)
+	require.Equal(t, []string{filepath.Join("test_data", "lib_c", "inc"),
+		filepath.Join("test_data", "lib_a", "include")}, pc.CIncludeDirs)
+	require.Equal(t, []proj.SrcDirT{{Path: filepath.Join("test_data", "lib_b", "src"), Recursive: true,
+		Exclude: []string{filepath.Join("test_data", "lib_b", "src", "test", "**")}}}, pc.CSrcDirs)
+
+	// The manifests are read relative to the project root
+	libs, err = Resolve("test_data", []config.ExternalDependencyT{{Var: "LIB_C", Path: "lib_c"}})
+	require.Nil(t, err)
+	require.Equal(t, "lib_c", libs[0].Dir)
+	require.NotNil(t, libs[0].Manifest)
+}
+
+func TestResolveErrors(t *testing.T) {
+	_, err := Resolve(".", []config.ExternalDependencyT{{Var: "CYCLE_X", Path: "test_data/cycle_x"}})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "CYCLE_X -> CYCLE_Y -> CYCLE_X")
+
+	// LIB_B is neither defined by the project nor has a path in the manifest
+	libs, err := Resolve(".", []config.ExternalDependencyT{{Var: "LIB_A", Path: "test_data/lib_a"}})
+	require.NotNil(t, err)
+	require.Nil(t, libs)
+}
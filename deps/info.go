@@ -0,0 +1,205 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+)
+
+// Sources of the external dependency settings
+const (
+	SourceProject     = "project"
+	SourceUserConfig  = "user config"
+	SourceLocalConfig = "local config"
+	SourceLockfile    = "lockfile"
+)
+
+// FieldSources describes where each setting of the merged dependency comes from.
+type FieldSources struct {
+	Path                string `json:"path"`
+	Git                 string `json:"git,omitempty"`
+	CreateInProjectLink string `json:"create_in_project_link"`
+	LinkName            string `json:"link_name,omitempty"`
+}
+
+func find(deps []config.ExternalDependencyT, v string) *config.ExternalDependencyT {
+	for i := range deps {
+		if deps[i].Var == v {
+			return &deps[i]
+		}
+	}
+	return nil
+}
+
+// Sources returns the sources of the merged dependency settings.
+// The local config takes precedence over the user config,
+// the merge rules are the ones of config.ExternalDependencyT.MergeSpecial.
+// settings are the effective configuration settings (see config.Effective),
+// the origin of the ones overridden by the environment variables or '--set'
+// is reported as the source.
+func Sources(merged *config.ExternalDependencyT,
+	project, user, local []config.ExternalDependencyT, settings []config.Setting) FieldSources {
+	r := FieldSources{}
+	p := find(project, merged.Var)
+	c, configSource := find(local, merged.Var), SourceLocalConfig
+	if c == nil {
+		c, configSource = find(user, merged.Var), SourceUserConfig
+	}
+	if p != nil {
+		r = FieldSources{Path: SourceProject, Git: SourceProject,
+			CreateInProjectLink: SourceProject, LinkName: SourceProject}
+	}
+	if c != nil {
+		if p == nil || c.Path != "" {
+			r.Path = configSource
+			r.Git = configSource
+		}
+		if p == nil || c.CreateInProjectLink {
+			r.CreateInProjectLink = configSource
+		}
+		if p == nil || c.LinkName != "" {
+			r.LinkName = configSource
+		}
+	}
+	// The overrides are merged like the config values
+	prefix := "external_dependencies." + merged.Var + "."
+	for _, s := range settings {
+		if !s.IsOverride() || !strings.HasPrefix(s.Key, prefix) {
+			continue
+		}
+		switch strings.TrimPrefix(s.Key, prefix) {
+		case "path":
+			if s.Value != "" {
+				r.Path = s.Origin
+				r.Git = s.Origin
+			}
+		case "git":
+			r.Git = s.Origin
+		case "create_in_project_link":
+			if p == nil || s.Value == "true" {
+				r.CreateInProjectLink = s.Origin
+			}
+		case "link_name":
+			if s.Value != "" {
+				r.LinkName = s.Origin
+			}
+		}
+	}
+	if merged.Git == "" {
+		r.Git = ""
+	} else if merged.Commit != "" {
+		r.Path = SourceLockfile
+	}
+	if merged.LinkName == "" {
+		r.LinkName = ""
+	}
+	return r
+}
+
+// Symlink statuses in the '_external' directory
+const (
+	LinkNone     = "-"
+	LinkOk       = "ok"
+	LinkMissing  = "missing"
+	LinkDangling = "dangling"
+	LinkWrong    = "wrong target"
+)
+
+// LinkStatus returns the status of the dependency symlink
+// in the external directory.
+func LinkStatus(d *config.ExternalDependencyT, externalDir string) string {
+	if !d.CreateInProjectLink {
+		return LinkNone
+	}
+	link := filepath.Join(externalDir, d.LinkName)
+	if _, err := os.Lstat(link); err != nil {
+		return LinkMissing
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return LinkDangling
+	}
+	expected, err := filepath.EvalSymlinks(d.Path)
+	if err == nil {
+		expected, err = filepath.Abs(expected)
+	}
+	if target, _ = filepath.Abs(target); err != nil || target != expected {
+		return LinkWrong
+	}
+	return LinkOk
+}
+
+// DanglingLinks returns the symlinks in the external directory
+// whose targets don't exist.
+func DanglingLinks(externalDir string) ([]string, error) {
+	entries, err := os.ReadDir(externalDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		link := filepath.Join(externalDir, e.Name())
+		if _, err := os.Stat(link); err != nil {
+			r = append(r, link)
+		}
+	}
+	return r, nil
+}
+
+// IsReferenced returns true if any of the paths references the dependency
+// either as a template variable, e.g. '{{.EXAMPLE_LIB}}/file.c',
+// or via its symlink, e.g. '_external/example_lib/file.c'.
+func IsReferenced(d *config.ExternalDependencyT, paths []string) bool {
+	link := ""
+	if d.CreateInProjectLink && d.LinkName != "" {
+		link = filepath.ToSlash(filepath.Join("_external", d.LinkName))
+	}
+	for _, p := range paths {
+		if strings.Contains(p, "."+d.Var+"}}") {
+			return true
+		}
+		cleaned := filepath.ToSlash(filepath.Clean(p))
+		if link != "" && (cleaned == link || strings.HasPrefix(cleaned, link+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// dotQuote quotes the DOT identifier.
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// Graph returns the dependency graph in DOT format. The project node
+// depends on the roots, the libraries on their manifest dependencies.
+func Graph(projectName string, roots []string, libs []*Library) string {
+	b := strings.Builder{}
+	b.WriteString("digraph dependencies {\n")
+	fmt.Fprintf(&b, "  %s [shape=box];\n", dotQuote(projectName))
+	sortedRoots := append([]string{}, roots...)
+	sort.Strings(sortedRoots)
+	for _, v := range sortedRoots {
+		fmt.Fprintf(&b, "  %s -> %s;\n", dotQuote(projectName), dotQuote(v))
+	}
+	for _, lib := range libs {
+		if lib.Manifest == nil {
+			fmt.Fprintf(&b, "  %s [style=dashed];\n", dotQuote(lib.Var))
+		}
+		for _, d := range lib.Dependencies {
+			fmt.Fprintf(&b, "  %s -> %s;\n", dotQuote(lib.Var), dotQuote(d))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package deps
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSources(t *testing.T) {
+	require := require.New(t)
+	project := []config.ExternalDependencyT{{Var: "A", Path: "a", CreateInProjectLink: true, LinkName: "a"}}
+	user := []config.ExternalDependencyT{{Var: "A", Path: "/usr/a"}, {Var: "B", Path: "b"}}
+	local := []config.ExternalDependencyT{{Var: "A", LinkName: "local_a"}}
+
+	merged := config.ExternalDependencyT{Var: "A", Path: "a", CreateInProjectLink: true, LinkName: "local_a"}
+	s := Sources(&merged, project, user, local, nil)
+	// the local config takes precedence, the project path is kept
+	require.Equal(FieldSources{Path: SourceProject, CreateInProjectLink: SourceProject,
+		LinkName: SourceLocalConfig}, s)
+
+	s = Sources(&merged, project, user, nil, nil)
+	require.Equal(SourceUserConfig, s.Path)
+
+	merged = config.ExternalDependencyT{Var: "B", Path: "b"}
+	s = Sources(&merged, project, user, local, nil)
+	require.Equal(FieldSources{Path: SourceUserConfig, CreateInProjectLink: SourceUserConfig}, s)
+
+	merged = config.ExternalDependencyT{Var: "G", Git: "g.git", Commit: "abc", Path: "/cache/abc"}
+	s = Sources(&merged, []config.ExternalDependencyT{{Var: "G", Git: "g.git"}}, nil, nil, nil)
+	require.Equal(SourceLockfile, s.Path)
+	require.Equal(SourceProject, s.Git)
+
+	// The environment variables and '--set' override the config files
+	merged = config.ExternalDependencyT{Var: "A", Path: "/env/a", CreateInProjectLink: true, LinkName: "cli_a"}
+	env := config.OriginEnvPrefix + "ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_A_PATH"
+	s = Sources(&merged, project, user, local, []config.Setting{
+		{Key: "external_dependencies.A.path", Value: "/env/a", Origin: env},
+		{Key: "external_dependencies.A.link_name", Value: "cli_a", Origin: config.OriginCLI},
+		{Key: "external_dependencies.A.create_in_project_link", Value: "false", Origin: config.OriginCLI},
+		{Key: "external_dependencies.B.path", Value: "/env/b", Origin: config.OriginCLI},
+		{Key: "openocd.interface", Value: "stlink.cfg", Origin: config.OriginCLI},
+	})
+	require.Equal(FieldSources{Path: env, CreateInProjectLink: SourceProject, LinkName: config.OriginCLI}, s)
+}
+
+func TestLinkStatus(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	external := filepath.Join(dir, "_external")
+	require.Nil(os.Mkdir(external, 0o755))
+	target, err := filepath.Abs("test_data/lib_a")
+	require.Nil(err)
+	require.Nil(os.Symlink(target, filepath.Join(external, "a")))
+	require.Nil(os.Symlink(filepath.Join(dir, "missing"), filepath.Join(external, "gone")))
+
+	d := config.ExternalDependencyT{Var: "A", Path: "test_data/lib_a", CreateInProjectLink: true, LinkName: "a"}
+	require.Equal(LinkOk, LinkStatus(&d, external))
+	d.Path = "test_data/lib_b"
+	require.Equal(LinkWrong, LinkStatus(&d, external))
+	d.LinkName = "b"
+	require.Equal(LinkMissing, LinkStatus(&d, external))
+	d.LinkName = "gone"
+	require.Equal(LinkDangling, LinkStatus(&d, external))
+	d.CreateInProjectLink = false
+	require.Equal(LinkNone, LinkStatus(&d, external))
+
+	dangling, err := DanglingLinks(external)
+	require.Nil(err)
+	require.Equal([]string{filepath.Join(external, "gone")}, dangling)
+
+	dangling, err = DanglingLinks(filepath.Join(dir, "no_such_dir"))
+	require.Nil(err)
+	require.Empty(dangling)
+}
+
+func TestIsReferenced(t *testing.T) {
+	require := require.New(t)
+	d := config.ExternalDependencyT{Var: "LIB", CreateInProjectLink: true, LinkName: "lib"}
+	require.True(IsReferenced(&d, []string{"Core/Src/main.c", "{{.LIB}}/src/lib.c"}))
+	require.True(IsReferenced(&d, []string{"./_external/lib/inc"}))
+	require.True(IsReferenced(&d, []string{"_external/lib"}))
+	require.False(IsReferenced(&d, []string{"_external/library/inc", "{{.LIB2}}/inc"}))
+	d.CreateInProjectLink = false
+	require.False(IsReferenced(&d, []string{"_external/lib/inc"}))
+}
+
+func TestGraph(t *testing.T) {
+	require := require.New(t)
+	libs := []*Library{
+		{Var: "C", Manifest: &Manifest{}},
+		{Var: "B", Dependencies: []string{"C"}},
+		{Var: "A", Manifest: &Manifest{}, Dependencies: []string{"B", "C"}},
+	}
+	expected := `digraph dependencies {
+  "my project" [shape=box];
+  "my project" -> "A";
+  "my project" -> "B";
+  "B" [style=dashed];
+  "B" -> "C";
+  "A" -> "B";
+  "A" -> "C";
+}
+`
+	require.Equal(expected, Graph("my project", []string{"B", "A"}, libs))
+}
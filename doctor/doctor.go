@@ -0,0 +1,360 @@
+// doctor package diagnoses the ergomcutool environment: the configured tools,
+// the CubeMX user action scripts and the external dependency symlinks.
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/deps"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/mcu-art/ergomcutool/proj"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Result is the result of a single check.
+type Result struct {
+	// Check is the checked setting or object, e.g. 'general.c_compiler_path'
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	// Hint describes how to fix the problem
+	Hint string `json:"hint,omitempty"`
+}
+
+// ProbeTimeout limits the duration of each version probe.
+var ProbeTimeout = 5 * time.Second
+
+// Options are the inputs of the checks.
+type Options struct {
+	Tool *config.ToolConfigT
+	// Project is nil if the checks run outside a project
+	Project *proj.ErgomcuProjectT
+	// ProjectDir is the project root, relative project paths are relative to it
+	ProjectDir string
+	// Probe runs the binary with '--version' and returns the first line
+	// of its output, ProbeVersion by default.
+	Probe func(bin string) (string, error)
+}
+
+// ProbeVersion runs the binary with '--version' and returns
+// the first non-empty line of its output.
+func ProbeVersion(bin string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ProbeTimeout)
+	defer cancel()
+	// openocd prints its version to stderr
+	out, err := exec.CommandContext(ctx, bin, "--version").CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no output")
+}
+
+// Counts returns the number of the results with each status.
+func Counts(results []Result) map[Status]int {
+	r := map[Status]int{StatusPass: 0, StatusWarn: 0, StatusFail: 0}
+	for _, res := range results {
+		r[res.Status]++
+	}
+	return r
+}
+
+func expand(path string) string {
+	if expanded, err := homedir.Expand(path); err == nil {
+		return expanded
+	}
+	return path
+}
+
+func isExecutable(info os.FileInfo) bool {
+	return !info.IsDir() && info.Mode().Perm()&0o111 != 0
+}
+
+// Run runs all the checks that apply to the options.
+func Run(opts Options) []Result {
+	if opts.Probe == nil {
+		opts.Probe = ProbeVersion
+	}
+	r := make([]Result, 0, 20)
+	r = append(r, checkTools(&opts)...)
+	if opts.Project != nil {
+		r = append(r, checkProject(&opts)...)
+	}
+	return r
+}
+
+// str returns the value of the optional setting.
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// checkDir checks that the setting specifies an existing directory.
+func checkDir(check, dir, hint string) Result {
+	if dir == "" {
+		return Result{check, StatusFail, "not configured", hint}
+	}
+	info, err := os.Stat(expand(dir))
+	if err != nil || !info.IsDir() {
+		return Result{check, StatusFail, fmt.Sprintf("directory %q doesn't exist", dir), hint}
+	}
+	return Result{check, StatusPass, dir, ""}
+}
+
+// checkBinary checks that the setting specifies an existing executable
+// and that it responds to '--version'.
+func checkBinary(opts *Options, check, bin, hint string) Result {
+	if bin == "" {
+		return Result{check, StatusFail, "not configured", hint}
+	}
+	path := expand(bin)
+	info, err := os.Stat(path)
+	if err != nil {
+		return Result{check, StatusFail, fmt.Sprintf("%q doesn't exist", bin), hint}
+	}
+	if !isExecutable(info) {
+		return Result{check, StatusFail, fmt.Sprintf("%q is not executable", bin),
+			fmt.Sprintf("Run 'chmod +x %s' or fix the path.", bin)}
+	}
+	version, err := opts.Probe(path)
+	if err != nil {
+		return Result{check, StatusWarn, fmt.Sprintf("'%s --version' failed: %v", bin, err), hint}
+	}
+	return Result{check, StatusPass, version, ""}
+}
+
+// checkTools checks the tool configuration.
+func checkTools(opts *Options) []Result {
+	const configHint = "Set %q in ergomcutool_config.yaml (user or local)."
+	hint := func(key string) string { return fmt.Sprintf(configHint, key) }
+	tool := opts.Tool
+	general := tool.General
+	if general == nil {
+		general = &config.ToolConfig_GeneralT{}
+	}
+	openocd := tool.Openocd
+	if openocd == nil {
+		openocd = &config.ToolConfig_OpenOcdT{}
+	}
+
+	r := []Result{
+		checkDir("general.arm_toolchain_path", str(general.ArmToolchainPath),
+			hint("general.arm_toolchain_path")+" It is the 'bin' directory that contains 'arm-none-eabi-gcc'."),
+		checkBinary(opts, "general.c_compiler_path", str(general.CCompilerPath),
+			hint("general.c_compiler_path")+" Install the GNU Arm Embedded Toolchain if it is missing."),
+		checkBinary(opts, "general.cpp_compiler_path", str(general.CppCompilerPath),
+			hint("general.cpp_compiler_path")),
+		checkBinary(opts, "general.debugger_path", str(general.DebuggerPath),
+			hint("general.debugger_path")+" 'gdb-multiarch' or 'arm-none-eabi-gdb' can be used."),
+		checkBinary(opts, "openocd.bin_path", str(openocd.BinPath),
+			hint("openocd.bin_path")+" Install openocd if it is missing."),
+	}
+	scripts := checkDir("openocd.scripts_path", str(openocd.ScriptsPath),
+		hint("openocd.scripts_path")+" It is the directory that contains 'interface' and 'target'.")
+	r = append(r, scripts)
+
+	iface := Result{Check: "openocd.interface"}
+	switch {
+	case str(openocd.Interface) == "":
+		iface.Status, iface.Message, iface.Hint = StatusFail, "not configured",
+			hint("openocd.interface")+" e.g. 'stlink.cfg'."
+	case scripts.Status != StatusPass:
+		iface.Status, iface.Message = StatusWarn, "can't be checked without openocd.scripts_path"
+	default:
+		path := filepath.Join(expand(*openocd.ScriptsPath), "interface", *openocd.Interface)
+		if _, err := os.Stat(path); err != nil {
+			iface.Status, iface.Message, iface.Hint = StatusFail, fmt.Sprintf("%q doesn't exist", path),
+				"Use one of the files in the openocd 'scripts/interface' directory."
+		} else {
+			iface.Status, iface.Message = StatusPass, path
+		}
+	}
+	r = append(r, iface)
+
+	if tool.BuildOptions != nil {
+		if err := tool.BuildOptions.Validate(); err != nil {
+			r = append(r, Result{"build_options", StatusFail, err.Error(),
+				"Fix 'build_options' in ergomcutool_config.yaml (user or local)."})
+		}
+	}
+	return r
+}
+
+// projectPath returns the file system path of the project path.
+func projectPath(opts *Options, path string) string {
+	path = expand(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(opts.ProjectDir, path)
+}
+
+// checkProject checks the project settings, the .ioc file and the symlinks.
+func checkProject(opts *Options) []Result {
+	pc := opts.Project
+	r := make([]Result, 0, 10)
+
+	// openocd target and svd file
+	openocd := pc.Openocd
+	if openocd == nil {
+		openocd = &proj.OpenocdDescriptor{}
+	}
+	if !openocd.Disabled {
+		target := Result{Check: "project openocd.target"}
+		scriptsPath := ""
+		if opts.Tool.Openocd != nil {
+			scriptsPath = str(opts.Tool.Openocd.ScriptsPath)
+		}
+		path := filepath.Join(expand(scriptsPath), "target", str(openocd.Target))
+		switch {
+		case str(openocd.Target) == "":
+			target.Status, target.Message = StatusFail, "not configured"
+			target.Hint = "Set 'openocd.target' in ergomcutool/ergomcu_project.yaml."
+		case scriptsPath == "" || !dirExists(scriptsPath):
+			target.Status, target.Message = StatusWarn, "can't be checked without openocd.scripts_path"
+		default:
+			if _, err := os.Stat(path); err != nil {
+				target.Status, target.Message = StatusFail, fmt.Sprintf("%q doesn't exist", path)
+				target.Hint = "Set 'openocd.target' in ergomcutool/ergomcu_project.yaml " +
+					"to one of the files in the openocd 'scripts/target' directory."
+			} else {
+				target.Status, target.Message = StatusPass, path
+			}
+		}
+		r = append(r, target)
+	}
+
+	svd := Result{Check: "svd_file_path"}
+	svdPath := openocd.SvdFilePath
+	if opts.Tool.Openocd != nil && opts.Tool.Openocd.SvdFilePath != "" {
+		svdPath = opts.Tool.Openocd.SvdFilePath
+	}
+	if svdPath == "" {
+		svd.Status, svd.Message = StatusPass, "not configured"
+	} else if _, err := os.Stat(projectPath(opts, svdPath)); err != nil {
+		svd.Status, svd.Message = StatusWarn, fmt.Sprintf("%q doesn't exist", svdPath)
+		svd.Hint = "Fix 'openocd.svd_file_path', the peripheral registers are not shown in the debugger without it."
+	} else {
+		svd.Status, svd.Message = StatusPass, svdPath
+	}
+	r = append(r, svd)
+
+	r = append(r, checkIocScripts(opts)...)
+	r = append(r, checkLinks(opts)...)
+	return r
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(expand(path))
+	return err == nil && info.IsDir()
+}
+
+// checkIocScripts checks that the CubeMX user action scripts
+// in the .ioc file point to the existing executable scripts.
+func checkIocScripts(opts *Options) []Result {
+	iocFiles, err := iocfile.FindIocFiles(opts.ProjectDir)
+	if err != nil || len(iocFiles) == 0 {
+		return []Result{{"ioc", StatusWarn, "no .ioc file found",
+			"Generate the .ioc file using STM32CubeMX."}}
+	}
+	ioc, err := iocfile.FromFile(filepath.Join(opts.ProjectDir, iocFiles[0]))
+	if err != nil {
+		return []Result{{iocFiles[0], StatusFail, fmt.Sprintf("failed to read: %v", err), ""}}
+	}
+	scripts := []struct{ key, expected string }{
+		{"ProjectManager.UAScriptBeforePath", config.CubeMXBeforeGenerateScript},
+		{"ProjectManager.UAScriptAfterPath", config.CubeMXAfterGenerateScript},
+	}
+	r := make([]Result, 0, len(scripts))
+	for _, s := range scripts {
+		res := Result{Check: iocFiles[0] + " " + s.key}
+		value, _ := ioc.Get(s.key)
+		setHint := fmt.Sprintf("Run 'ergomcutool ioc set %s=%s'.", s.key, s.expected)
+		if value == "" {
+			res.Status, res.Message, res.Hint = StatusWarn, "not set", setHint
+			r = append(r, res)
+			continue
+		}
+		info, err := os.Stat(projectPath(opts, value))
+		switch {
+		case err != nil:
+			res.Status, res.Message = StatusFail, fmt.Sprintf("script %q doesn't exist", value)
+			res.Hint = setHint
+		case !isExecutable(info):
+			res.Status, res.Message = StatusFail, fmt.Sprintf("script %q is not executable", value)
+			res.Hint = fmt.Sprintf("Run 'chmod +x %s'.", value)
+		default:
+			res.Status, res.Message = StatusPass, value
+		}
+		r = append(r, res)
+	}
+	return r
+}
+
+// checkLinks checks the external dependencies and their symlinks in '_external'.
+func checkLinks(opts *Options) []Result {
+	externalDir := filepath.Join(opts.ProjectDir, "_external")
+	r := make([]Result, 0, len(opts.Project.ExternalDependencies))
+	for _, d := range opts.Project.ExternalDependencies {
+		res := Result{Check: "external dependency " + d.Var}
+		if d.Path == "" || !dirExists(projectPath(opts, d.Path)) {
+			res.Status, res.Message = StatusFail, fmt.Sprintf("path %q doesn't exist", d.Path)
+			res.Hint = "Fix the dependency path in ergomcutool_config.yaml or ergomcu_project.yaml."
+			if d.Git != "" {
+				res.Hint = "Run 'ergomcutool deps fetch'."
+			}
+			r = append(r, res)
+			continue
+		}
+		// LinkStatus resolves the dependency path relative to CWD
+		dep := d
+		dep.Path = projectPath(opts, d.Path)
+		switch status := deps.LinkStatus(&dep, externalDir); status {
+		case deps.LinkOk, deps.LinkNone:
+			res.Status, res.Message = StatusPass, d.Path
+		case deps.LinkDangling:
+			res.Status, res.Message = StatusFail, fmt.Sprintf("symlink '_external/%s' is dangling", d.LinkName)
+			res.Hint = "Run 'ergomcutool update-project'."
+		default:
+			res.Status, res.Message = StatusWarn, fmt.Sprintf("symlink '_external/%s': %s", d.LinkName, status)
+			res.Hint = "Run 'ergomcutool update-project'."
+		}
+		r = append(r, res)
+	}
+
+	dangling, err := deps.DanglingLinks(externalDir)
+	if err != nil {
+		r = append(r, Result{"_external", StatusWarn, fmt.Sprintf("failed to read: %v", err), ""})
+	}
+	for _, link := range dangling {
+		rel, _ := filepath.Rel(opts.ProjectDir, link)
+		r = append(r, Result{filepath.ToSlash(rel), StatusWarn, "dangling symlink",
+			fmt.Sprintf("Remove it if it isn't used: 'rm %s'.", filepath.ToSlash(rel))})
+	}
+	return r
+}
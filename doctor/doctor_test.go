@@ -0,0 +1,137 @@
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeProbe(bin string) (string, error) {
+	if filepath.Base(bin) == "broken" {
+		return "", fmt.Errorf("exit status 1")
+	}
+	return filepath.Base(bin) + " 1.0", nil
+}
+
+func writeFile(t *testing.T, path string, perm os.FileMode) {
+	require.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.Nil(t, os.WriteFile(path, []byte("#!/bin/sh\n"), perm))
+}
+
+func find(t *testing.T, results []Result, check string) Result {
+	for _, r := range results {
+		if r.Check == check {
+			return r
+		}
+	}
+	require.Failf(t, "check not found", "%q", check)
+	return Result{}
+}
+
+func TestRunTools(t *testing.T) {
+	dir := t.TempDir()
+	gcc := filepath.Join(dir, "bin", "arm-none-eabi-gcc")
+	writeFile(t, gcc, 0o755)
+	writeFile(t, filepath.Join(dir, "bin", "broken"), 0o755)
+	writeFile(t, filepath.Join(dir, "bin", "noexec"), 0o644)
+	scripts := filepath.Join(dir, "scripts")
+	writeFile(t, filepath.Join(scripts, "interface", "stlink.cfg"), 0o644)
+
+	s := func(v string) *string { return &v }
+	tool := &config.ToolConfigT{
+		General: &config.ToolConfig_GeneralT{
+			ArmToolchainPath: s(filepath.Join(dir, "bin")),
+			CCompilerPath:    s(gcc),
+			CppCompilerPath:  s(filepath.Join(dir, "bin", "noexec")),
+			DebuggerPath:     s(filepath.Join(dir, "bin", "broken")),
+		},
+		Openocd: &config.ToolConfig_OpenOcdT{
+			Interface:   s("missing.cfg"),
+			BinPath:     s(filepath.Join(dir, "bin", "openocd")),
+			ScriptsPath: s(scripts),
+		},
+	}
+	results := Run(Options{Tool: tool, Probe: fakeProbe})
+	require.Len(t, results, 7)
+	require.Equal(t, StatusPass, find(t, results, "general.arm_toolchain_path").Status)
+	cc := find(t, results, "general.c_compiler_path")
+	require.Equal(t, StatusPass, cc.Status)
+	require.Equal(t, "arm-none-eabi-gcc 1.0", cc.Message)
+	require.Equal(t, StatusFail, find(t, results, "general.cpp_compiler_path").Status)
+	require.Equal(t, StatusWarn, find(t, results, "general.debugger_path").Status)
+	require.Equal(t, StatusFail, find(t, results, "openocd.bin_path").Status)
+	require.Equal(t, StatusPass, find(t, results, "openocd.scripts_path").Status)
+	require.Equal(t, StatusFail, find(t, results, "openocd.interface").Status)
+
+	*tool.Openocd.Interface = "stlink.cfg"
+	results = Run(Options{Tool: tool, Probe: fakeProbe})
+	require.Equal(t, StatusPass, find(t, results, "openocd.interface").Status)
+	require.Equal(t, map[Status]int{StatusPass: 4, StatusWarn: 1, StatusFail: 2}, Counts(results))
+
+	// Each missing or invalid setting is reported
+	debug := "2"
+	tool = &config.ToolConfigT{
+		General:      &config.ToolConfig_GeneralT{CCompilerPath: s(gcc)},
+		BuildOptions: &config.BuildOptionsT{Debug: &debug},
+	}
+	results = Run(Options{Tool: tool, Probe: fakeProbe})
+	require.Equal(t, StatusPass, find(t, results, "general.c_compiler_path").Status)
+	for _, check := range []string{"general.arm_toolchain_path", "general.cpp_compiler_path",
+		"general.debugger_path", "openocd.bin_path", "openocd.scripts_path", "openocd.interface"} {
+		r := find(t, results, check)
+		require.Equal(t, StatusFail, r.Status, check)
+		require.Equal(t, "not configured", r.Message, check)
+	}
+	require.Equal(t, StatusFail, find(t, results, "build_options").Status)
+}
+
+func TestRunProject(t *testing.T) {
+	dir := t.TempDir()
+	scripts := filepath.Join(dir, "scripts")
+	writeFile(t, filepath.Join(scripts, "target", "stm32g4x.cfg"), 0o644)
+	writeFile(t, filepath.Join(dir, config.CubeMXBeforeGenerateScript), 0o755)
+	writeFile(t, filepath.Join(dir, config.CubeMXAfterGenerateScript), 0o644)
+	ioc := fmt.Sprintf("ProjectManager.UAScriptBeforePath=%s\nProjectManager.UAScriptAfterPath=%s\n",
+		config.CubeMXBeforeGenerateScript, config.CubeMXAfterGenerateScript)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "board.ioc"), []byte(ioc), 0o644))
+
+	lib := filepath.Join(dir, "lib")
+	require.Nil(t, os.Mkdir(lib, 0o755))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "_external"), 0o755))
+	require.Nil(t, os.Symlink(lib, filepath.Join(dir, "_external", "lib")))
+	require.Nil(t, os.Symlink(filepath.Join(dir, "none"), filepath.Join(dir, "_external", "old")))
+
+	target := "stm32g4x.cfg"
+	tool := &config.ToolConfigT{Openocd: &config.ToolConfig_OpenOcdT{ScriptsPath: &scripts}}
+	pc := &proj.ErgomcuProjectT{
+		Openocd: &proj.OpenocdDescriptor{Target: &target, SvdFilePath: "board.svd"},
+		ExternalDependencies: []config.ExternalDependencyT{
+			{Var: "LIB", Path: "lib", CreateInProjectLink: true, LinkName: "lib"},
+			{Var: "OTHER", Path: "other", CreateInProjectLink: true, LinkName: "other"},
+			{Var: "GIT_LIB", Git: "https://example.com/lib.git"},
+		},
+	}
+	results := Run(Options{Tool: tool, Project: pc, ProjectDir: dir, Probe: fakeProbe})
+	require.Equal(t, StatusPass, find(t, results, "project openocd.target").Status)
+	require.Equal(t, StatusWarn, find(t, results, "svd_file_path").Status)
+	require.Equal(t, StatusPass, find(t, results, "board.ioc ProjectManager.UAScriptBeforePath").Status)
+	after := find(t, results, "board.ioc ProjectManager.UAScriptAfterPath")
+	require.Equal(t, StatusFail, after.Status)
+	require.Contains(t, after.Hint, "chmod +x")
+	require.Equal(t, StatusPass, find(t, results, "external dependency LIB").Status)
+	require.Equal(t, StatusFail, find(t, results, "external dependency OTHER").Status)
+	require.Contains(t, find(t, results, "external dependency GIT_LIB").Hint, "deps fetch")
+	require.Equal(t, StatusWarn, find(t, results, "_external/old").Status)
+
+	// The link of an existing dependency is missing
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "other"), 0o755))
+	results = Run(Options{Tool: tool, Project: pc, ProjectDir: dir, Probe: fakeProbe})
+	other := find(t, results, "external dependency OTHER")
+	require.Equal(t, StatusWarn, other.Status)
+	require.Contains(t, other.Hint, "update-project")
+}
@@ -0,0 +1,200 @@
+// gitdep package fetches external dependencies from git repositories
+// into the user cache and pins them in the project lockfile.
+package gitdep
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+)
+
+// CacheDir returns the cache directory in the user configuration directory,
+// it contains a bare mirror and the checked out commits of each repository.
+func CacheDir(userConfigDir string) string {
+	return filepath.Join(userConfigDir, "cache", "git")
+}
+
+// git runs the git command and returns its trimmed output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ResolveURL makes the local relative repository path absolute,
+// it is relative to dir, the project root. Other URLs are returned as is.
+// The other functions expect the resolved URLs, since git runs in the cache directory.
+func ResolveURL(dir, url string) string {
+	if strings.Contains(url, "://") || filepath.IsAbs(url) {
+		return url
+	}
+	// scp-like syntax, e.g. 'git@github.com:user/repo.git'
+	if i := strings.Index(url, ":"); i > 0 && !strings.ContainsAny(url[:i], `/\`) {
+		return url
+	}
+	if abs, err := filepath.Abs(filepath.Join(dir, url)); err == nil {
+		return abs
+	}
+	return url
+}
+
+// repoKey returns the cache directory name of the repository,
+// e.g. 'example_lib-1a2b3c4d5e'.
+func repoKey(url string) string {
+	sum := sha1.Sum([]byte(url))
+	name := strings.TrimSuffix(path.Base(filepath.ToSlash(strings.TrimRight(url, "/"))), ".git")
+	if name == "" || name == "." || name == "/" {
+		name = "repo"
+	}
+	return name + "-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// MirrorDir returns the directory of the bare mirror of the repository.
+func MirrorDir(cacheDir, url string) string {
+	return filepath.Join(cacheDir, repoKey(url)+".git")
+}
+
+// CheckoutDir returns the directory the commit is checked out into.
+func CheckoutDir(cacheDir, url, commit string) string {
+	return filepath.Join(cacheDir, repoKey(url), commit)
+}
+
+// Fetch clones the mirror of the repository or updates it if it exists.
+func Fetch(cacheDir, url string) error {
+	mirror := MirrorDir(cacheDir, url)
+	if _, err := os.Stat(mirror); err == nil {
+		_, err = git(mirror, "remote", "update", "--prune")
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, os.FileMode(config.DefaultDirPermissions)); err != nil {
+		return err
+	}
+	_, err := git(cacheDir, "clone", "--quiet", "--mirror", "--", url, mirror)
+	return err
+}
+
+// ResolveRef returns the commit of the ref (branch, tag or commit)
+// in the mirror. An empty ref means the default branch.
+func ResolveRef(cacheDir, url, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return git(MirrorDir(cacheDir, url), "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+}
+
+// hasCommit returns true if the mirror contains the commit.
+func hasCommit(cacheDir, url, commit string) bool {
+	_, err := git(MirrorDir(cacheDir, url), "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// Checkout checks out the commit into CheckoutDir if it isn't there yet
+// and returns the directory.
+func Checkout(cacheDir, url, commit string) (string, error) {
+	dir := CheckoutDir(cacheDir, url, commit)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), os.FileMode(config.DefaultDirPermissions)); err != nil {
+		return "", err
+	}
+	tmp := dir + ".tmp"
+	_ = os.RemoveAll(tmp)
+	if _, err := git(cacheDir, "clone", "--quiet", "--no-checkout", "--", MirrorDir(cacheDir, url), tmp); err != nil {
+		return "", err
+	}
+	if _, err := git(tmp, "checkout", "--quiet", "--detach", commit); err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Sync fetches the git dependencies and checks out their locked commits.
+// The dependencies that are not locked, listed in update
+// or all of them if updateAll is true are resolved from their refs
+// and the lock is updated.
+// The local repository paths are relative to the project root.
+// A failed dependency doesn't stop the others: the lock is updated
+// with the entries that were resolved and the errors are joined.
+// Returns the lock entries that have changed.
+func Sync(root, cacheDir string, deps []config.ExternalDependencyT, lock *Lock,
+	updateAll bool, update []string) ([]LockEntry, error) {
+	changed := make([]LockEntry, 0, len(deps))
+	var errs []error
+	for _, d := range deps {
+		if d.Git == "" {
+			continue
+		}
+		needsUpdate := updateAll
+		for _, v := range update {
+			if v == d.Var {
+				needsUpdate = true
+			}
+		}
+		entry, err := syncDependency(root, cacheDir, d, lock, needsUpdate)
+		if entry != nil {
+			changed = append(changed, *entry)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", d.Var, err))
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// syncDependency fetches the git dependency and checks out its locked commit,
+// the commit is resolved from the ref if needsUpdate is true or it isn't locked.
+// Returns the lock entry if it has changed.
+func syncDependency(root, cacheDir string, d config.ExternalDependencyT, lock *Lock,
+	needsUpdate bool) (*LockEntry, error) {
+	url := ResolveURL(root, d.Git)
+	entry := lock.Find(d.Var)
+	needsUpdate = needsUpdate || entry == nil || entry.Git != d.Git || entry.Ref != d.Ref
+
+	if needsUpdate || !hasCommit(cacheDir, url, entry.Commit) {
+		if err := Fetch(cacheDir, url); err != nil {
+			return nil, err
+		}
+	}
+	var changed *LockEntry
+	if needsUpdate {
+		commit, err := ResolveRef(cacheDir, url, d.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve ref %q", d.Ref)
+		}
+		if entry == nil || entry.Commit != commit || entry.Git != d.Git || entry.Ref != d.Ref {
+			entry = lock.Set(LockEntry{Var: d.Var, Git: d.Git, Ref: d.Ref, Commit: commit})
+			changed = entry
+		}
+	}
+	if !hasCommit(cacheDir, url, entry.Commit) {
+		return changed, fmt.Errorf("locked commit %s doesn't exist in %q", entry.Commit, d.Git)
+	}
+	if _, err := Checkout(cacheDir, url, entry.Commit); err != nil {
+		return changed, err
+	}
+	return changed, nil
+}
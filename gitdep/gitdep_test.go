@@ -0,0 +1,141 @@
+package gitdep
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/stretchr/testify/require"
+)
+
+// createRepo creates a repository with two commits, the first one is tagged 'v1'.
+func createRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := filepath.Join(t.TempDir(), "lib")
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@b",
+			"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@b")
+		out, err := cmd.CombinedOutput()
+		require.Nil(t, err, string(out))
+	}
+	require.Nil(t, os.MkdirAll(dir, 0755))
+	run("init", "-q", "-b", "main")
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "a.c"), []byte("// 1\n"), 0644))
+	run("add", "a.c")
+	run("commit", "-q", "-m", "one")
+	run("tag", "v1")
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "a.c"), []byte("// 2\n"), 0644))
+	run("commit", "-q", "-am", "two")
+	return dir
+}
+
+func TestSync(t *testing.T) {
+	repo := createRepo(t)
+	cache := filepath.Join(t.TempDir(), "cache")
+	url := "file://" + filepath.ToSlash(repo)
+	deps := []config.ExternalDependencyT{
+		{Var: "LIB", Git: url, Ref: "v1"},
+		{Var: "LOCAL", Path: "../local"},
+	}
+
+	lock := &Lock{}
+	changed, err := Sync("", cache, deps, lock, false, nil)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(changed))
+	v1 := lock.Find("LIB").Commit
+	data, err := os.ReadFile(filepath.Join(CheckoutDir(cache, url, v1), "a.c"))
+	require.Nil(t, err)
+	require.Equal(t, "// 1\n", string(data))
+
+	// Locked commits are kept
+	deps[0].Ref = ""
+	lock.Find("LIB").Ref = ""
+	changed, err = Sync("", cache, deps, lock, false, nil)
+	require.Nil(t, err)
+	require.Empty(t, changed)
+	require.Equal(t, v1, lock.Find("LIB").Commit)
+
+	// Update resolves the default branch
+	changed, err = Sync("", cache, deps, lock, false, []string{"LIB"})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(changed))
+	head := lock.Find("LIB").Commit
+	require.NotEqual(t, v1, head)
+	data, err = os.ReadFile(filepath.Join(CheckoutDir(cache, url, head), "a.c"))
+	require.Nil(t, err)
+	require.Equal(t, "// 2\n", string(data))
+
+	// A bare repository as a local path
+	bare := filepath.Join(t.TempDir(), "lib.git")
+	out, err := exec.Command("git", "clone", "-q", "--bare", repo, bare).CombinedOutput()
+	require.Nil(t, err, string(out))
+	lock = &Lock{}
+	_, err = Sync("", cache, []config.ExternalDependencyT{{Var: "LIB", Git: bare, Ref: "v1"}}, lock, false, nil)
+	require.Nil(t, err)
+	require.Equal(t, v1, lock.Find("LIB").Commit)
+
+	_, err = Sync("", cache, []config.ExternalDependencyT{{Var: "LIB", Git: bare, Ref: "missing"}}, lock, true, nil)
+	require.NotNil(t, err)
+
+	// A failed dependency doesn't stop the others
+	lock = &Lock{}
+	changed, err = Sync("", cache, []config.ExternalDependencyT{
+		{Var: "MISSING", Git: filepath.Join(t.TempDir(), "missing.git")},
+		{Var: "LIB", Git: bare, Ref: "v1"},
+	}, lock, false, nil)
+	require.ErrorContains(t, err, `"MISSING"`)
+	require.Equal(t, 1, len(changed))
+	require.Nil(t, lock.Find("MISSING"))
+	require.Equal(t, v1, lock.Find("LIB").Commit)
+}
+
+func TestSyncRelativeURL(t *testing.T) {
+	repo := createRepo(t)
+	cache := filepath.Join(t.TempDir(), "cache")
+	// The local repository path is relative to the project root, not CWD
+	root := filepath.Join(filepath.Dir(repo), "project")
+	deps := []config.ExternalDependencyT{{Var: "LIB", Git: "../lib", Ref: "v1"}}
+	lock := &Lock{}
+	_, err := Sync(root, cache, deps, lock, false, nil)
+	require.Nil(t, err)
+	require.Equal(t, "../lib", lock.Find("LIB").Git)
+	data, err := os.ReadFile(filepath.Join(CheckoutDir(cache, repo, lock.Find("LIB").Commit), "a.c"))
+	require.Nil(t, err)
+	require.Equal(t, "// 1\n", string(data))
+	require.Equal(t, repo, ResolveURL(root, "../lib"))
+	require.Equal(t, "git@github.com:user/lib.git", ResolveURL(root, "git@github.com:user/lib.git"))
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ergomcu.lock")
+	lock, err := ReadLock(path)
+	require.Nil(t, err)
+	require.Empty(t, lock.Dependencies)
+
+	lock.Set(LockEntry{Var: "B", Git: "b.git", Commit: "2"})
+	lock.Set(LockEntry{Var: "A", Git: "a.git", Ref: "v1", Commit: "1"})
+	lock.Set(LockEntry{Var: "B", Git: "b.git", Commit: "3"})
+	written, err := lock.Write(path)
+	require.Nil(t, err)
+	require.True(t, written)
+	written, err = lock.Write(path)
+	require.Nil(t, err)
+	require.False(t, written)
+
+	lock, err = ReadLock(path)
+	require.Nil(t, err)
+	require.Equal(t, []LockEntry{
+		{Var: "A", Git: "a.git", Ref: "v1", Commit: "1"},
+		{Var: "B", Git: "b.git", Commit: "3"},
+	}, lock.Dependencies)
+
+	lock.Prune([]string{"B"})
+	require.Nil(t, lock.Find("A"))
+	require.NotNil(t, lock.Find("B"))
+}
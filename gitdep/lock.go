@@ -0,0 +1,91 @@
+package gitdep
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// LockEntry is the resolved commit of a git dependency.
+type LockEntry struct {
+	Var    string `yaml:"var"`
+	Git    string `yaml:"git"`
+	Ref    string `yaml:"ref,omitempty"`
+	Commit string `yaml:"commit"`
+}
+
+// Lock is the contents of the 'ergomcu.lock' file.
+type Lock struct {
+	Dependencies []LockEntry `yaml:"dependencies"`
+}
+
+const lockHeader = `# This file was generated by ergomcutool, do not edit it manually.
+# Run 'ergomcutool deps update' to update the locked commits.
+`
+
+// ReadLock reads the lockfile. Returns an empty lock if the file doesn't exist.
+func ReadLock(path string) (*Lock, error) {
+	r := &Lock{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	err = yaml.Unmarshal(data, r)
+	return r, err
+}
+
+// Write writes the lockfile if its contents have changed.
+// The entries are sorted by variable.
+func (l *Lock) Write(path string) (bool, error) {
+	sort.Slice(l.Dependencies, func(i, j int) bool {
+		return l.Dependencies[i].Var < l.Dependencies[j].Var
+	})
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+	return utils.WriteFileIfChanged(path, append([]byte(lockHeader), data...),
+		config.DefaultDirPermissions, config.DefaultFilePermissions)
+}
+
+// Find returns the entry of the variable or nil if it isn't locked.
+func (l *Lock) Find(v string) *LockEntry {
+	for i := range l.Dependencies {
+		if l.Dependencies[i].Var == v {
+			return &l.Dependencies[i]
+		}
+	}
+	return nil
+}
+
+// Set adds or replaces the entry and returns it.
+func (l *Lock) Set(e LockEntry) *LockEntry {
+	if existing := l.Find(e.Var); existing != nil {
+		*existing = e
+		return existing
+	}
+	l.Dependencies = append(l.Dependencies, e)
+	return &l.Dependencies[len(l.Dependencies)-1]
+}
+
+// Prune removes the entries of the variables that are not in vars.
+func (l *Lock) Prune(vars []string) {
+	keep := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		keep[v] = true
+	}
+	r := l.Dependencies[:0]
+	for _, e := range l.Dependencies {
+		if keep[e.Var] {
+			r = append(r, e)
+		}
+	}
+	l.Dependencies = r
+}
@@ -0,0 +1,178 @@
+// glob package matches and expands source path patterns.
+// Patterns use the path.Match syntax with '/' separators,
+// '**' matches any number of directories, e.g. 'lib/**/*.c'.
+package glob
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HasMeta returns true if the pattern contains any of '*', '?' or '['.
+func HasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func clean(p string) string {
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// Match reports whether the name matches the pattern.
+// Both are cleaned before matching, so 'src/a.c' matches './src/*.c'.
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(clean(pattern), "/"), strings.Split(clean(name), "/"))
+}
+
+// MatchAny reports whether the name matches any of the patterns.
+func MatchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// base returns the longest leading part of the pattern without meta characters.
+func base(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	i := 0
+	for ; i < len(segments); i++ {
+		if HasMeta(segments[i]) {
+			break
+		}
+	}
+	switch {
+	case i == 0:
+		return "."
+	case i == 1 && segments[0] == "":
+		return "/"
+	}
+	return strings.Join(segments[:i], "/")
+}
+
+// walkRoot appends the trailing separator to the directory,
+// which makes WalkDir follow the root symlink.
+func walkRoot(dir string) string {
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		return dir
+	}
+	return dir + string(filepath.Separator)
+}
+
+// join returns the file system path of the relative path in the root directory.
+func join(root, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(root, p)
+}
+
+// Expand returns the files (or directories if dirs is true)
+// that match the pattern, sorted by path.
+// Relative patterns are relative to the root directory,
+// and so are the returned paths.
+// The base directory may be a symlink, symlinks below it are not followed.
+// Returns no error if the base directory doesn't exist.
+func Expand(root, pattern string, dirs bool) ([]string, error) {
+	pattern = clean(pattern)
+	if !HasMeta(pattern) {
+		info, err := os.Stat(join(root, filepath.FromSlash(pattern)))
+		if err != nil || info.IsDir() != dirs {
+			return nil, nil
+		}
+		return []string{filepath.FromSlash(pattern)}, nil
+	}
+	patternBase := filepath.FromSlash(base(pattern))
+	baseDir := join(root, patternBase)
+	info, err := os.Stat(baseDir)
+	if err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	r := make([]string, 0, 20)
+	err = filepath.WalkDir(walkRoot(baseDir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(baseDir, p)
+		if err != nil {
+			return err
+		}
+		p = filepath.Join(patternBase, rel)
+		if d.IsDir() == dirs && Match(pattern, p) {
+			r = append(r, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return filepath.ToSlash(r[i]) < filepath.ToSlash(r[j])
+	})
+	return r, nil
+}
+
+// Walk returns the files with the specified extensions in the directory,
+// in subdirectories as well if recursive is true, sorted by path.
+// A relative directory is relative to the root directory,
+// and so are the returned paths.
+func Walk(root, dir string, recursive bool, extensions ...string) ([]string, error) {
+	r := make([]string, 0, 20)
+	dir = filepath.Clean(dir)
+	walkDir := filepath.Clean(join(root, dir))
+	err := filepath.WalkDir(walkRoot(walkDir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if !recursive && filepath.Clean(p) != walkDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		for _, ext := range extensions {
+			if strings.HasSuffix(p, ext) {
+				rel, err := filepath.Rel(walkDir, p)
+				if err != nil {
+					return err
+				}
+				r = append(r, filepath.Join(dir, rel))
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return filepath.ToSlash(r[i]) < filepath.ToSlash(r[j])
+	})
+	return r, nil
+}
@@ -0,0 +1,92 @@
+package glob
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	require.True(t, Match("src/*.c", "src/a.c"))
+	require.True(t, Match("./src/*.c", "src/a.c"))
+	require.False(t, Match("src/*.c", "src/sub/a.c"))
+	require.True(t, Match("src/**/*.c", "src/a.c"))
+	require.True(t, Match("src/**/*.c", "src/sub/dir/a.c"))
+	require.False(t, Match("src/**/*.c", "lib/a.c"))
+	require.True(t, Match("**/test_*.c", "lib/sub/test_x.c"))
+	require.True(t, Match("src/**", "src/sub/a.c"))
+	require.True(t, Match("/abs/**/*.c", "/abs/x/a.c"))
+	require.False(t, Match("src/[ab].c", "src/c.c"))
+	require.True(t, MatchAny([]string{"x/*", "src/*.c"}, "src/a.c"))
+	require.False(t, HasMeta("{{.EXAMPLE_LIB}}/file.c"))
+	require.True(t, HasMeta("lib/**/*.c"))
+}
+
+func slash(l []string) []string {
+	r := make([]string, 0, len(l))
+	for _, s := range l {
+		r = append(r, filepath.ToSlash(s))
+	}
+	return r
+}
+
+func TestExpand(t *testing.T) {
+	r, err := Expand(".", "test_data/lib/**/*.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/lib/a/y.c", "test_data/lib/b/sub/w.c", "test_data/lib/x.c"}, slash(r))
+
+	r, err = Expand(".", "test_data/*/*.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/lib/x.c", "test_data/other/o.c"}, slash(r))
+
+	r, err = Expand(".", "test_data/lib/**/include", true)
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/lib/a/include"}, slash(r))
+
+	r, err = Expand(".", "test_data/lib/x.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/lib/x.c"}, slash(r))
+
+	// The base directory is a symlink
+	r, err = Expand(".", "test_data/link/**/*.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/link/a/y.c", "test_data/link/b/sub/w.c", "test_data/link/x.c"}, slash(r))
+
+	r, err = Expand(".", "test_data/missing/**/*.c", false)
+	require.Nil(t, err)
+	require.Empty(t, r)
+}
+
+func TestWalk(t *testing.T) {
+	r, err := Walk(".", "test_data/lib", false, ".c")
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/lib/x.c"}, slash(r))
+
+	r, err = Walk(".", "test_data/lib", true, ".c", ".cpp")
+	require.Nil(t, err)
+	require.Equal(t, []string{"test_data/lib/a/y.c", "test_data/lib/b/sub/w.c",
+		"test_data/lib/b/v.cpp", "test_data/lib/x.c"}, slash(r))
+
+	_, err = Walk(".", "test_data/missing", false, ".c")
+	require.NotNil(t, err)
+}
+
+func TestRoot(t *testing.T) {
+	// Relative paths are relative to the root, not to CWD
+	r, err := Expand("test_data", "lib/**/*.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"lib/a/y.c", "lib/b/sub/w.c", "lib/x.c"}, slash(r))
+
+	r, err = Expand("test_data/lib", "../other/*.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"../other/o.c"}, slash(r))
+
+	r, err = Expand("test_data", "lib/x.c", false)
+	require.Nil(t, err)
+	require.Equal(t, []string{"lib/x.c"}, slash(r))
+
+	r, err = Walk("test_data", "lib/b", true, ".c", ".cpp")
+	require.Nil(t, err)
+	require.Equal(t, []string{"lib/b/sub/w.c", "lib/b/v.cpp"}, slash(r))
+}
@@ -0,0 +1,111 @@
+package intellisense
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/changes"
+)
+
+// CompileCommand is an entry of the 'compile_commands.json' compilation database.
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Arguments []string `json:"arguments"`
+	Output    string   `json:"output"`
+}
+
+// CompileCommandsOptions describe the project translation units.
+// Relative paths are resolved against Directory.
+type CompileCommandsOptions struct {
+	// Directory is the absolute path to the project root.
+	Directory   string
+	BuildDir    string
+	CCompiler   string
+	CppCompiler string
+	// CFlags are the compiler flags without include directories
+	// and definitions, e.g. '-mcpu=cortex-m4 -mthumb -Og -Wall'.
+	CFlags []string
+	// CppFlags are the additional flags for C++ sources.
+	CppFlags    []string
+	IncludeDirs []string
+	Defines     []string
+	CSources    []string
+	CppSources  []string
+}
+
+// CompileCommands creates one entry per C and C++ translation unit.
+func CompileCommands(o CompileCommandsOptions) []CompileCommand {
+	common := make([]string, 0, len(o.CFlags)+len(o.IncludeDirs)+len(o.Defines))
+	common = append(common, cleanCompilerFlags(o.CFlags, o.Directory)...)
+	for _, d := range o.IncludeDirs {
+		common = append(common, "-I"+absPath(o.Directory, d))
+	}
+	for _, d := range o.Defines {
+		common = append(common, "-D"+d)
+	}
+
+	r := make([]CompileCommand, 0, len(o.CSources)+len(o.CppSources))
+	add := func(compiler string, extraFlags []string, file string) {
+		file = absPath(o.Directory, file)
+		base := filepath.Base(file)
+		output := absPath(o.Directory,
+			filepath.Join(o.BuildDir, strings.TrimSuffix(base, filepath.Ext(base))+".o"))
+		args := make([]string, 0, len(common)+len(extraFlags)+5)
+		args = append(args, compiler)
+		args = append(args, common...)
+		args = append(args, extraFlags...)
+		args = append(args, "-c", file, "-o", output)
+		r = append(r, CompileCommand{Directory: o.Directory, File: file,
+			Arguments: args, Output: output})
+	}
+	for _, f := range o.CSources {
+		add(o.CCompiler, nil, f)
+	}
+	cppFlags := cleanCompilerFlags(o.CppFlags, o.Directory)
+	for _, f := range o.CppSources {
+		add(o.CppCompiler, cppFlags, f)
+	}
+	return r
+}
+
+// WriteCompileCommands writes 'compile_commands.json' through the change set.
+// The file is only written if its contents have changed.
+func WriteCompileCommands(c *changes.Set, path string, commands []CompileCommand) (bool, error) {
+	data, err := json.MarshalIndent(commands, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	data = append(data, '\n')
+	return c.WriteFile(path, data)
+}
+
+// cleanCompilerFlags removes the flags that make no sense outside
+// of the Makefile recipes: dependency generation flags and flags that
+// contain unexpanded make references. Relative include paths become absolute.
+func cleanCompilerFlags(flags []string, dir string) []string {
+	r := make([]string, 0, len(flags))
+	for i := 0; i < len(flags); i++ {
+		f := flags[i]
+		switch {
+		case f == "-MMD" || f == "-MD" || f == "-MP":
+		case f == "-MF" || f == "-MT" || f == "-MQ":
+			i++
+		case strings.HasPrefix(f, "-MF") || strings.HasPrefix(f, "-MT") || strings.HasPrefix(f, "-MQ"):
+		case strings.Contains(f, "$"):
+		case strings.HasPrefix(f, "-I") && len(f) > 2:
+			r = append(r, "-I"+absPath(dir, f[2:]))
+		default:
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
+func absPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
@@ -3,12 +3,11 @@ package intellisense
 import (
 	"encoding/json"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/changes"
 	"github.com/mcu-art/ergomcutool/utils"
 )
 
@@ -18,6 +17,8 @@ type CCppPropertiesReplacements struct {
 	IncludePath  []string `json:"includePath"`
 	Defines      []string `json:"defines"`
 	CompilerPath string   `json:"compilerPath"`
+	// Variants are added as separate configurations
+	Variants []CCppPropertiesVariant `json:"-"`
 }
 
 // LaunchReplacements are JSON entries for 'launch.json'
@@ -26,6 +27,8 @@ type LaunchReplacements struct {
 	Executable  string   `json:"executable"`
 	ConfigFiles []string `json:"configFiles"`
 	SvdFile     string   `json:"svdFile"`
+	// Variants are added as separate configurations
+	Variants []LaunchVariant `json:"-"`
 }
 
 // SettingsReplacements are JSON entries for 'settings.json'
@@ -99,26 +102,25 @@ func findConfigurations(
 // so that it contains values required for VSCode intellisense.
 // If the file doesn't exist, it will be created
 // from the 'c_cpp_properties.persistent.json'.
-func ProcessCCppPropertiesJson(r CCppPropertiesReplacements) error {
+// The file in the project directory dir is written through the change set.
+func ProcessCCppPropertiesJson(c *changes.Set, dir string, r CCppPropertiesReplacements) error {
 	var err error
 	// Read c_cpp_properties.json if exists
-	currentFile := filepath.Join(".vscode", "c_cpp_properties.json")
-	persistentFile := filepath.Join(".vscode", "c_cpp_properties.persistent.json")
+	currentFile := filepath.Join(dir, ".vscode", "c_cpp_properties.json")
+	persistentFile := filepath.Join(dir, ".vscode", "c_cpp_properties.persistent.json")
 	currentFileExists := utils.FileExists(currentFile)
 
 	var currentFileMap map[string]any
 	var persistentFileMap map[string]any
 
-	// If c_cpp_properties.json doesn't exist, create it as a copy
-	// of c_cpp_properties.persistent.json
+	// If c_cpp_properties.json doesn't exist, it is created from
+	// c_cpp_properties.persistent.json
+	baseFile := currentFile
 	if !currentFileExists {
-		err = utils.CopyFile(persistentFile, currentFile)
-		if err != nil {
-			return fmt.Errorf("failed to copy %q to %q: %w", persistentFile, currentFile, err)
-		}
+		baseFile = persistentFile
 	}
 
-	currentFileMap, err = readJsonFileToMap(currentFile)
+	currentFileMap, err = readJsonFileToMap(baseFile)
 	if err != nil {
 		return fmt.Errorf("failed to read %q: %w", currentFile, err)
 	}
@@ -134,6 +136,10 @@ func ProcessCCppPropertiesJson(r CCppPropertiesReplacements) error {
 	}
 
 	prefix := "linux-gcc-arm"
+	// Generated variant configurations are re-created below
+	if err = replaceVariantEntries(currentFileMap, "configurations", "name", prefix, nil); err != nil {
+		return fmt.Errorf("failed to read %q: %w", currentFile, err)
+	}
 	destConfigs, err := findConfigurations(currentFileMap, prefix)
 	if err != nil {
 		return fmt.Errorf("failed to read %q: %w", currentFile, err)
@@ -168,39 +174,52 @@ func ProcessCCppPropertiesJson(r CCppPropertiesReplacements) error {
 		configs[dest.Index] = dest.C
 	}
 
+	variantConfigs := make([]map[string]any, 0, len(r.Variants))
+	for _, v := range r.Variants {
+		c := copyMap(srcConfigs[0].C)
+		c["name"] = VariantName(prefix, v.Name)
+		c["includePath"] = r.IncludePath
+		c["defines"] = v.Defines
+		c["compilerPath"] = r.CompilerPath
+		variantConfigs = append(variantConfigs, c)
+	}
+	if err = replaceVariantEntries(currentFileMap, "configurations", "name", prefix, variantConfigs); err != nil {
+		return fmt.Errorf("failed to update %q: %w", currentFile, err)
+	}
+
 	data, err := json.MarshalIndent(currentFileMap, "", "  ")
 	if err != nil {
 		return fmt.Errorf("ProcessCCppPropertiesJson: failed to marshal json: %w", err)
 	}
 
 	// Save the updated file
-	return os.WriteFile(currentFile, data, fs.FileMode(config.DefaultFilePermissions))
+	_, err = c.WriteFile(currentFile, data)
+	return err
 }
 
 // ProcessLaunchJson processes 'launch.json'
 // so that it contains values required for VSCode intellisense.
 // If the file doesn't exist, it will be created
 // from 'launch.persistent.json'.
-func ProcessLaunchJson(r LaunchReplacements) error {
+// The file in the project directory dir is written through the change set.
+func ProcessLaunchJson(c *changes.Set, dir string, r LaunchReplacements) error {
 	var err error
 	// Read c_cpp_properties.json if exists
-	currentFile := filepath.Join(".vscode", "launch.json")
-	persistentFile := filepath.Join(".vscode", "launch.persistent.json")
+	currentFile := filepath.Join(dir, ".vscode", "launch.json")
+	persistentFile := filepath.Join(dir, ".vscode", "launch.persistent.json")
 	currentFileExists := utils.FileExists(currentFile)
 
 	var currentFileMap map[string]any
 	var persistentFileMap map[string]any
 
-	// If launch.json doesn't exist, create it as a copy
-	// of launch.persistent.json
+	// If launch.json doesn't exist, it is created from
+	// launch.persistent.json
+	baseFile := currentFile
 	if !currentFileExists {
-		err = utils.CopyFile(persistentFile, currentFile)
-		if err != nil {
-			return fmt.Errorf("failed to copy %q to %q: %w", persistentFile, currentFile, err)
-		}
+		baseFile = persistentFile
 	}
 
-	currentFileMap, err = readJsonFileToMap(currentFile)
+	currentFileMap, err = readJsonFileToMap(baseFile)
 	if err != nil {
 		return fmt.Errorf("failed to read %q: %w", currentFile, err)
 	}
@@ -216,6 +235,10 @@ func ProcessLaunchJson(r LaunchReplacements) error {
 	}
 
 	prefix := "STM32_Debug"
+	// Generated variant configurations are re-created below
+	if err = replaceVariantEntries(currentFileMap, "configurations", "name", prefix, nil); err != nil {
+		return fmt.Errorf("failed to read %q: %w", currentFile, err)
+	}
 	destConfigs, err := findConfigurations(currentFileMap, prefix)
 	if err != nil {
 		return fmt.Errorf("failed to read %q: %w", currentFile, err)
@@ -250,39 +273,53 @@ func ProcessLaunchJson(r LaunchReplacements) error {
 		configs[dest.Index] = dest.C
 	}
 
+	variantConfigs := make([]map[string]any, 0, len(r.Variants))
+	for _, v := range r.Variants {
+		c := copyMap(srcConfigs[0].C)
+		c["name"] = VariantName(prefix, v.Name)
+		c["configFiles"] = r.ConfigFiles
+		c["executable"] = v.Executable
+		c["svdFile"] = r.SvdFile
+		c["preLaunchTask"] = VariantName("Build", v.Name)
+		variantConfigs = append(variantConfigs, c)
+	}
+	if err = replaceVariantEntries(currentFileMap, "configurations", "name", prefix, variantConfigs); err != nil {
+		return fmt.Errorf("failed to update %q: %w", currentFile, err)
+	}
+
 	data, err := json.MarshalIndent(currentFileMap, "", "  ")
 	if err != nil {
 		return fmt.Errorf("ProcessLaunchJson: failed to marshal json: %w", err)
 	}
 
 	// Save the updated file
-	return os.WriteFile(currentFile, data, fs.FileMode(config.DefaultFilePermissions))
+	_, err = c.WriteFile(currentFile, data)
+	return err
 }
 
 // ProcessSettingsJson processes 'settings.json'
 // so that it contains values required for VSCode intellisense.
 // If the file doesn't exist, it will be created
 // from 'settings.persistent.json'.
-func ProcessSettingsJson(r SettingsReplacements) error {
+// The file in the project directory dir is written through the change set.
+func ProcessSettingsJson(c *changes.Set, dir string, r SettingsReplacements) error {
 	var err error
 	// Read c_cpp_properties.json if exists
-	currentFile := filepath.Join(".vscode", "settings.json")
-	persistentFile := filepath.Join(".vscode", "settings.persistent.json")
+	currentFile := filepath.Join(dir, ".vscode", "settings.json")
+	persistentFile := filepath.Join(dir, ".vscode", "settings.persistent.json")
 	currentFileExists := utils.FileExists(currentFile)
 
 	var currentFileMap map[string]any
 	var persistentFileMap map[string]any
 
-	// If settings.json doesn't exist, create it as a copy
-	// of settings.persistent.json
+	// If settings.json doesn't exist, it is created from
+	// settings.persistent.json
+	baseFile := currentFile
 	if !currentFileExists {
-		err = utils.CopyFile(persistentFile, currentFile)
-		if err != nil {
-			return fmt.Errorf("failed to copy %q to %q: %w", persistentFile, currentFile, err)
-		}
+		baseFile = persistentFile
 	}
 
-	currentFileMap, err = readJsonFileToMap(currentFile)
+	currentFileMap, err = readJsonFileToMap(baseFile)
 	if err != nil {
 		return fmt.Errorf("failed to read %q: %w", currentFile, err)
 	}
@@ -318,29 +355,61 @@ func ProcessSettingsJson(r SettingsReplacements) error {
 	}
 
 	// Save the updated file
-	return os.WriteFile(currentFile, data, fs.FileMode(config.DefaultFilePermissions))
+	_, err = c.WriteFile(currentFile, data)
+	return err
 }
 
 // ProcessTasksJson processes 'tasks.json'
 // so that it contains values required for VSCode intellisense.
 // If the file doesn't exist, it will be created
 // from 'tasks.persistent.json'.
-func ProcessTasksJson() error {
+// The file in the project directory dir is written through the change set.
+// 'Build (<variant>)' and 'Prog (<variant>)' tasks are added for each build variant.
+func ProcessTasksJson(c *changes.Set, dir string, variants []string) error {
 	var err error
 	// Read c_cpp_properties.json if exists
-	currentFile := filepath.Join(".vscode", "tasks.json")
-	persistentFile := filepath.Join(".vscode", "tasks.persistent.json")
+	currentFile := filepath.Join(dir, ".vscode", "tasks.json")
+	persistentFile := filepath.Join(dir, ".vscode", "tasks.persistent.json")
 	currentFileExists := utils.FileExists(currentFile)
 
-	// If tasks.json doesn't exist, create it as a copy
-	// of tasks.persistent.json
+	// If tasks.json doesn't exist, it is created from
+	// tasks.persistent.json
+	baseFile := currentFile
 	if !currentFileExists {
-		err = utils.CopyFile(persistentFile, currentFile)
-		if err != nil {
-			return fmt.Errorf("failed to copy %q to %q: %w", persistentFile, currentFile, err)
+		baseFile = persistentFile
+	}
+
+	currentFileMap, err := readJsonFileToMap(baseFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", currentFile, err)
+	}
+	original, _ := json.Marshal(currentFileMap)
+
+	tasks := map[string]string{"Build": "make %s", "Prog": "make %s-prog"}
+	for _, label := range []string{"Build", "Prog"} {
+		entries := make([]map[string]any, 0, len(variants))
+		for _, v := range variants {
+			entries = append(entries, map[string]any{
+				"label":          VariantName(label, v),
+				"type":           "shell",
+				"command":        fmt.Sprintf(tasks[label], v),
+				"problemMatcher": []any{},
+			})
+		}
+		if err = replaceVariantEntries(currentFileMap, "tasks", "label", label, entries); err != nil {
+			return fmt.Errorf("failed to update %q: %w", currentFile, err)
 		}
 	}
 
-	// No additional processing required for tasks.json
-	return nil
+	// Keep the file intact if nothing has changed
+	updated, _ := json.Marshal(currentFileMap)
+	if currentFileExists && string(original) == string(updated) {
+		return nil
+	}
+	data, err := json.MarshalIndent(currentFileMap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ProcessTasksJson: failed to marshal json: %w", err)
+	}
+	_, err = c.WriteFile(currentFile, data)
+	return err
 }
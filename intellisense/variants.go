@@ -0,0 +1,66 @@
+package intellisense
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CCppPropertiesVariant is a 'c_cpp_properties.json' configuration
+// of a build variant.
+type CCppPropertiesVariant struct {
+	Name    string
+	Defines []string
+}
+
+// LaunchVariant is a 'launch.json' configuration of a build variant.
+type LaunchVariant struct {
+	Name       string
+	Executable string
+}
+
+// VariantName returns the name of the generated variant entry,
+// e.g. 'STM32_Debug (release)'.
+func VariantName(baseName, variant string) string {
+	return fmt.Sprintf("%s (%s)", baseName, variant)
+}
+
+// isVariantName returns true if the name was created by VariantName.
+func isVariantName(name, baseName string) bool {
+	return strings.HasPrefix(name, baseName+" (") && strings.HasSuffix(name, ")")
+}
+
+// replaceVariantEntries removes the generated variant entries
+// from the 'key' array of the root object and appends the new ones.
+// The entries are identified by the 'nameKey' value.
+func replaceVariantEntries(root map[string]any, key, nameKey, baseName string,
+	entries []map[string]any) error {
+	var current []any
+	if v, ok := root[key]; ok {
+		if current, ok = v.([]any); !ok {
+			return fmt.Errorf("'%s' must be an array", key)
+		}
+	}
+	r := make([]any, 0, len(current)+len(entries))
+	for _, e := range current {
+		if m, ok := e.(map[string]any); ok {
+			if name, ok := m[nameKey].(string); ok && isVariantName(name, baseName) {
+				continue
+			}
+		}
+		r = append(r, e)
+	}
+	for _, e := range entries {
+		r = append(r, e)
+	}
+	root[key] = r
+	return nil
+}
+
+// copyMap returns a shallow copy of the map.
+func copyMap(m map[string]any) map[string]any {
+	r := make(map[string]any, len(m))
+	for k, v := range m {
+		r[k] = v
+	}
+	return r
+}
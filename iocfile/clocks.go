@@ -0,0 +1,160 @@
+package iocfile
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Clock is a named clock signal and its frequency in Hz.
+type Clock struct {
+	Name      string `json:"name"`
+	Frequency uint64 `json:"frequency"`
+}
+
+// ClockSetting is an RCC setting that is not a frequency,
+// e.g. a PLL multiplier or a bus prescaler.
+type ClockSetting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ClockTree is the clock configuration taken from the 'RCC.*' entries.
+type ClockTree struct {
+	// SysclkSource is the system clock source, e.g. 'RCC_SYSCLKSOURCE_PLLCLK'.
+	SysclkSource string `json:"sysclk_source"`
+	// PllSource is the PLL source, e.g. 'RCC_PLLSOURCE_HSE'.
+	PllSource string `json:"pll_source"`
+	// Oscillators are the HSE, HSI, LSE, LSI etc. frequencies.
+	Oscillators []Clock `json:"oscillators"`
+	// Buses are the SYSCLK, HCLK and APB bus frequencies.
+	Buses []Clock `json:"buses"`
+	// Pll are the PLL and VCO output frequencies.
+	Pll []Clock `json:"pll"`
+	// Peripherals are the peripheral kernel clock frequencies.
+	Peripherals []Clock `json:"peripherals"`
+	// Settings are PLL multipliers, dividers, prescalers and other settings.
+	Settings []ClockSetting `json:"settings"`
+}
+
+// clockBuses maps bus names to the RCC keys (without 'RCC.' prefix)
+// that hold their frequencies. The first existing key is used.
+var clockBuses = []struct {
+	name string
+	keys []string
+}{
+	{"SYSCLK", []string{"SYSCLKFreq_VALUE"}},
+	{"HCLK", []string{"HCLKFreq_Value", "AHBFreq_Value"}},
+	{"FCLK", []string{"FCLKCortexFreq_Value"}},
+	{"CORTEX_SYSTICK", []string{"CortexFreq_Value"}},
+	{"APB1", []string{"APB1Freq_Value"}},
+	{"APB1_TIM", []string{"APB1TimFreq_Value"}},
+	{"APB2", []string{"APB2Freq_Value"}},
+	{"APB2_TIM", []string{"APB2TimFreq_Value"}},
+	{"APB3", []string{"APB3Freq_Value"}},
+	{"APB4", []string{"APB4Freq_Value"}},
+}
+
+// Clocks extracts the clock tree from the 'RCC.*' entries.
+// RCC key names are matched case-insensitively because CubeMX
+// uses both '_Value' and '_VALUE' suffixes.
+func (ioc *Ioc) Clocks() (*ClockTree, error) {
+	r := &ClockTree{
+		Oscillators: []Clock{},
+		Buses:       []Clock{},
+		Pll:         []Clock{},
+		Peripherals: []Clock{},
+		Settings:    []ClockSetting{},
+	}
+	rcc := make(map[string]string, 100)
+	originalNames := make(map[string]string, 100)
+	for _, key := range ioc.KeysWithPrefix("RCC.") {
+		v, _ := ioc.Get(key)
+		name := strings.TrimPrefix(key, "RCC.")
+		rcc[strings.ToUpper(name)] = v
+		originalNames[strings.ToUpper(name)] = name
+	}
+	parse := func(name, v string) (uint64, error) {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("RCC.%s: invalid frequency %q", name, v)
+		}
+		return uint64(f + 0.5), nil
+	}
+	used := make(map[string]bool, len(rcc))
+
+	for _, b := range clockBuses {
+		for _, k := range b.keys {
+			k = strings.ToUpper(k)
+			v, ok := rcc[k]
+			if !ok {
+				continue
+			}
+			f, err := parse(k, v)
+			if err != nil {
+				return r, err
+			}
+			r.Buses = append(r.Buses, Clock{Name: b.name, Frequency: f})
+			break
+		}
+		for _, k := range b.keys {
+			used[strings.ToUpper(k)] = true
+		}
+	}
+
+	names := make([]string, 0, len(rcc))
+	for k := range rcc {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := rcc[k]
+		switch {
+		case used[k]:
+		case k == "SYSCLKSOURCE":
+			r.SysclkSource = v
+		case k == "PLLSOURCEVIRTUAL" || k == "PLLSOURCE":
+			if r.PllSource == "" {
+				r.PllSource = v
+			}
+		case k == "IPPARAMETERS" || k == "FAMILYNAME":
+		case strings.HasSuffix(k, "_VALUE") && !strings.HasSuffix(k, "FREQ_VALUE"):
+			// Oscillators: HSE_VALUE, HSI_VALUE, LSE_VALUE, EXTERNAL_CLOCK_VALUE...
+			f, err := parse(k, v)
+			if err != nil {
+				return r, err
+			}
+			r.Oscillators = append(r.Oscillators,
+				Clock{Name: strings.TrimSuffix(k, "_VALUE"), Frequency: f})
+		case strings.HasSuffix(k, "FREQ_VALUE"):
+			f, err := parse(k, v)
+			if err != nil {
+				return r, err
+			}
+			name := strings.TrimSuffix(k, "FREQ_VALUE")
+			c := Clock{Name: name, Frequency: f}
+			if strings.HasPrefix(name, "PLL") || strings.HasPrefix(name, "VCO") {
+				r.Pll = append(r.Pll, c)
+			} else {
+				r.Peripherals = append(r.Peripherals, c)
+			}
+		default:
+			r.Settings = append(r.Settings, ClockSetting{Name: originalNames[k], Value: v})
+		}
+	}
+	return r, nil
+}
+
+// Frequency returns the frequency of the named bus, oscillator,
+// PLL output or peripheral clock.
+func (t *ClockTree) Frequency(name string) (uint64, bool) {
+	for _, l := range [][]Clock{t.Buses, t.Oscillators, t.Pll, t.Peripherals} {
+		for _, c := range l {
+			if strings.EqualFold(c.Name, name) {
+				return c.Frequency, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,234 @@
+package iocfile
+
+import (
+	"sort"
+	"strings"
+)
+
+// ChangeKind specifies how an item was changed between two .ioc revisions.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Change categories in the order they are reported.
+const (
+	CategoryPeripherals = "peripherals"
+	CategoryPins        = "pins"
+	CategoryClocks      = "clocks"
+	CategoryDma         = "dma"
+	CategoryInterrupts  = "interrupts"
+	CategoryMiddleware  = "middleware"
+	CategoryProject     = "project"
+	CategoryOther       = "other"
+)
+
+var categoryOrder = map[string]int{
+	CategoryPeripherals: 0,
+	CategoryPins:        1,
+	CategoryClocks:      2,
+	CategoryDma:         3,
+	CategoryInterrupts:  4,
+	CategoryMiddleware:  5,
+	CategoryProject:     6,
+	CategoryOther:       7,
+}
+
+// MiddlewareNames are the CubeMX middleware components
+// that are listed among the peripherals in 'Mcu.IPn' entries.
+var MiddlewareNames = map[string]bool{
+	"FREERTOS":     true,
+	"FATFS":        true,
+	"LWIP":         true,
+	"MBEDTLS":      true,
+	"USB_DEVICE":   true,
+	"USB_HOST":     true,
+	"LIBJPEG":      true,
+	"PDM2PCM":      true,
+	"TOUCHSENSING": true,
+	"THREADX":      true,
+	"FILEX":        true,
+	"NETXDUO":      true,
+	"USBX":         true,
+	"OPENAMP":      true,
+	"STM32_WPAN":   true,
+	"GRAPHICS":     true,
+}
+
+// Change is a single semantic difference between two .ioc revisions.
+type Change struct {
+	Category string     `json:"category"`
+	Kind     ChangeKind `json:"kind"`
+	// Subject is the changed item, e.g. peripheral or pin name.
+	Subject string `json:"subject"`
+	// Key is the changed attribute of the subject, empty if the subject
+	// itself was added or removed.
+	Key string `json:"key,omitempty"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// isBookkeepingKey returns true for the keys that only duplicate information
+// reported elsewhere (pin and peripheral lists, parameter lists).
+func isBookkeepingKey(key string) bool {
+	if strings.HasPrefix(key, "Mcu.Pin") || strings.HasPrefix(key, "Mcu.IP") {
+		return true
+	}
+	return strings.HasSuffix(key, ".IPParameters") ||
+		strings.HasSuffix(key, ".GPIOParameters") ||
+		strings.HasSuffix(key, ".RequestParameters")
+}
+
+func peripheralCategory(name string) string {
+	if MiddlewareNames[name] {
+		return CategoryMiddleware
+	}
+	return CategoryPeripherals
+}
+
+// keyPrefix returns the part of the key before the first dot.
+func keyPrefix(key string) string {
+	prefix, _, _ := strings.Cut(key, ".")
+	return prefix
+}
+
+// Diff compares two .ioc revisions and returns a list of semantic changes
+// sorted by category, subject and key.
+func Diff(oldIoc, newIoc *Ioc) ([]Change, error) {
+	r := make([]Change, 0, 50)
+
+	oldPins, err := oldIoc.Pins()
+	if err != nil {
+		return r, err
+	}
+	newPins, err := newIoc.Pins()
+	if err != nil {
+		return r, err
+	}
+	oldPeripherals, err := oldIoc.Peripherals(oldPins)
+	if err != nil {
+		return r, err
+	}
+	newPeripherals, err := newIoc.Peripherals(newPins)
+	if err != nil {
+		return r, err
+	}
+
+	// Peripherals and middleware
+	peripheralNames := make(map[string]bool, len(oldPeripherals)+len(newPeripherals))
+	oldPeripheralSet := make(map[string]bool, len(oldPeripherals))
+	for _, p := range oldPeripherals {
+		oldPeripheralSet[p.Name] = true
+		peripheralNames[p.Name] = true
+	}
+	newPeripheralSet := make(map[string]bool, len(newPeripherals))
+	for _, p := range newPeripherals {
+		newPeripheralSet[p.Name] = true
+		peripheralNames[p.Name] = true
+		if !oldPeripheralSet[p.Name] {
+			r = append(r, Change{Category: peripheralCategory(p.Name),
+				Kind: ChangeAdded, Subject: p.Name})
+		}
+	}
+	for _, p := range oldPeripherals {
+		if !newPeripheralSet[p.Name] {
+			r = append(r, Change{Category: peripheralCategory(p.Name),
+				Kind: ChangeRemoved, Subject: p.Name})
+		}
+	}
+
+	// Pins
+	pinNames := make(map[string]bool, len(oldPins)+len(newPins))
+	oldPinSet := make(map[string]Pin, len(oldPins))
+	for _, p := range oldPins {
+		oldPinSet[p.Name] = p
+		pinNames[p.Name] = true
+	}
+	newPinSet := make(map[string]Pin, len(newPins))
+	for _, p := range newPins {
+		newPinSet[p.Name] = p
+		pinNames[p.Name] = true
+		if _, ok := oldPinSet[p.Name]; !ok {
+			r = append(r, Change{Category: CategoryPins, Kind: ChangeAdded,
+				Subject: p.Name, New: p.Signal})
+		}
+	}
+	for _, p := range oldPins {
+		if _, ok := newPinSet[p.Name]; !ok {
+			r = append(r, Change{Category: CategoryPins, Kind: ChangeRemoved,
+				Subject: p.Name, Old: p.Signal})
+		}
+	}
+
+	// Individual entries
+	oldEntries := oldIoc.Entries()
+	newEntries := newIoc.Entries()
+	keys := make(map[string]bool, len(oldEntries)+len(newEntries))
+	for k := range oldEntries {
+		keys[k] = true
+	}
+	for k := range newEntries {
+		keys[k] = true
+	}
+	for key := range keys {
+		if isBookkeepingKey(key) {
+			continue
+		}
+		oldValue, inOld := oldEntries[key]
+		newValue, inNew := newEntries[key]
+		if inOld && inNew && oldValue == newValue {
+			continue
+		}
+		prefix := keyPrefix(key)
+		c := Change{Subject: prefix, Key: key, Old: oldValue, New: newValue}
+		switch {
+		case pinNames[prefix]:
+			// Added and removed pins are already reported
+			_, pinInOld := oldPinSet[prefix]
+			_, pinInNew := newPinSet[prefix]
+			if !pinInOld || !pinInNew {
+				continue
+			}
+			c.Category = CategoryPins
+			c.Key = strings.TrimPrefix(key, prefix+".")
+		case prefix == "RCC":
+			c.Category = CategoryClocks
+		case prefix == "Dma":
+			c.Category = CategoryDma
+		case prefix == "NVIC":
+			c.Category = CategoryInterrupts
+		case prefix == "ProjectManager":
+			c.Category = CategoryProject
+		case MiddlewareNames[prefix]:
+			c.Category = CategoryMiddleware
+		case peripheralNames[prefix]:
+			c.Category = CategoryPeripherals
+		default:
+			c.Category = CategoryOther
+		}
+		switch {
+		case !inOld:
+			c.Kind = ChangeAdded
+		case !inNew:
+			c.Kind = ChangeRemoved
+		default:
+			c.Kind = ChangeModified
+		}
+		r = append(r, c)
+	}
+
+	sort.SliceStable(r, func(i, j int) bool {
+		a, b := r[i], r[j]
+		if a.Category != b.Category {
+			return categoryOrder[a.Category] < categoryOrder[b.Category]
+		}
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		return a.Key < b.Key
+	})
+	return r, nil
+}
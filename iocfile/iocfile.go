@@ -1,19 +1,58 @@
+// iocfile package works with .ioc files generated by STM32CubeMX.
+// The .ioc file is a Java properties file, it is parsed into an ordered
+// list of lines so that it can be modified and written back without
+// collateral changes.
 package iocfile
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/mcu-art/ergomcutool/utils"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 var (
 	ErrEntryNotFound = errors.New("entry not found")
+	ErrEntryExists   = errors.New("entry already exists")
+)
+
+// LineKind specifies the kind of a line in the .ioc file.
+type LineKind uint32
+
+const (
+	// LineEntry is a key/value entry, e.g. 'File.Version=6'.
+	LineEntry LineKind = iota
+	// LineComment is a comment line starting with '#' or '!',
+	// e.g. '#MicroXplorer Configuration settings - do not modify'.
+	LineComment
+	// LineBlank is an empty or whitespace-only line.
+	LineBlank
 )
 
+// Line is a single logical line of the .ioc file.
+type Line struct {
+	Kind LineKind
+	// Key is the unescaped entry key, empty for comments and blank lines.
+	Key string
+	// Value is the unescaped entry value, empty for comments and blank lines.
+	Value string
+	// Raw is the original text of the line. Logical lines that span
+	// several physical lines (trailing backslash) are joined with "\n".
+	// Raw is regenerated when the entry is modified.
+	Raw string
+}
+
 type Ioc struct {
-	Lines      []string
+	Lines []Line
+	// LineEnding specifies the .ioc file line endings ("\n" and "\r\n" are supported).
 	LineEnding string
+	// NoFinalLineEnding is true if the last line is not terminated.
+	NoFinalLineEnding bool
 }
 
 // ParsedIoc contains some fields of interest from the .ioc file
@@ -23,75 +62,399 @@ type ParsedIoc struct {
 	DeviceId           string
 	UAScriptAfterPath  string // ProjectManager.UAScriptAfterPath
 	UAScriptBeforePath string // ProjectManager.UAScriptBeforePath
+	Pins               []Pin
+	Peripherals        []Peripheral
+	Clocks             *ClockTree
+	// ClocksErr is the error of the clock tree, e.g. an invalid 'RCC.*_Value',
+	// Clocks is incomplete then. The other fields don't depend on the clocks.
+	ClocksErr error
 }
 
+// FromFile reads and parses the specified .ioc file.
+// Line endings are detected automatically.
 func FromFile(path string) (*Ioc, error) {
-	ioc := &Ioc{}
-	var err error
-	ioc.Lines, ioc.LineEnding, err = utils.ReadTextFileEx(path)
-	return ioc, err
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &Ioc{}, err
+	}
+	return FromBytes(data)
 }
 
-func (ioc *Ioc) Parse() (*ParsedIoc, error) {
-	result := &ParsedIoc{}
-	for _, line := range ioc.Lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "ProjectManager.ProjectName") {
-			substrings := strings.Split(line, "=")
-			if len(substrings) > 1 {
-				result.ProjectName = substrings[1]
-			}
+// FromBytes parses the .ioc file contents.
+func FromBytes(data []byte) (*Ioc, error) {
+	ioc := &Ioc{LineEnding: "\n"}
+	if len(data) == 0 {
+		return ioc, fmt.Errorf("empty file")
+	}
+	physicalLines := strings.Split(string(data), "\n")
+	if physicalLines[len(physicalLines)-1] == "" {
+		physicalLines = physicalLines[:len(physicalLines)-1]
+	} else {
+		ioc.NoFinalLineEnding = true
+	}
+	for i, line := range physicalLines {
+		if strings.HasSuffix(line, "\r") {
+			ioc.LineEnding = "\r\n"
+			physicalLines[i] = line[:len(line)-1]
 		}
-		if strings.HasPrefix(line, "ProjectManager.DeviceId") {
-			substrings := strings.Split(line, "=")
-			if len(substrings) > 1 {
-				result.DeviceId = substrings[1]
-			}
+	}
+
+	for i := 0; i < len(physicalLines); i++ {
+		raw := physicalLines[i]
+		trimmed := strings.TrimLeft(raw, " \t\f")
+		switch {
+		case trimmed == "":
+			ioc.Lines = append(ioc.Lines, Line{Kind: LineBlank, Raw: raw})
+			continue
+		case trimmed[0] == '#' || trimmed[0] == '!':
+			ioc.Lines = append(ioc.Lines, Line{Kind: LineComment, Raw: raw})
+			continue
+		}
+		// Join continuation lines
+		logical := trimmed
+		for endsWithContinuation(logical) && i+1 < len(physicalLines) {
+			i++
+			raw += "\n" + physicalLines[i]
+			logical = logical[:len(logical)-1] + strings.TrimLeft(physicalLines[i], " \t\f")
+		}
+		key, value, err := splitEntry(logical)
+		if err != nil {
+			return ioc, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		ioc.Lines = append(ioc.Lines, Line{Kind: LineEntry, Key: key, Value: value, Raw: raw})
+	}
+	return ioc, nil
+}
+
+// endsWithContinuation returns true if the line ends
+// with an odd number of backslashes.
+func endsWithContinuation(s string) bool {
+	n := 0
+	for i := len(s) - 1; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitEntry splits a logical line into unescaped key and value.
+func splitEntry(s string) (string, string, error) {
+	sepPos := len(s)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			sepPos = i
+			break
+		}
+	}
+	key, err := unescape(s[:sepPos])
+	if err != nil {
+		return "", "", err
+	}
+	rest := s[sepPos:]
+	rest = strings.TrimLeft(rest, " \t\f")
+	if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	value, err := unescape(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// unescape decodes Java properties escape sequences, e.g. '\:' or '\u00e9'.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
 		}
-		if strings.HasPrefix(line, "ProjectManager.UAScriptAfterPath") {
-			substrings := strings.Split(line, "=")
-			if len(substrings) > 1 {
-				result.UAScriptAfterPath = substrings[1]
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\uXXXX escape sequence in %q", s)
 			}
+			code, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uXXXX escape sequence in %q", s)
+			}
+			i += 4
+			r := rune(code)
+			// The runes above 0xFFFF are escaped as a UTF-16 surrogate pair
+			if utf16.IsSurrogate(r) && i+7 <= len(s) && s[i+1:i+3] == `\u` {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 16); err == nil {
+					if pair := utf16.DecodeRune(r, rune(low)); pair != utf8.RuneError {
+						r = pair
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(s[i])
 		}
-		if strings.HasPrefix(line, "ProjectManager.UAScriptBeforePath") {
-			substrings := strings.Split(line, "=")
-			if len(substrings) > 1 {
-				result.UAScriptBeforePath = substrings[1]
+	}
+	return b.String(), nil
+}
+
+// escape encodes a key or a value the same way CubeMX (java.util.Properties) does.
+func escape(s string, isKey bool) string {
+	b := strings.Builder{}
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			if r > 0xffff {
+				r1, r2 := utf16.EncodeRune(r)
+				b.WriteString(fmt.Sprintf(`\u%04x\u%04x`, r1, r2))
+			} else if r < 0x20 || r > 0x7e {
+				b.WriteString(fmt.Sprintf(`\u%04x`, r))
+			} else {
+				b.WriteRune(r)
 			}
 		}
 	}
+	return b.String()
+}
+
+// newEntryLine creates an entry line with the Raw field generated.
+func newEntryLine(key, value string) Line {
+	return Line{
+		Kind:  LineEntry,
+		Key:   key,
+		Value: value,
+		Raw:   escape(key, true) + "=" + escape(value, false),
+	}
+}
+
+// index returns the index of the line that contains the specified key, or -1.
+func (ioc *Ioc) index(key string) int {
+	for i, line := range ioc.Lines {
+		if line.Kind == LineEntry && line.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse extracts the fields of interest.
+// The clock tree errors are returned in ParsedIoc.ClocksErr.
+func (ioc *Ioc) Parse() (*ParsedIoc, error) {
+	result := &ParsedIoc{}
+	result.ProjectName, _ = ioc.Get("ProjectManager.ProjectName")
+	result.DeviceId, _ = ioc.Get("ProjectManager.DeviceId")
+	result.UAScriptAfterPath, _ = ioc.Get("ProjectManager.UAScriptAfterPath")
+	result.UAScriptBeforePath, _ = ioc.Get("ProjectManager.UAScriptBeforePath")
+	var err error
+	if result.Pins, err = ioc.Pins(); err != nil {
+		return result, err
+	}
+	if result.Peripherals, err = ioc.Peripherals(result.Pins); err != nil {
+		return result, err
+	}
+	result.Clocks, result.ClocksErr = ioc.Clocks()
 	return result, nil
 }
 
-// ReadValue returns the value of the specified key or error if key doesn't exist.
-func (ioc *Ioc) ReadValue(key string) (string, error) {
+// Keys returns all entry keys in the file order.
+func (ioc *Ioc) Keys() []string {
+	r := make([]string, 0, len(ioc.Lines))
 	for _, line := range ioc.Lines {
-		if strings.HasPrefix(line, key) {
-			split := strings.Split(line, "=")
-			if len(split) > 1 {
-				return split[1], nil
-			}
+		if line.Kind == LineEntry {
+			r = append(r, line.Key)
 		}
 	}
-	return "", ErrEntryNotFound
+	return r
+}
+
+// Has returns true if the entry with exactly the specified key exists.
+func (ioc *Ioc) Has(key string) bool {
+	return ioc.index(key) != -1
+}
+
+// Get returns the unescaped value of the specified key
+// and true if the key exists.
+func (ioc *Ioc) Get(key string) (string, bool) {
+	i := ioc.index(key)
+	if i == -1 {
+		return "", false
+	}
+	return ioc.Lines[i].Value, true
+}
+
+// ReadValue returns the value of the specified key or error if key doesn't exist.
+func (ioc *Ioc) ReadValue(key string) (string, error) {
+	v, ok := ioc.Get(key)
+	if !ok {
+		return "", ErrEntryNotFound
+	}
+	return v, nil
+}
+
+// GetInt returns the value of the specified key as an integer.
+// Decimal and hexadecimal ('0x200') values are supported.
+func (ioc *Ioc) GetInt(key string) (int64, error) {
+	v, err := ioc.ReadValue(key)
+	if err != nil {
+		return 0, err
+	}
+	r, err := strconv.ParseInt(strings.TrimSpace(v), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer: %w", key, err)
+	}
+	return r, nil
+}
+
+// GetFloat returns the value of the specified key as a floating point number.
+func (ioc *Ioc) GetFloat(key string) (float64, error) {
+	v, err := ioc.ReadValue(key)
+	if err != nil {
+		return 0, err
+	}
+	r, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number: %w", key, err)
+	}
+	return r, nil
+}
+
+// GetBool returns the value of the specified key as a boolean.
+func (ioc *Ioc) GetBool(key string) (bool, error) {
+	v, err := ioc.ReadValue(key)
+	if err != nil {
+		return false, err
+	}
+	r, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return false, fmt.Errorf("%q is not a boolean: %w", key, err)
+	}
+	return r, nil
+}
+
+// GetList returns the value of the specified key split by commas,
+// e.g. 'RCC.IPParameters'. Empty value results in an empty list.
+func (ioc *Ioc) GetList(key string) ([]string, error) {
+	v, err := ioc.ReadValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if v == "" {
+		return []string{}, nil
+	}
+	return strings.Split(v, ","), nil
 }
 
 // ReplaceValue replaces the value of the specified key and
 // returns the old value and the error if any.
 func (ioc *Ioc) ReplaceValue(key string, value string) (string, error) {
+	i := ioc.index(key)
+	if i == -1 {
+		return "", ErrEntryNotFound
+	}
+	old := ioc.Lines[i].Value
+	ioc.Lines[i] = newEntryLine(key, value)
+	return old, nil
+}
+
+// Insert adds a new entry. The entry is placed before the first entry
+// which key is greater than the specified one, this keeps
+// the alphabetical order CubeMX uses when it saves the file.
+func (ioc *Ioc) Insert(key string, value string) error {
+	if ioc.Has(key) {
+		return ErrEntryExists
+	}
+	pos := len(ioc.Lines)
 	for i, line := range ioc.Lines {
-		if strings.HasPrefix(line, key) {
-			split := strings.Split(line, "=")
-			result := split[0] + "=" + value
-			ioc.Lines[i] = result
-			if len(split) > 1 {
-				return split[1], nil
-			}
-			return "", nil
+		if line.Kind == LineEntry && line.Key > key {
+			pos = i
+			break
+		}
+	}
+	tmp := make([]Line, 0, len(ioc.Lines)+1)
+	tmp = append(tmp, ioc.Lines[:pos]...)
+	tmp = append(tmp, newEntryLine(key, value))
+	tmp = append(tmp, ioc.Lines[pos:]...)
+	ioc.Lines = tmp
+	return nil
+}
+
+// Set replaces the value of the specified key,
+// or inserts a new entry if the key doesn't exist.
+func (ioc *Ioc) Set(key string, value string) {
+	if _, err := ioc.ReplaceValue(key, value); err != nil {
+		_ = ioc.Insert(key, value)
+	}
+}
+
+// Delete removes the entry with the specified key.
+func (ioc *Ioc) Delete(key string) error {
+	i := ioc.index(key)
+	if i == -1 {
+		return ErrEntryNotFound
+	}
+	ioc.Lines = append(ioc.Lines[:i], ioc.Lines[i+1:]...)
+	return nil
+}
+
+// Entries returns a key/value map of all entries.
+func (ioc *Ioc) Entries() map[string]string {
+	r := make(map[string]string, len(ioc.Lines))
+	for _, line := range ioc.Lines {
+		if line.Kind == LineEntry {
+			r[line.Key] = line.Value
+		}
+	}
+	return r
+}
+
+// KeysWithPrefix returns a sorted list of keys that start with the specified prefix.
+func (ioc *Ioc) KeysWithPrefix(prefix string) []string {
+	r := make([]string, 0, 50)
+	for _, line := range ioc.Lines {
+		if line.Kind == LineEntry && strings.HasPrefix(line.Key, prefix) {
+			r = append(r, line.Key)
 		}
 	}
-	return "", ErrEntryNotFound
+	sort.Strings(r)
+	return r
 }
 
 func (ioc *Ioc) Bytes() []byte {
@@ -100,9 +463,16 @@ func (ioc *Ioc) Bytes() []byte {
 
 func (ioc *Ioc) String() string {
 	b := strings.Builder{}
-	for _, line := range ioc.Lines {
-		b.WriteString(line)
-		b.WriteString(ioc.LineEnding)
+	for i, line := range ioc.Lines {
+		b.WriteString(strings.ReplaceAll(line.Raw, "\n", ioc.LineEnding))
+		if i < len(ioc.Lines)-1 || !ioc.NoFinalLineEnding {
+			b.WriteString(ioc.LineEnding)
+		}
 	}
 	return b.String()
 }
+
+// WriteFile writes the .ioc file to the specified path.
+func (ioc *Ioc) WriteFile(path string, filePerm uint32) error {
+	return os.WriteFile(path, ioc.Bytes(), fs.FileMode(filePerm))
+}
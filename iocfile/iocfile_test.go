@@ -1,6 +1,7 @@
 package iocfile
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -55,3 +56,313 @@ func TestReplaceValue(t *testing.T) {
 	_, err = m.ReplaceValue("NOT_EXISTS", "dummy")
 	require.NotNil(t, err)
 }
+
+func TestRoundTrip(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	data, err := os.ReadFile(sample1Path)
+	require.Nil(t, err)
+	m, err := FromBytes(data)
+	require.Nil(t, err)
+	require.Equal(t, string(data), m.String())
+
+	crlf := "#comment\r\nA.B=1\r\n\r\nC.D=x\\:y"
+	m, err = FromBytes([]byte(crlf))
+	require.Nil(t, err)
+	require.Equal(t, "\r\n", m.LineEnding)
+	require.Equal(t, LineComment, m.Lines[0].Kind)
+	require.Equal(t, LineBlank, m.Lines[2].Kind)
+	require.Equal(t, crlf, m.String())
+}
+
+func TestExactKeyMatch(t *testing.T) {
+	m, err := FromBytes([]byte("ProjectManager.ProjectNameXYZ=wrong\nProjectManager.ProjectName=right\n"))
+	require.Nil(t, err)
+	val, err := m.ReadValue("ProjectManager.ProjectName")
+	require.Nil(t, err)
+	require.Equal(t, "right", val)
+
+	_, err = m.ReadValue("ProjectManager.Project")
+	require.ErrorIs(t, err, ErrEntryNotFound)
+}
+
+func TestEscapeSequences(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+	val, err := m.ReadValue("NVIC.BusFault_IRQn")
+	require.Nil(t, err)
+	require.Equal(t, "true:0:0:false:false:true:false:false:false", val)
+
+	m, err = FromBytes([]byte("Path=C\\:\\\\Users\\\\me\nEq=a\\=b=c\nUni=\\u00e9\n"))
+	require.Nil(t, err)
+	val, _ = m.ReadValue("Path")
+	require.Equal(t, `C:\Users\me`, val)
+	val, _ = m.ReadValue("Eq")
+	require.Equal(t, "a=b=c", val)
+	val, _ = m.ReadValue("Uni")
+	require.Equal(t, "é", val)
+
+	_, err = m.ReplaceValue("Path", `D:\x`)
+	require.Nil(t, err)
+	require.Equal(t, `Path=D\:\\x`, m.Lines[0].Raw)
+
+	// The runes above 0xFFFF are escaped as a UTF-16 surrogate pair
+	for _, s := range []string{"é", "日本", "😀", "a😀b\n"} {
+		escaped := escape(s, false)
+		require.NotContains(t, escaped, `\u1f600`)
+		unescaped, err := unescape(escaped)
+		require.Nil(t, err)
+		require.Equal(t, s, unescaped)
+	}
+	require.Equal(t, `\ud83d\ude00`, escape("😀", false))
+}
+
+func TestTypedGetters(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+
+	i, err := m.GetInt("RCC.SYSCLKFreq_VALUE")
+	require.Nil(t, err)
+	require.Equal(t, int64(8000000), i)
+
+	i, err = m.GetInt("ProjectManager.HeapSize")
+	require.Nil(t, err)
+	require.Equal(t, int64(0x200), i)
+
+	b, err := m.GetBool("ProjectManager.KeepUserCode")
+	require.Nil(t, err)
+	require.True(t, b)
+
+	_, err = m.GetBool("Mcu.Family")
+	require.NotNil(t, err)
+
+	l, err := m.GetList("USART1.IPParameters")
+	require.Nil(t, err)
+	require.Equal(t, []string{"VirtualMode-Asynchronous"}, l)
+
+	_, err = m.GetInt("NOT_EXISTS")
+	require.ErrorIs(t, err, ErrEntryNotFound)
+}
+
+func TestInsertAndDelete(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+
+	err := m.Insert("File.Version", "7")
+	require.ErrorIs(t, err, ErrEntryExists)
+
+	err = m.Insert("File.Zzz", "1")
+	require.Nil(t, err)
+	require.Equal(t, 286, len(m.Lines))
+	keys := m.Keys()
+	for i, k := range keys {
+		if k == "File.Zzz" {
+			require.Equal(t, "File.Version", keys[i-1])
+			require.Equal(t, "GPIO.groupedBy", keys[i+1])
+		}
+	}
+
+	err = m.Delete("File.Zzz")
+	require.Nil(t, err)
+	require.False(t, m.Has("File.Zzz"))
+	err = m.Delete("File.Zzz")
+	require.ErrorIs(t, err, ErrEntryNotFound)
+
+	m.Set("board", "nucleo")
+	val, _ := m.ReadValue("board")
+	require.Equal(t, "nucleo", val)
+}
+
+func TestPins(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+	pins, err := m.Pins()
+	require.Nil(t, err)
+	require.Equal(t, 18, len(pins))
+
+	require.Equal(t, Pin{
+		Name:   "PC13",
+		Signal: "GPIO_Input",
+		Label:  "BtnUser1",
+		Pull:   "GPIO_PULLDOWN",
+	}, pins[0])
+
+	require.Equal(t, "PC6", pins[10].Name)
+	require.Equal(t, "GPIO_MODE_OUTPUT_PP", pins[10].Mode)
+	require.Equal(t, "LedUser1", pins[10].Label)
+
+	require.Equal(t, "Asynchronous", pins[11].Mode)
+	require.Equal(t, "USART1_RX", pins[11].Signal)
+	require.True(t, pins[16].Virtual)
+
+	for _, n := range []string{"-1", "1000000000000", "x"} {
+		m.Set("Mcu.PinsNb", n)
+		_, err = m.Pins()
+		require.NotNil(t, err, n)
+	}
+	m.Set("Mcu.PinsNb", "0")
+	pins, err = m.Pins()
+	require.Nil(t, err)
+	require.Empty(t, pins)
+}
+
+func TestPeripherals(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+	parsed, err := m.Parse()
+	require.Nil(t, err)
+	require.Equal(t, 8, len(parsed.Peripherals))
+	require.Equal(t, "DMA", parsed.Peripherals[0].Name)
+	require.Equal(t, 0, len(parsed.Peripherals[0].Pins))
+	require.Equal(t, Peripheral{Name: "USART1", Pins: []string{"PC4", "PA10"}},
+		parsed.Peripherals[5])
+	require.Equal(t, []string{"PA13", "PA14", "VP_SYS_VS_tim6", "VP_SYS_VS_DBSignals"},
+		parsed.Peripherals[4].Pins)
+
+	require.Nil(t, parsed.ClocksErr)
+
+	// An invalid clock value doesn't break the pins and the peripherals
+	m.Set("RCC.FooFreq_Value", "abc")
+	parsed, err = m.Parse()
+	require.Nil(t, err)
+	require.Equal(t, 18, len(parsed.Pins))
+	require.Equal(t, 8, len(parsed.Peripherals))
+	require.ErrorContains(t, parsed.ClocksErr, `RCC.FOOFREQ_VALUE: invalid frequency "abc"`)
+
+	// The 'S_' prefix of the signal is ignored
+	m, _ = FromFile(sample1Path)
+	m.Set("Mcu.Pin18", "PA0")
+	m.Set("Mcu.PinsNb", "19")
+	m.Set("PA0.Signal", "S_TIM2_CH1")
+	m.Set("Mcu.IP8", "TIM2")
+	m.Set("Mcu.IPNb", "9")
+	parsed, err = m.Parse()
+	require.Nil(t, err)
+	require.Equal(t, Peripheral{Name: "TIM2", Pins: []string{"PA0"}}, parsed.Peripherals[8])
+	issues, err := m.Lint([]LintRule{{Name: "unused-peripherals", Check: checkUnusedPeripherals}})
+	require.Nil(t, err)
+	require.Empty(t, issues)
+}
+
+func TestGpioPort(t *testing.T) {
+	cases := []struct {
+		name   string
+		port   string
+		number int
+		ok     bool
+	}{
+		{"PA5", "A", 5, true},
+		{"PC14-OSC32_IN", "C", 14, true},
+		{"PF1-OSC_OUT", "F", 1, true},
+		{"VP_SYS_VS_tim6", "", 0, false},
+		{"PA16", "", 0, false},
+	}
+	for _, c := range cases {
+		p := Pin{Name: c.name}
+		port, number, ok := p.GpioPort()
+		require.Equal(t, c.ok, ok, c.name)
+		require.Equal(t, c.port, port, c.name)
+		require.Equal(t, c.number, number, c.name)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	oldIoc, _ := FromFile(sample1Path)
+	newIoc, _ := FromFile(sample1Path)
+
+	changes, err := Diff(oldIoc, newIoc)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(changes))
+
+	// Reassign a pin, add a peripheral, change the clock
+	newIoc.Set("PC6.Signal", "GPIO_Input")
+	newIoc.Set("Mcu.IP8", "FREERTOS")
+	newIoc.Set("Mcu.IPNb", "9")
+	newIoc.Set("FREERTOS.IPParameters", "Tasks01")
+	newIoc.Set("RCC.SYSCLKFreq_VALUE", "170000000")
+	_ = newIoc.Delete("USART3.VirtualMode-Asynchronous")
+
+	changes, err = Diff(oldIoc, newIoc)
+	require.Nil(t, err)
+	require.Equal(t, []Change{
+		{Category: CategoryPeripherals, Kind: ChangeRemoved, Subject: "USART3",
+			Key: "USART3.VirtualMode-Asynchronous", Old: "VM_ASYNC"},
+		{Category: CategoryPins, Kind: ChangeModified, Subject: "PC6",
+			Key: "Signal", Old: "GPIO_Output", New: "GPIO_Input"},
+		{Category: CategoryClocks, Kind: ChangeModified, Subject: "RCC",
+			Key: "RCC.SYSCLKFreq_VALUE", Old: "8000000", New: "170000000"},
+		{Category: CategoryMiddleware, Kind: ChangeAdded, Subject: "FREERTOS"},
+	}, changes)
+}
+
+func TestClocks(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+	c, err := m.Clocks()
+	require.Nil(t, err)
+	require.Equal(t, "RCC_SYSCLKSOURCE_HSE", c.SysclkSource)
+	require.Equal(t, "RCC_PLLSOURCE_HSE", c.PllSource)
+	require.Equal(t, Clock{Name: "SYSCLK", Frequency: 8000000}, c.Buses[0])
+	require.Equal(t, Clock{Name: "HCLK", Frequency: 8000000}, c.Buses[1])
+
+	f, ok := c.Frequency("HSE")
+	require.True(t, ok)
+	require.Equal(t, uint64(8000000), f)
+
+	f, ok = c.Frequency("usart1")
+	require.True(t, ok)
+	require.Equal(t, uint64(8000000), f)
+
+	f, ok = c.Frequency("VCOOutput")
+	require.True(t, ok)
+	require.Equal(t, uint64(64000000), f)
+
+	_, ok = c.Frequency("APB3")
+	require.False(t, ok)
+
+	m.Set("RCC.PLLN", "85")
+	m.Set("RCC.APB1Freq_Value", "1.7E8")
+	c, err = m.Clocks()
+	require.Nil(t, err)
+	require.Contains(t, c.Settings, ClockSetting{Name: "PLLN", Value: "85"})
+	f, _ = c.Frequency("APB1")
+	require.Equal(t, uint64(170000000), f)
+
+	m.Set("RCC.APB1Freq_Value", "fast")
+	_, err = m.Clocks()
+	require.NotNil(t, err)
+}
+
+func TestLint(t *testing.T) {
+	sample1Path := "./test_data/sample1.ioc"
+	m, _ := FromFile(sample1Path)
+	opts := LintOptions{
+		UAScriptBeforePath: "ergomcutool/scripts/cubemx-before-generate.sh",
+		UAScriptAfterPath:  "ergomcutool/scripts/cubemx-after-generate.sh",
+	}
+	issues, err := m.Lint(DefaultLintRules(opts))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(issues))
+	require.Equal(t, "ua-scripts", issues[0].Rule)
+	require.Equal(t, SeverityError, issues[0].Severity)
+	require.Equal(t, "ProjectManager.UAScriptBeforePath", issues[0].Key)
+	require.Equal(t, "ProjectManager.UAScriptAfterPath", issues[1].Key)
+
+	m.Set("ProjectManager.UAScriptBeforePath", opts.UAScriptBeforePath)
+	m.Set("ProjectManager.UAScriptAfterPath", "./"+opts.UAScriptAfterPath)
+	m.Set("ProjectManager.TargetToolchain", "CMake")
+	m.Set("ProjectManager.KeepUserCode", "false")
+	m.Set("PC13.GPIO_Label", "LedUser1")
+	m.Set("Mcu.IP8", "CRC")
+	m.Set("Mcu.IPNb", "9")
+	_ = m.Delete("RCC.HSE_VALUE")
+	issues, err = m.Lint(DefaultLintRules(opts))
+	require.Nil(t, err)
+	rules := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		rules = append(rules, issue.Rule)
+	}
+	require.Equal(t, []string{"toolchain", "keep-user-code", "duplicate-pin-labels",
+		"unused-peripherals", "missing-hse-value"}, rules)
+	require.Equal(t, SeverityWarning, issues[3].Severity)
+}
@@ -0,0 +1,242 @@
+package iocfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Severity of a lint issue.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// LintIssue is a problem found by a lint rule.
+type LintIssue struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Key is the .ioc entry the issue relates to, if any.
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintRule is a single check of the .ioc file.
+// Custom rules can be added to the list returned by DefaultLintRules.
+type LintRule struct {
+	Name        string
+	Description string
+	Severity    Severity
+	// Check returns the found issues.
+	// 'Rule' and 'Severity' fields of the issues are filled by Lint.
+	Check func(ioc *Ioc) ([]LintIssue, error)
+}
+
+// LintOptions configure the default lint rules.
+type LintOptions struct {
+	// UAScriptBeforePath and UAScriptAfterPath are the expected
+	// CubeMX user action scripts. The check is skipped if empty.
+	UAScriptBeforePath string
+	UAScriptAfterPath  string
+}
+
+// pinlessPeripherals are peripherals that normally don't use any pins.
+var pinlessPeripherals = map[string]bool{
+	"DMA":  true,
+	"NVIC": true,
+	"RCC":  true,
+	"SYS":  true,
+	"GPIO": true,
+}
+
+// DefaultLintRules returns the built-in lint rules.
+func DefaultLintRules(opts LintOptions) []LintRule {
+	return []LintRule{
+		{
+			Name:        "toolchain",
+			Description: "ProjectManager.TargetToolchain must be 'Makefile'",
+			Severity:    SeverityError,
+			Check:       checkToolchain,
+		},
+		{
+			Name:        "keep-user-code",
+			Description: "ProjectManager.KeepUserCode must be true",
+			Severity:    SeverityError,
+			Check:       checkKeepUserCode,
+		},
+		{
+			Name:        "ua-scripts",
+			Description: "CubeMX user action scripts must point to the ergomcutool scripts",
+			Severity:    SeverityError,
+			Check: func(ioc *Ioc) ([]LintIssue, error) {
+				return checkUAScripts(ioc, opts)
+			},
+		},
+		{
+			Name:        "duplicate-pin-labels",
+			Description: "GPIO labels must be unique",
+			Severity:    SeverityError,
+			Check:       checkDuplicatePinLabels,
+		},
+		{
+			Name:        "unused-peripherals",
+			Description: "enabled peripherals should have pins or parameters configured",
+			Severity:    SeverityWarning,
+			Check:       checkUnusedPeripherals,
+		},
+		{
+			Name:        "missing-hse-value",
+			Description: "RCC.HSE_VALUE must be defined if HSE is used",
+			Severity:    SeverityError,
+			Check:       checkHseValue,
+		},
+	}
+}
+
+// Lint runs the specified rules and returns the found issues
+// in the order of the rules. Rule severity is applied to the issues.
+func (ioc *Ioc) Lint(rules []LintRule) ([]LintIssue, error) {
+	r := make([]LintIssue, 0, 10)
+	for _, rule := range rules {
+		issues, err := rule.Check(ioc)
+		if err != nil {
+			return r, fmt.Errorf("lint rule %q failed: %w", rule.Name, err)
+		}
+		for _, issue := range issues {
+			issue.Rule = rule.Name
+			issue.Severity = rule.Severity
+			r = append(r, issue)
+		}
+	}
+	return r, nil
+}
+
+func checkToolchain(ioc *Ioc) ([]LintIssue, error) {
+	key := "ProjectManager.TargetToolchain"
+	v, ok := ioc.Get(key)
+	if !ok {
+		return []LintIssue{{Key: key, Message: "target toolchain is not defined"}}, nil
+	}
+	if v != "Makefile" {
+		return []LintIssue{{Key: key,
+			Message: fmt.Sprintf("target toolchain is %q, must be \"Makefile\"", v)}}, nil
+	}
+	return nil, nil
+}
+
+func checkKeepUserCode(ioc *Ioc) ([]LintIssue, error) {
+	key := "ProjectManager.KeepUserCode"
+	v, ok := ioc.Get(key)
+	// CubeMX keeps the user code by default
+	if !ok || v == "true" {
+		return nil, nil
+	}
+	return []LintIssue{{Key: key,
+		Message: "user code will be deleted on code generation"}}, nil
+}
+
+func checkUAScripts(ioc *Ioc, opts LintOptions) ([]LintIssue, error) {
+	r := make([]LintIssue, 0, 2)
+	scripts := []struct {
+		key      string
+		expected string
+	}{
+		{"ProjectManager.UAScriptBeforePath", opts.UAScriptBeforePath},
+		{"ProjectManager.UAScriptAfterPath", opts.UAScriptAfterPath},
+	}
+	for _, s := range scripts {
+		if s.expected == "" {
+			continue
+		}
+		v, _ := ioc.Get(s.key)
+		if v == "" {
+			r = append(r, LintIssue{Key: s.key,
+				Message: fmt.Sprintf("user action script is not set, must be %q", s.expected)})
+			continue
+		}
+		if filepath.Clean(v) != filepath.Clean(s.expected) {
+			r = append(r, LintIssue{Key: s.key,
+				Message: fmt.Sprintf("user action script is %q, must be %q", v, s.expected)})
+		}
+	}
+	return r, nil
+}
+
+func checkDuplicatePinLabels(ioc *Ioc) ([]LintIssue, error) {
+	pins, err := ioc.Pins()
+	if err != nil {
+		return nil, err
+	}
+	r := make([]LintIssue, 0, 2)
+	labels := make(map[string]string, len(pins))
+	for _, p := range pins {
+		if p.Label == "" {
+			continue
+		}
+		if other, ok := labels[p.Label]; ok {
+			r = append(r, LintIssue{Key: p.Name + ".GPIO_Label",
+				Message: fmt.Sprintf("label %q is already used by pin %s", p.Label, other)})
+			continue
+		}
+		labels[p.Label] = p.Name
+	}
+	return r, nil
+}
+
+func checkUnusedPeripherals(ioc *Ioc) ([]LintIssue, error) {
+	pins, err := ioc.Pins()
+	if err != nil {
+		return nil, err
+	}
+	peripherals, err := ioc.Peripherals(pins)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]LintIssue, 0, 2)
+	for _, p := range peripherals {
+		if len(p.Pins) > 0 || pinlessPeripherals[p.Name] || MiddlewareNames[p.Name] {
+			continue
+		}
+		configured := false
+		for _, key := range ioc.KeysWithPrefix(p.Name + ".") {
+			if !strings.HasSuffix(key, ".IPParameters") {
+				configured = true
+				break
+			}
+		}
+		if !configured {
+			r = append(r, LintIssue{Key: p.Name,
+				Message: fmt.Sprintf("peripheral %s is enabled but has neither pins nor parameters configured", p.Name)})
+		}
+	}
+	return r, nil
+}
+
+func checkHseValue(ioc *Ioc) ([]LintIssue, error) {
+	hseUsed := false
+	for _, key := range []string{"RCC.SYSCLKSource", "RCC.PLLSourceVirtual", "RCC.PLLSource"} {
+		if v, _ := ioc.Get(key); strings.Contains(v, "HSE") {
+			hseUsed = true
+		}
+	}
+	pins, err := ioc.Pins()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pins {
+		if strings.HasPrefix(p.Mode, "HSE") {
+			hseUsed = true
+		}
+	}
+	if !hseUsed {
+		return nil, nil
+	}
+	v, ok := ioc.Get("RCC.HSE_VALUE")
+	if !ok || strings.TrimSpace(v) == "" {
+		return []LintIssue{{Key: "RCC.HSE_VALUE",
+			Message: "HSE is used but its frequency is not defined"}}, nil
+	}
+	return nil, nil
+}
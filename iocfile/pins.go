@@ -0,0 +1,168 @@
+package iocfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Pin describes a single MCU pin configured in the .ioc file.
+type Pin struct {
+	// Name is the CubeMX pin name, e.g. 'PA5' or 'PC14-OSC32_IN'.
+	Name string `json:"name"`
+	// Signal is the function assigned to the pin, e.g. 'SPI1_SCK' or 'GPIO_Output'.
+	Signal string `json:"signal"`
+	// Label is the user label (GPIO_Label), e.g. 'LED'.
+	Label string `json:"label"`
+	// Mode is the pin mode, e.g. 'Asynchronous' or 'GPIO_MODE_OUTPUT_PP'.
+	Mode string `json:"mode"`
+	// Pull is the pull-up/pull-down setting, e.g. 'GPIO_PULLDOWN'.
+	Pull string `json:"pull"`
+	// Speed is the output speed setting, e.g. 'GPIO_SPEED_FREQ_LOW'.
+	Speed string `json:"speed"`
+	// AlternateFunction is the alternate function (GPIO_AF), e.g. 'GPIO_AF5_SPI1'.
+	// CubeMX only stores it for the pins that have it set explicitly.
+	AlternateFunction string `json:"alternate_function"`
+	// Virtual is true for CubeMX virtual pins (VP_*) that don't exist physically.
+	Virtual bool `json:"virtual"`
+}
+
+// Peripheral describes an MCU peripheral (IP) enabled in the .ioc file.
+type Peripheral struct {
+	// Name is the peripheral instance name, e.g. 'USART1'.
+	Name string `json:"name"`
+	// Pins is a list of pin names whose signal belongs to the peripheral.
+	Pins []string `json:"pins"`
+}
+
+// maxIndexedValues is the limit of the number of the indexed values,
+// the largest packages have a few hundred pins.
+const maxIndexedValues = 4096
+
+// indexedValues reads a list of values defined as 'prefix0', 'prefix1', ...
+// The number of values is taken from the 'countKey' entry.
+// If 'countKey' doesn't exist, an empty list is returned.
+func (ioc *Ioc) indexedValues(prefix, countKey string) ([]string, error) {
+	n, err := ioc.GetInt(countKey)
+	if err != nil {
+		if errors.Is(err, ErrEntryNotFound) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	if n < 0 || n > maxIndexedValues {
+		return nil, fmt.Errorf("%q must be between 0 and %d, got %d", countKey, maxIndexedValues, n)
+	}
+	r := make([]string, 0, n)
+	for i := int64(0); i < n; i++ {
+		v, ok := ioc.Get(fmt.Sprintf("%s%d", prefix, i))
+		if !ok || v == "" {
+			continue
+		}
+		r = append(r, v)
+	}
+	return r, nil
+}
+
+// Pins returns the pins listed in 'Mcu.PinN' entries
+// in the order they are defined.
+func (ioc *Ioc) Pins() ([]Pin, error) {
+	names, err := ioc.indexedValues("Mcu.Pin", "Mcu.PinsNb")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pin list: %w", err)
+	}
+	r := make([]Pin, 0, len(names))
+	for _, name := range names {
+		p := Pin{
+			Name:    name,
+			Virtual: strings.HasPrefix(name, "VP_"),
+		}
+		p.Signal, _ = ioc.Get(name + ".Signal")
+		p.Label, _ = ioc.Get(name + ".GPIO_Label")
+		p.Pull, _ = ioc.Get(name + ".GPIO_PuPd")
+		p.Speed, _ = ioc.Get(name + ".GPIO_Speed")
+		p.AlternateFunction, _ = ioc.Get(name + ".GPIO_AF")
+		p.Mode = ioc.pinMode(name)
+		r = append(r, p)
+	}
+	return r, nil
+}
+
+// pinMode returns the pin mode. Peripheral pins have the 'Mode' entry,
+// GPIO pins store it in one of the 'GPIO_Mode*' entries.
+func (ioc *Ioc) pinMode(name string) string {
+	if v, ok := ioc.Get(name + ".Mode"); ok {
+		return v
+	}
+	if v, ok := ioc.Get(name + ".GPIO_Mode"); ok {
+		return v
+	}
+	for _, key := range ioc.KeysWithPrefix(name + ".GPIO_ModeDefault") {
+		v, _ := ioc.Get(key)
+		return v
+	}
+	return ""
+}
+
+// Peripherals returns the peripherals listed in 'Mcu.IPn' entries
+// in the order they are defined, with the pins among 'pins'
+// (as returned by Pins) whose signal belongs to the peripheral.
+func (ioc *Ioc) Peripherals(pins []Pin) ([]Peripheral, error) {
+	names, err := ioc.indexedValues("Mcu.IP", "Mcu.IPNb")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the peripheral list: %w", err)
+	}
+	r := make([]Peripheral, 0, len(names))
+	for _, name := range names {
+		p := Peripheral{Name: name, Pins: []string{}}
+		for _, pin := range pins {
+			if strings.HasPrefix(pin.SignalName(), name+"_") {
+				p.Pins = append(p.Pins, pin.Name)
+			}
+		}
+		r = append(r, p)
+	}
+	return r, nil
+}
+
+// FindIocFiles returns a sorted list of .ioc files in the specified directory.
+func FindIocFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]string, 0, 10)
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".ioc") {
+			r = append(r, e.Name())
+		}
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+// SignalName returns the signal without the 'S_' prefix that CubeMX
+// adds to some signals, e.g. 'TIM2_CH1' for 'S_TIM2_CH1'.
+func (p *Pin) SignalName() string {
+	return strings.TrimPrefix(p.Signal, "S_")
+}
+
+// GpioPort returns the GPIO port letter of the pin, e.g. "C" for 'PC14-OSC32_IN',
+// and the pin number, e.g. 14. 'ok' is false if the pin is not a GPIO pin.
+func (p *Pin) GpioPort() (port string, number int, ok bool) {
+	name := p.Name
+	if i := strings.IndexAny(name, "-_ "); i != -1 {
+		name = name[:i]
+	}
+	if len(name) < 3 || name[0] != 'P' || name[1] < 'A' || name[1] > 'Z' {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(name[2:])
+	if err != nil || n < 0 || n > 15 {
+		return "", 0, false
+	}
+	return name[1:2], n, true
+}
@@ -0,0 +1,478 @@
+package mkf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeKind specifies the kind of a Makefile node.
+type NodeKind uint32
+
+const (
+	// NodeBlank is an empty line.
+	NodeBlank NodeKind = iota
+	// NodeComment is a comment line, e.g. '# C sources'.
+	NodeComment
+	// NodeAssignment is a variable assignment, e.g. 'C_DEFS = -DUSE_HAL_DRIVER'.
+	NodeAssignment
+	// NodeRule is a rule with its prerequisites and recipe.
+	NodeRule
+	// NodeConditional is a conditional directive:
+	// 'ifeq', 'ifneq', 'ifdef', 'ifndef', 'else' or 'endif'.
+	NodeConditional
+	// NodeInclude is an 'include', '-include' or 'sinclude' directive.
+	NodeInclude
+	// NodeDefine is a multi-line variable definition ('define' ... 'endef').
+	NodeDefine
+	// NodeOther is any other line, e.g. a 'vpath' directive.
+	NodeOther
+)
+
+// Node is a single element of the Makefile.
+// Each node keeps the original physical lines it was parsed from,
+// so that the Makefile can be written back without changes.
+type Node struct {
+	Kind NodeKind
+	// Lines are the physical lines of the node.
+	Lines []string
+	// Depth is the conditional nesting level of the node.
+	Depth int
+
+	// Name is the variable name of assignments and defines.
+	Name string
+	// Op is the assignment operator: '=', ':=', '::=', '?=', '+=' or '!='.
+	Op string
+	// Modifiers are 'export' and 'override' keywords preceding the assignment.
+	Modifiers []string
+	// Values are the items of the assigned value,
+	// one item per physical line, comments excluded.
+	Values []string
+
+	// Targets, Prerequisites and OrderOnly are the rule targets and
+	// its normal and order-only ('|') prerequisites.
+	Targets       []string
+	Prerequisites []string
+	OrderOnly     []string
+	// DoubleColon is true for '::' rules.
+	DoubleColon bool
+	// Recipe are the recipe lines of the rule without the leading tab.
+	Recipe []string
+	// headerLines is the number of physical lines before the recipe.
+	headerLines int
+
+	// Directive is the conditional or include directive, e.g. 'ifeq' or '-include'.
+	Directive string
+	// Arguments are the directive arguments, e.g. '($(DEBUG), 1)'
+	// or the list of included files.
+	Arguments string
+}
+
+// Value returns the assigned value items joined with spaces.
+func (n *Node) Value() string {
+	return strings.Join(n.Values, " ")
+}
+
+// SetValues replaces the assigned value and regenerates the node lines.
+// Multiple values are written one per line using line continuations,
+// the same way STM32CubeMX does.
+func (n *Node) SetValues(values []string) {
+	prefix := ""
+	if len(n.Modifiers) > 0 {
+		prefix = strings.Join(n.Modifiers, " ") + " "
+	}
+	head := fmt.Sprintf("%s%s %s", prefix, n.Name, n.Op)
+	n.Values = append([]string{}, values...)
+	switch len(values) {
+	case 0:
+		n.Lines = []string{head}
+	case 1:
+		n.Lines = []string{head + " " + values[0]}
+	default:
+		n.Lines = make([]string, 0, len(values)+1)
+		n.Lines = append(n.Lines, head+"  \\")
+		for _, v := range values[:len(values)-1] {
+			n.Lines = append(n.Lines, v+" \\")
+		}
+		// last value must not contain trailing backslash
+		n.Lines = append(n.Lines, values[len(values)-1])
+	}
+}
+
+// SetRecipe replaces the recipe of the rule and regenerates the node lines.
+// Inline recipes (after ';') are not supported and are kept as is.
+func (n *Node) SetRecipe(recipe []string) {
+	n.Lines = append([]string{}, n.Lines[:n.headerLines]...)
+	n.Recipe = append([]string{}, recipe...)
+	for _, r := range recipe {
+		n.Lines = append(n.Lines, "\t"+r)
+	}
+}
+
+// File is a parsed Makefile.
+type File struct {
+	Nodes []*Node
+}
+
+// Lines returns the physical lines of all nodes.
+func (f *File) Lines() []string {
+	r := make([]string, 0, len(f.Nodes)*2)
+	for _, n := range f.Nodes {
+		r = append(r, n.Lines...)
+	}
+	return r
+}
+
+// Assignments returns all assignments of the specified variable.
+// The name must match exactly.
+func (f *File) Assignments(name string) []*Node {
+	r := make([]*Node, 0, 2)
+	for _, n := range f.Nodes {
+		if n.Kind == NodeAssignment && n.Name == name {
+			r = append(r, n)
+		}
+	}
+	return r
+}
+
+// Variable returns the first assignment of the specified variable,
+// or nil if the variable is not assigned.
+func (f *File) Variable(name string) *Node {
+	for _, n := range f.Nodes {
+		if n.Kind == NodeAssignment && n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// InsertAfter inserts the nodes after the reference node.
+// The nodes are appended at the end if ref is nil or not found.
+func (f *File) InsertAfter(ref *Node, nodes ...*Node) {
+	index := len(f.Nodes)
+	for i, n := range f.Nodes {
+		if n == ref {
+			index = i + 1
+			break
+		}
+	}
+	tmp := make([]*Node, 0, len(f.Nodes)+len(nodes))
+	tmp = append(tmp, f.Nodes[:index]...)
+	tmp = append(tmp, nodes...)
+	tmp = append(tmp, f.Nodes[index:]...)
+	f.Nodes = tmp
+}
+
+// Rules returns all rules in the order they are defined.
+func (f *File) Rules() []*Node {
+	r := make([]*Node, 0, 20)
+	for _, n := range f.Nodes {
+		if n.Kind == NodeRule {
+			r = append(r, n)
+		}
+	}
+	return r
+}
+
+// Rule returns the first rule that has the specified target, or nil.
+func (f *File) Rule(target string) *Node {
+	for _, n := range f.Nodes {
+		if n.Kind != NodeRule {
+			continue
+		}
+		for _, t := range n.Targets {
+			if t == target {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// endsWithContinuation returns true if the line ends
+// with an odd number of backslashes.
+func endsWithContinuation(s string) bool {
+	n := 0
+	for i := len(s) - 1; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// stripComment removes the comment part of the line, if any.
+// Escaped '\#' is not a comment.
+func stripComment(s string) (string, bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == '#' {
+			return s[:i], true
+		}
+	}
+	return s, false
+}
+
+// splitFields splits the string by whitespace
+// ignoring whitespace inside '$(...)' and '${...}'.
+func splitFields(s string) []string {
+	r := make([]string, 0, 4)
+	depth := 0
+	start := -1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '(' || c == '{':
+			depth++
+		case (c == ')' || c == '}') && depth > 0:
+			depth--
+		}
+		isSpace := (c == ' ' || c == '\t') && depth == 0
+		if isSpace {
+			if start != -1 {
+				r = append(r, s[start:i])
+				start = -1
+			}
+		} else if start == -1 {
+			start = i
+		}
+	}
+	if start != -1 {
+		r = append(r, s[start:])
+	}
+	return r
+}
+
+// findSeparator finds the first assignment operator or rule colon
+// outside of variable references. Returns the position,
+// the operator ('=', ':=', '::=', '?=', '+=', '!=', ':' or '::') or "".
+func findSeparator(s string) (int, string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$' && i+1 < len(s) && (s[i+1] == '(' || s[i+1] == '{'):
+			depth++
+			i++
+			continue
+		case (c == '(' || c == '{') && depth > 0:
+			depth++
+		case (c == ')' || c == '}') && depth > 0:
+			depth--
+		}
+		if depth > 0 {
+			continue
+		}
+		switch c {
+		case '=':
+			if i > 0 {
+				switch s[i-1] {
+				case '+', '?', '!':
+					return i - 1, s[i-1 : i+1]
+				}
+			}
+			return i, "="
+		case ':':
+			switch {
+			case strings.HasPrefix(s[i:], "::="):
+				return i, "::="
+			case strings.HasPrefix(s[i:], ":="):
+				return i, ":="
+			case strings.HasPrefix(s[i:], "::"):
+				return i, "::"
+			}
+			return i, ":"
+		}
+	}
+	return -1, ""
+}
+
+var conditionalDirectives = map[string]bool{
+	"ifeq": true, "ifneq": true, "ifdef": true, "ifndef": true, "else": true, "endif": true,
+}
+
+var includeDirectives = map[string]bool{
+	"include": true, "-include": true, "sinclude": true,
+}
+
+var assignmentModifiers = map[string]bool{
+	"export": true, "override": true, "private": true,
+}
+
+// valueItems splits the physical lines of an assignment value into items:
+// one item per physical line, trailing backslashes and comments removed.
+// A comment continues to the end of the logical line, as in GNU make.
+func valueItems(first string, rest []string) []string {
+	r := make([]string, 0, len(rest)+1)
+	lines := append([]string{first}, rest...)
+	for _, line := range lines {
+		if endsWithContinuation(line) {
+			line = line[:len(line)-1]
+		}
+		line, isComment := stripComment(line)
+		line = strings.TrimSpace(line)
+		if line != "" {
+			r = append(r, line)
+		}
+		if isComment {
+			break
+		}
+	}
+	return r
+}
+
+// Parse parses the Makefile lines into nodes.
+func Parse(lines []string) (*File, error) {
+	f := &File{Nodes: make([]*Node, 0, len(lines))}
+	depth := 0
+	var lastRule *Node
+
+	for i := 0; i < len(lines); i++ {
+		start := i
+		// Collect the logical line
+		for endsWithContinuation(lines[i]) && i+1 < len(lines) {
+			i++
+		}
+		physical := lines[start : i+1]
+		first := physical[0]
+		n := &Node{Lines: append([]string{}, physical...), Depth: depth}
+
+		// Recipe lines belong to the last rule
+		if strings.HasPrefix(first, "\t") && lastRule != nil {
+			lastRule.Lines = append(lastRule.Lines, physical...)
+			lastRule.Recipe = append(lastRule.Recipe, strings.TrimPrefix(strings.Join(physical, "\n"), "\t"))
+			continue
+		}
+
+		trimmed := strings.TrimSpace(first)
+		words := splitFields(trimmed)
+		keyword := ""
+		if len(words) > 0 {
+			keyword = words[0]
+		}
+		switch {
+		case trimmed == "" && len(physical) == 1:
+			n.Kind = NodeBlank
+		case strings.HasPrefix(trimmed, "#"):
+			n.Kind = NodeComment
+		case conditionalDirectives[keyword]:
+			n.Kind = NodeConditional
+			n.Directive = keyword
+			n.Arguments = strings.TrimSpace(strings.TrimPrefix(trimmed, keyword))
+			switch keyword {
+			case "endif":
+				depth--
+				if depth < 0 {
+					return f, fmt.Errorf("line %d: 'endif' without matching 'if'", start+1)
+				}
+				n.Depth = depth
+			case "else":
+				n.Depth = depth - 1
+			default:
+				depth++
+			}
+		case includeDirectives[keyword]:
+			n.Kind = NodeInclude
+			n.Directive = keyword
+			n.Arguments = strings.TrimSpace(strings.TrimPrefix(trimmed, keyword))
+		case keyword == "define" || (assignmentModifiers[keyword] && len(words) > 1 && words[1] == "define"):
+			n.Kind = NodeDefine
+			defineWords := words[1:]
+			if keyword != "define" {
+				n.Modifiers = []string{keyword}
+				defineWords = words[2:]
+			}
+			if len(defineWords) > 0 {
+				n.Name = defineWords[0]
+			}
+			if len(defineWords) > 1 {
+				n.Op = defineWords[1]
+			}
+			// Collect the lines up to the matching 'endef'
+			nested := 1
+			for nested > 0 {
+				i++
+				if i >= len(lines) {
+					return f, fmt.Errorf("line %d: 'define' without matching 'endef'", start+1)
+				}
+				w := splitFields(strings.TrimSpace(lines[i]))
+				if len(w) > 0 && w[0] == "endef" {
+					nested--
+				} else if len(w) > 0 && w[0] == "define" {
+					nested++
+				}
+				n.Lines = append(n.Lines, lines[i])
+			}
+			if len(n.Lines) > 2 {
+				n.Values = append([]string{}, n.Lines[1:len(n.Lines)-1]...)
+			}
+		default:
+			parseStatement(n, first, physical[1:])
+		}
+
+		if n.Kind == NodeRule {
+			lastRule = n
+		} else {
+			lastRule = nil
+		}
+		f.Nodes = append(f.Nodes, n)
+	}
+	if depth != 0 {
+		return f, fmt.Errorf("unterminated conditional directive")
+	}
+	return f, nil
+}
+
+// parseStatement parses an assignment or a rule.
+// Lines that are neither become NodeOther.
+func parseStatement(n *Node, first string, rest []string) {
+	logicalFirst := first
+	if endsWithContinuation(logicalFirst) {
+		logicalFirst = logicalFirst[:len(logicalFirst)-1]
+	}
+	code, _ := stripComment(logicalFirst)
+	pos, op := findSeparator(code)
+	if pos == -1 {
+		n.Kind = NodeOther
+		return
+	}
+	if op == ":" || op == "::" {
+		n.Kind = NodeRule
+		n.headerLines = len(rest) + 1
+		n.DoubleColon = op == "::"
+		n.Targets = splitFields(strings.TrimSpace(code[:pos]))
+		// Prerequisites may span several physical lines
+		prereqs := strings.Join(valueItems(first[pos+len(op):], rest), " ")
+		inlineRecipe := ""
+		if p := strings.Index(prereqs, ";"); p != -1 {
+			inlineRecipe = strings.TrimSpace(prereqs[p+1:])
+			prereqs = prereqs[:p]
+		}
+		normal, orderOnly, _ := strings.Cut(prereqs, "|")
+		n.Prerequisites = splitFields(normal)
+		n.OrderOnly = splitFields(orderOnly)
+		if inlineRecipe != "" {
+			n.Recipe = append(n.Recipe, inlineRecipe)
+		}
+		return
+	}
+
+	words := splitFields(strings.TrimSpace(code[:pos]))
+	if len(words) == 0 {
+		n.Kind = NodeOther
+		return
+	}
+	for len(words) > 1 && assignmentModifiers[words[0]] {
+		n.Modifiers = append(n.Modifiers, words[0])
+		words = words[1:]
+	}
+	if len(words) != 1 {
+		n.Kind = NodeOther
+		n.Modifiers = nil
+		return
+	}
+	n.Kind = NodeAssignment
+	n.Name = words[0]
+	n.Op = op
+	n.Values = valueItems(first[pos+len(op):], rest)
+}
@@ -0,0 +1,212 @@
+package mkf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestASTRoundTrip(t *testing.T) {
+	m, err := FromFile("./test_data/sample1.txt")
+	require.Nil(t, err)
+	f, err := m.AST()
+	require.Nil(t, err)
+	require.Equal(t, m.Lines, f.Lines())
+
+	n := f.Variable("C_SOURCES")
+	require.NotNil(t, n)
+	require.Equal(t, NodeAssignment, n.Kind)
+	require.Equal(t, "=", n.Op)
+	require.Equal(t, 28, len(n.Lines))
+
+	cc := f.Assignments("CC")
+	require.Equal(t, 2, len(cc))
+	require.Equal(t, 1, cc[0].Depth)
+
+	r := f.Rule("$(BUILD_DIR)/%.o")
+	require.NotNil(t, r)
+	require.Equal(t, []string{"%.c", "Makefile"}, r.Prerequisites)
+	require.Equal(t, []string{"$(BUILD_DIR)"}, r.OrderOnly)
+	require.Equal(t, 1, len(r.Recipe))
+	require.True(t, strings.HasPrefix(r.Recipe[0], "$(CC) -c $(CFLAGS)"))
+
+	var include *Node
+	for _, n := range f.Nodes {
+		if n.Kind == NodeInclude {
+			include = n
+		}
+	}
+	require.NotNil(t, include)
+	require.Equal(t, "-include", include.Directive)
+	require.Equal(t, "$(wildcard $(BUILD_DIR)/*.d)", include.Arguments)
+}
+
+func TestASTExactNames(t *testing.T) {
+	lines := []string{
+		"C_DEFS_EXTRA = -DEXTRA",
+		"C_DEFS = \\",
+		"-DUSE_HAL_DRIVER \\",
+		"-DSTM32G431xx",
+	}
+	m := &Mkf{Lines: lines, LineEnding: "\n"}
+	vals, err := m.ReadValue("C_DEFS")
+	require.Nil(t, err)
+	require.Equal(t, []string{"-DUSE_HAL_DRIVER", "-DSTM32G431xx"}, vals)
+
+	require.Nil(t, m.ReplaceValue("C_DEFS", []string{"-DA"}))
+	require.Equal(t, []string{"C_DEFS_EXTRA = -DEXTRA", "C_DEFS = -DA"}, m.Lines)
+
+	_, err = m.ReadValue("C_DEF")
+	require.ErrorIs(t, err, ErrEntryNotFound)
+}
+
+func TestASTOperators(t *testing.T) {
+	lines := []string{
+		"A = 1",
+		"B ?= 2",
+		"C += 3",
+		"D := 4",
+		"E ::= 5",
+		"F != echo 6",
+		"export G = 7",
+		"override H := $(A) $(B)",
+	}
+	f, err := Parse(lines)
+	require.Nil(t, err)
+	expected := []struct{ name, op, value string }{
+		{"A", "=", "1"}, {"B", "?=", "2"}, {"C", "+=", "3"}, {"D", ":=", "4"},
+		{"E", "::=", "5"}, {"F", "!=", "echo 6"}, {"G", "=", "7"}, {"H", ":=", "$(A) $(B)"},
+	}
+	require.Equal(t, len(expected), len(f.Nodes))
+	for i, e := range expected {
+		n := f.Nodes[i]
+		require.Equal(t, NodeAssignment, n.Kind, lines[i])
+		require.Equal(t, e.name, n.Name)
+		require.Equal(t, e.op, n.Op)
+		require.Equal(t, e.value, n.Value())
+	}
+	require.Equal(t, []string{"override"}, f.Nodes[7].Modifiers)
+
+	f.Nodes[2].SetValues([]string{"x", "y"})
+	f.Nodes[7].SetValues([]string{"z"})
+	require.Equal(t, []string{"C +=  \\", "x \\", "y"}, f.Nodes[2].Lines)
+	require.Equal(t, []string{"override H := z"}, f.Nodes[7].Lines)
+}
+
+func TestASTComments(t *testing.T) {
+	lines := []string{
+		"C_SOURCES = \\",
+		"a.c \\",
+		"b.c # the comment \\",
+		"continues.c",
+		"# c.c",
+		"OPT = -Og # optimization",
+		"ESCAPED = a\\#b",
+	}
+	m := &Mkf{Lines: lines, LineEnding: "\n"}
+	vals, err := m.ReadValue("C_SOURCES")
+	require.Nil(t, err)
+	require.Equal(t, []string{"a.c", "b.c"}, vals)
+
+	vals, err = m.ReadValue("OPT")
+	require.Nil(t, err)
+	require.Equal(t, []string{"-Og"}, vals)
+
+	vals, err = m.ReadValue("ESCAPED")
+	require.Nil(t, err)
+	require.Equal(t, []string{"a\\#b"}, vals)
+
+	f, err := m.AST()
+	require.Nil(t, err)
+	require.Equal(t, NodeComment, f.Nodes[1].Kind)
+	require.Equal(t, lines, f.Lines())
+}
+
+func TestASTConditionalsAndDefines(t *testing.T) {
+	lines := []string{
+		"ifeq ($(DEBUG), 1)",
+		"CFLAGS += -g",
+		"else ifdef RELEASE",
+		"CFLAGS += -O2",
+		"endif",
+		"define RECIPE",
+		"echo $(1)",
+		"endef",
+		"all: build/app.elf ; @echo done",
+		"",
+		"\t@echo orphan",
+	}
+	f, err := Parse(lines)
+	require.Nil(t, err)
+	require.Equal(t, lines, f.Lines())
+
+	kinds := make([]NodeKind, 0, len(f.Nodes))
+	for _, n := range f.Nodes {
+		kinds = append(kinds, n.Kind)
+	}
+	require.Equal(t, []NodeKind{NodeConditional, NodeAssignment, NodeConditional,
+		NodeAssignment, NodeConditional, NodeDefine, NodeRule, NodeBlank, NodeOther}, kinds)
+	require.Equal(t, "($(DEBUG), 1)", f.Nodes[0].Arguments)
+	require.Equal(t, 1, f.Nodes[1].Depth)
+	require.Equal(t, 0, f.Nodes[4].Depth)
+	require.Equal(t, "RECIPE", f.Nodes[5].Name)
+	require.Equal(t, []string{"echo $(1)"}, f.Nodes[5].Values)
+	require.Equal(t, []string{"@echo done"}, f.Nodes[6].Recipe)
+
+	_, err = Parse([]string{"ifdef X"})
+	require.NotNil(t, err)
+	_, err = Parse([]string{"define X", "a"})
+	require.NotNil(t, err)
+}
+
+func TestVariables(t *testing.T) {
+	m, err := FromFile("./test_data/sample1.txt")
+	require.Nil(t, err)
+	f, err := m.AST()
+	require.Nil(t, err)
+
+	vars := f.Variables(map[string]string{"C_INCLUDES": ""})
+	require.Equal(t, "-mcpu=cortex-m4 -mthumb -mfpu=fpv4-sp-d16 -mfloat-abi=hard", vars.Value("MCU"))
+	require.Equal(t, "arm-none-eabi-gcc", vars.Value("CC"))
+	cflags := vars.Value("CFLAGS")
+	require.True(t, strings.HasPrefix(cflags,
+		"-mcpu=cortex-m4 -mthumb -mfpu=fpv4-sp-d16 -mfloat-abi=hard -DUSE_HAL_DRIVER -DSTM32G431xx  -Og -Wall"))
+	require.Contains(t, cflags, "-g -gdwarf-2")
+	require.Contains(t, cflags, `-MF"$(@:%.o=%.d)"`)
+
+	vars = f.Variables(map[string]string{"DEBUG": "0", "GCC_PATH": "/opt/gcc/bin"})
+	require.NotContains(t, vars.Value("CFLAGS"), "-gdwarf-2")
+	require.Equal(t, "/opt/gcc/bin/arm-none-eabi-gcc", vars.Value("CC"))
+
+	lines := []string{
+		"A = a",
+		"B := $(A)",
+		"C = $(A)",
+		"A = x",
+		"D ?= d",
+		"D ?= e",
+		"C += $(D)",
+		"override E = e",
+		"ifneq '$(A)' 'x'",
+		"F = wrong",
+		"else ifeq ($(B),a)",
+		"F = $$HOME $@ $(notdir $(C))",
+		"else",
+		"F = wrong",
+		"endif",
+		"LOOP = $(LOOP)",
+	}
+	f, err = Parse(lines)
+	require.Nil(t, err)
+	vars = f.Variables(map[string]string{"E": "cmd"})
+	require.Equal(t, "a", vars.Value("B"))
+	require.Equal(t, "x d", vars.Value("C"))
+	require.Equal(t, "d", vars.Value("D"))
+	require.Equal(t, "e", vars.Value("E"))
+	require.Equal(t, "$HOME $@ $(notdir $(C))", vars.Value("F"))
+	require.NotPanics(t, func() { vars.Value("LOOP") })
+	v, ok := vars.Get("C")
+	require.True(t, ok)
+	require.Equal(t, "$(A) $(D)", v)
+}
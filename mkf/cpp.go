@@ -0,0 +1,151 @@
+package mkf
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CppExtensions are the supported C++ source file extensions.
+var CppExtensions = []string{".cpp", ".cc", ".cxx"}
+
+// IsCppSource returns true if the file has one of the C++ extensions.
+func IsCppSource(path string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range CppExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// AddCppSupport adds the C++ sources to the Makefile:
+// 'CPP_SOURCES' after 'C_SOURCES', 'CXX' and 'CXXFLAGS' after 'CFLAGS',
+// object lists and 'vpath' entries, the compile rules, and switches
+// the link step of the target to g++.
+// 'flags' are the C++-only compiler flags, e.g. '-fno-exceptions'.
+// 'compiler' is the path to the C++ compiler, if empty CXX is derived from CC.
+// The Makefile is not changed if there are no C++ sources.
+func (m *Mkf) AddCppSupport(sources []string, flags []string, compiler string) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	f, err := m.AST()
+	if err != nil {
+		return err
+	}
+	cSources := f.Variable("C_SOURCES")
+	if cSources == nil {
+		return fmt.Errorf("'C_SOURCES': %w", ErrEntryNotFound)
+	}
+	if f.Variable("CPP_SOURCES") != nil {
+		return fmt.Errorf("'CPP_SOURCES' is already defined")
+	}
+
+	// Extensions in use, in the order of CppExtensions
+	used := make(map[string]bool, len(CppExtensions))
+	for _, s := range sources {
+		used[filepath.Ext(s)] = true
+	}
+	extensions := make([]string, 0, len(CppExtensions))
+	for _, e := range CppExtensions {
+		if used[e] {
+			extensions = append(extensions, e)
+		}
+	}
+
+	// Sources
+	cppSources := &Node{Kind: NodeAssignment, Name: "CPP_SOURCES", Op: "="}
+	cppSources.SetValues(sources)
+	header, err := parseNodes("", "# C++ sources")
+	if err != nil {
+		return err
+	}
+	f.InsertAfter(cSources, append(header, cppSources)...)
+
+	// Compiler and flags. Without the configured compiler,
+	// CXX is derived from CC so that GCC_PATH still works.
+	cppFlags := &Node{Kind: NodeAssignment, Name: "CPP_FLAGS", Op: "="}
+	cppFlags.SetValues(flags)
+	cxx := "CXX = $(patsubst %gcc,%g++,$(CC))"
+	if compiler != "" {
+		cxx = "CXX = " + filepath.ToSlash(compiler)
+	}
+	compilerNodes, err := parseNodes("", "# C++ compiler and flags", cxx)
+	if err != nil {
+		return err
+	}
+	cxxFlags, err := parseNodes("CXXFLAGS = $(CFLAGS) $(CPP_FLAGS)")
+	if err != nil {
+		return err
+	}
+	var lastCFlags *Node
+	if a := f.Assignments("CFLAGS"); len(a) > 0 {
+		lastCFlags = a[len(a)-1]
+	}
+	f.InsertAfter(lastCFlags, append(append(compilerNodes, cppFlags), cxxFlags...)...)
+
+	// Objects and vpath entries, after the last 'vpath' line
+	objects := []string{"# list of C++ objects"}
+	for _, e := range extensions {
+		objects = append(objects,
+			fmt.Sprintf("OBJECTS += $(addprefix $(BUILD_DIR)/,$(notdir $(patsubst %%%s,%%.o,$(filter %%%s,$(CPP_SOURCES)))))", e, e),
+			fmt.Sprintf("vpath %%%s $(sort $(dir $(filter %%%s,$(CPP_SOURCES))))", e, e))
+	}
+	objectNodes, err := parseNodes(objects...)
+	if err != nil {
+		return err
+	}
+	var lastVpath *Node
+	for _, n := range f.Nodes {
+		if n.Kind == NodeOther && strings.HasPrefix(strings.TrimSpace(n.Lines[0]), "vpath ") {
+			lastVpath = n
+		}
+	}
+	f.InsertAfter(lastVpath, objectNodes...)
+
+	// Compile rules, after the C compile rule
+	rules := make([]string, 0, len(extensions)*3)
+	for _, e := range extensions {
+		rules = append(rules, "",
+			fmt.Sprintf("$(BUILD_DIR)/%%.o: %%%s Makefile | $(BUILD_DIR)", e),
+			fmt.Sprintf("\t$(CXX) -c $(CXXFLAGS) -Wa,-a,-ad,-alms=$(BUILD_DIR)/$(notdir $(<:%s=.lst)) $< -o $@", e))
+	}
+	ruleNodes, err := parseNodes(rules...)
+	if err != nil {
+		return err
+	}
+	var cRule *Node
+	for _, n := range f.Rules() {
+		if len(n.Prerequisites) > 0 && n.Prerequisites[0] == "%.c" {
+			cRule = n
+			break
+		}
+	}
+	f.InsertAfter(cRule, ruleNodes...)
+
+	// Link with g++
+	if target := f.Rule("$(BUILD_DIR)/$(TARGET).elf"); target != nil {
+		recipe := make([]string, 0, len(target.Recipe))
+		for _, r := range target.Recipe {
+			if strings.HasPrefix(r, "$(CC) ") {
+				r = "$(CXX) " + strings.TrimPrefix(r, "$(CC) ")
+			}
+			recipe = append(recipe, r)
+		}
+		target.SetRecipe(recipe)
+	}
+
+	m.Lines = f.Lines()
+	return nil
+}
+
+// parseNodes parses the lines into nodes.
+func parseNodes(lines ...string) ([]*Node, error) {
+	f, err := Parse(lines)
+	if err != nil {
+		return nil, err
+	}
+	return f.Nodes, nil
+}
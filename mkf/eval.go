@@ -0,0 +1,307 @@
+package mkf
+
+import (
+	"strings"
+)
+
+// maxExpansionDepth limits recursive variable expansion,
+// e.g. in case of 'A = $(A)'.
+const maxExpansionDepth = 32
+
+// variable is an evaluated Makefile variable.
+type variable struct {
+	value string
+	// simple is true for ':=' variables that are expanded when defined.
+	simple bool
+	// commandLine is true for variables that are passed to Variables
+	// and can't be changed by the Makefile without 'override'.
+	commandLine bool
+}
+
+// Vars are the variables of the evaluated Makefile.
+type Vars struct {
+	vars map[string]*variable
+}
+
+// Get returns the raw value of the variable.
+func (v *Vars) Get(name string) (string, bool) {
+	r, ok := v.vars[name]
+	if !ok {
+		return "", false
+	}
+	return r.value, true
+}
+
+// Expand expands the variable references in s, e.g. '$(MCU) -Wall'.
+// Automatic variables ('$@', '$(@D)' etc.), function calls and
+// substitution references are left unexpanded.
+// Undefined variables expand to an empty string, as in make.
+func (v *Vars) Expand(s string) string {
+	return v.expand(s, 0)
+}
+
+// Value returns the expanded value of the variable.
+func (v *Vars) Value(name string) string {
+	r, ok := v.vars[name]
+	if !ok {
+		return ""
+	}
+	if r.simple {
+		return r.value
+	}
+	return v.expand(r.value, 1)
+}
+
+func (v *Vars) expand(s string, depth int) string {
+	if depth > maxExpansionDepth || !strings.Contains(s, "$") {
+		return s
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '$' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		next := s[i+1]
+		switch next {
+		case '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case '(', '{':
+		default:
+			// Single-character variable: automatic variables are kept
+			name := string(next)
+			if isAutomaticVariable(next) {
+				b.WriteString(s[i : i+2])
+			} else {
+				b.WriteString(v.lookup(name, depth))
+			}
+			i++
+			continue
+		}
+		end := matchingParen(s, i+1)
+		if end == -1 {
+			b.WriteString(s[i:])
+			break
+		}
+		ref := s[i : end+1]
+		inner := s[i+2 : end]
+		i = end
+		if inner == "" || isAutomaticVariable(inner[0]) {
+			b.WriteString(ref)
+			continue
+		}
+		name := v.expand(inner, depth+1)
+		if strings.ContainsAny(name, " \t:=,$") {
+			// Function call or substitution reference
+			b.WriteString(ref)
+			continue
+		}
+		b.WriteString(v.lookup(name, depth))
+	}
+	return b.String()
+}
+
+func (v *Vars) lookup(name string, depth int) string {
+	r, ok := v.vars[name]
+	if !ok {
+		return ""
+	}
+	if r.simple {
+		return r.value
+	}
+	return v.expand(r.value, depth+1)
+}
+
+func isAutomaticVariable(c byte) bool {
+	return strings.IndexByte("@<^?*+|%", c) != -1
+}
+
+// matchingParen returns the index of the parenthesis or brace
+// that closes the one at position 'open', or -1.
+func matchingParen(s string, open int) int {
+	openChar := s[open]
+	closeChar := byte(')')
+	if openChar == '{' {
+		closeChar = '}'
+	}
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case openChar:
+			depth++
+		case closeChar:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// conditional is the state of an 'if' block during evaluation.
+type conditional struct {
+	// active is true if the current branch is selected.
+	active bool
+	// taken is true if any branch of the block was selected.
+	taken bool
+}
+
+// Variables evaluates the variable assignments of the Makefile,
+// including the conditional directives.
+// 'commandLine' are the variables passed in the make command line,
+// they take precedence over the Makefile assignments without 'override'.
+// 'define' blocks and '!=' assignments are not evaluated.
+func (f *File) Variables(commandLine map[string]string) *Vars {
+	v := &Vars{vars: make(map[string]*variable, 100)}
+	for name, value := range commandLine {
+		v.vars[name] = &variable{value: value, simple: true, commandLine: true}
+	}
+	stack := make([]conditional, 0, 4)
+	active := func() bool {
+		for _, c := range stack {
+			if !c.active {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, n := range f.Nodes {
+		switch n.Kind {
+		case NodeConditional:
+			switch n.Directive {
+			case "endif":
+				if len(stack) > 0 {
+					stack = stack[:len(stack)-1]
+				}
+			case "else":
+				if len(stack) == 0 {
+					continue
+				}
+				top := &stack[len(stack)-1]
+				if top.taken {
+					top.active = false
+					continue
+				}
+				// 'else' or 'else ifeq ...'
+				words := strings.Fields(n.Arguments)
+				cond := true
+				if len(words) > 0 {
+					cond = v.evalCondition(words[0],
+						strings.TrimSpace(strings.TrimPrefix(n.Arguments, words[0])))
+				}
+				top.active = cond
+				top.taken = cond
+			default:
+				cond := active() && v.evalCondition(n.Directive, n.Arguments)
+				stack = append(stack, conditional{active: cond, taken: cond})
+			}
+		case NodeAssignment:
+			if !active() {
+				continue
+			}
+			v.assign(n)
+		}
+	}
+	return v
+}
+
+func (v *Vars) assign(n *Node) {
+	value := strings.Join(n.Values, " ")
+	existing, defined := v.vars[n.Name]
+	override := false
+	for _, m := range n.Modifiers {
+		if m == "override" {
+			override = true
+		}
+	}
+	if defined && existing.commandLine && !override {
+		return
+	}
+	switch n.Op {
+	case "=":
+		v.vars[n.Name] = &variable{value: value}
+	case ":=", "::=":
+		v.vars[n.Name] = &variable{value: v.Expand(value), simple: true}
+	case "?=":
+		if !defined {
+			v.vars[n.Name] = &variable{value: value}
+		}
+	case "+=":
+		if !defined {
+			v.vars[n.Name] = &variable{value: value}
+			return
+		}
+		if existing.simple {
+			value = v.Expand(value)
+		}
+		if existing.value != "" {
+			value = existing.value + " " + value
+		}
+		v.vars[n.Name] = &variable{value: value, simple: existing.simple}
+	case "!=":
+		// Shell assignments are not executed
+		v.vars[n.Name] = &variable{simple: true}
+	}
+}
+
+// evalCondition evaluates 'ifeq', 'ifneq', 'ifdef' and 'ifndef' directives.
+func (v *Vars) evalCondition(directive, arguments string) bool {
+	switch directive {
+	case "ifdef", "ifndef":
+		name := strings.TrimSpace(v.Expand(arguments))
+		defined := v.Value(name) != ""
+		return defined == (directive == "ifdef")
+	case "ifeq", "ifneq":
+		a, b, ok := splitConditionArguments(arguments)
+		if !ok {
+			return false
+		}
+		equal := strings.TrimSpace(v.Expand(a)) == strings.TrimSpace(v.Expand(b))
+		return equal == (directive == "ifeq")
+	}
+	return false
+}
+
+// splitConditionArguments splits '(a,b)', "'a' 'b'" or '"a" "b"'.
+func splitConditionArguments(s string) (string, string, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		inner := s[1 : len(s)-1]
+		depth := 0
+		for i := 0; i < len(inner); i++ {
+			switch inner[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+			case ',':
+				if depth == 0 {
+					return inner[:i], inner[i+1:], true
+				}
+			}
+		}
+		return "", "", false
+	}
+	parts := make([]string, 0, 2)
+	for len(s) > 0 && len(parts) < 2 {
+		quote := s[0]
+		if quote != '\'' && quote != '"' {
+			return "", "", false
+		}
+		end := strings.IndexByte(s[1:], quote)
+		if end == -1 {
+			return "", "", false
+		}
+		parts = append(parts, s[1:end+1])
+		s = strings.TrimSpace(s[end+2:])
+	}
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
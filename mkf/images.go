@@ -0,0 +1,140 @@
+package mkf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ImageVar selects the firmware image that is built by the makefile.
+const ImageVar = "IMAGE"
+
+// ImagesBuildDirVar is the build directory of all images,
+// each image is built in its own subdirectory.
+const ImagesBuildDirVar = "IMAGES_BUILD_DIR"
+
+// Image is a firmware image built from the same makefile.
+type Image struct {
+	Name string
+	// Target is the TARGET of the image
+	Target string
+	// LinkerScript is not overridden if empty
+	LinkerScript string
+	// FlashAddress is the address the image binary is programmed at
+	FlashAddress uint64
+	CSources     []string
+	// CIncludes must be prefixed with '-I'
+	CIncludes []string
+	// CDefs must be prefixed with '-D'
+	CDefs []string
+}
+
+// AddImages adds the image settings after 'LDSCRIPT' and makes
+// the '$(BUILD_DIR)' rule create the parent directories.
+// The image is selected with the 'IMAGE' variable, the first image by default.
+func (m *Mkf) AddImages(images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+	f, err := m.AST()
+	if err != nil {
+		return err
+	}
+	ldScript := f.Variable("LDSCRIPT")
+	if ldScript == nil {
+		return fmt.Errorf("'LDSCRIPT': %w", ErrEntryNotFound)
+	}
+	lines := []string{
+		"",
+		"#######################################",
+		"# firmware images",
+		"#######################################",
+		fmt.Sprintf("%s ?= %s", ImageVar, images[0].Name),
+		fmt.Sprintf("%s := $(BUILD_DIR)", ImagesBuildDirVar),
+	}
+	for _, img := range images {
+		lines = append(lines,
+			fmt.Sprintf("ifeq ($(%s),%s)", ImageVar, img.Name),
+			"override TARGET = "+img.Target,
+			fmt.Sprintf("override BUILD_DIR = $(%s)/%s", ImagesBuildDirVar, img.Name),
+		)
+		if img.LinkerScript != "" {
+			lines = append(lines, "override LDSCRIPT = "+img.LinkerScript)
+		}
+		for _, v := range []struct {
+			name   string
+			values []string
+		}{
+			{"C_SOURCES", img.CSources},
+			{"C_INCLUDES", img.CIncludes},
+			{"C_DEFS", img.CDefs},
+		} {
+			if len(v.values) == 0 {
+				continue
+			}
+			n := &Node{Kind: NodeAssignment, Name: v.name, Op: "+="}
+			n.SetValues(v.values)
+			lines = append(lines, n.Lines...)
+		}
+		lines = append(lines, "endif")
+	}
+	nodes, err := parseNodes(lines...)
+	if err != nil {
+		return err
+	}
+	f.InsertAfter(ldScript, nodes...)
+	// The image build directories are nested, e.g. 'build/bootloader'
+	if err = makeBuildDirParents(f); err != nil {
+		return err
+	}
+	m.Lines = f.Lines()
+	return nil
+}
+
+// imageBinary returns the path of the image binary.
+func imageBinary(img Image) string {
+	return fmt.Sprintf("$(%s)/%s/%s.bin", ImagesBuildDirVar, img.Name, img.Target)
+}
+
+// ImageTargets returns the Makefile text with the image targets:
+// 'images' builds all images, '<name>' builds one image,
+// '<name>-prog' programs one image and 'prog' programs all images
+// at their flash addresses.
+func ImageTargets(images []Image, openocdInterface, openocdTarget string) []string {
+	if len(images) == 0 {
+		return nil
+	}
+	openocd := fmt.Sprintf("openocd -f interface/%s -f target/%s", openocdInterface, openocdTarget)
+	names := make([]string, 0, len(images))
+	phony := []string{"images", "prog"}
+	for _, img := range images {
+		names = append(names, img.Name)
+		phony = append(phony, img.Name, img.Name+"-prog")
+	}
+	r := []string{
+		"#######################################",
+		"# firmware image targets",
+		"#######################################",
+		".PHONY: " + strings.Join(phony, " "),
+		"images: " + strings.Join(names, " "),
+	}
+	progAll := make([]string, 0, len(images))
+	for i, img := range images {
+		programCmd := fmt.Sprintf("program %s 0x%08X verify", imageBinary(img), img.FlashAddress)
+		r = append(r,
+			img.Name+":",
+			fmt.Sprintf("\t$(MAKE) %s=%s all", ImageVar, img.Name),
+			img.Name+"-prog: "+img.Name,
+			fmt.Sprintf("\t%s -c \"%s reset exit\"", openocd, programCmd),
+		)
+		if i == len(images)-1 {
+			programCmd += " reset exit"
+		}
+		progAll = append(progAll, fmt.Sprintf("-c \"%s\"", programCmd))
+	}
+	r = append(r,
+		"prog: images",
+		fmt.Sprintf("\t%s %s", openocd, strings.Join(progAll, " ")),
+		"",
+	)
+	return r
+}
@@ -4,12 +4,9 @@ package mkf
 import (
 	"errors"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/mcu-art/ergomcutool/config"
 	"github.com/mcu-art/ergomcutool/utils"
@@ -94,80 +91,53 @@ func FromFile(path string) (m *Mkf, err error) {
 	return m, err
 }
 
-// RemoveValue removes the value of the specified entry.
-// It doesn't remove the entry itself.
-func (m *Mkf) RemoveValue(entryName string) error {
-	entryFound := false
-	var startIndex, endIndex int
-	for i, line := range m.Lines {
-		if strings.HasPrefix(line, entryName) {
-			startIndex = i
-			endIndex = i
-			entryFound = true
+// AST parses the Makefile lines into nodes.
+func (m *Mkf) AST() (*File, error) {
+	return Parse(m.Lines)
+}
 
-		}
-		if entryFound {
-			if strings.HasSuffix(line, "\\") {
-				continue
-			}
-			endIndex = i
-			break
-		}
-	}
-	if !entryFound {
-		return ErrEntryNotFound
+// variable returns the parsed Makefile and the first assignment
+// of the specified variable. The name must match exactly.
+func (m *Mkf) variable(entryName string) (*File, *Node, error) {
+	f, err := m.AST()
+	if err != nil {
+		return f, nil, err
 	}
-	m.Lines[startIndex] = entryName + " = "
-	if startIndex != endIndex {
-		tmp := make([]string, 0, len(m.Lines))
-		tmp = append(tmp, m.Lines[:startIndex+1]...)
-		tmp = append(tmp, m.Lines[endIndex+1:]...)
-		m.Lines = tmp
+	n := f.Variable(entryName)
+	if n == nil {
+		return f, nil, ErrEntryNotFound
 	}
-	return nil
+	return f, n, nil
+}
+
+// RemoveValue removes the value of the specified entry.
+// It doesn't remove the entry itself.
+func (m *Mkf) RemoveValue(entryName string) error {
+	return m.ReplaceValue(entryName, nil)
 }
 
-// InsertValue writes the value of the specified entry.
+// InsertValue appends the values to the specified entry.
 // It doesn't create the entry itself.
 func (m *Mkf) InsertValue(entryName string, values []string) error {
-	entryFound := false
-	index := 0
-	for i, line := range m.Lines {
-		if strings.HasPrefix(line, entryName) {
-			entryFound = true
-			index = i
-			if len(values) == 1 {
-				m.Lines[i] = line + values[0]
-				return nil
-			}
-			break
-		}
-	}
-	if !entryFound {
-		return ErrEntryNotFound
-	}
-	// Insert multiline value
-	m.Lines[index] += " \\"
-	tmp := make([]string, 0, len(m.Lines)+len(values))
-	tmp = append(tmp, m.Lines[:index+1]...)
-
-	for j := 0; j < len(values)-1; j++ {
-		tmp = append(tmp, values[j]+" \\")
+	f, n, err := m.variable(entryName)
+	if err != nil {
+		return err
 	}
-	// last value must not contain trailing backslash
-	tmp = append(tmp, values[len(values)-1])
-	tmp = append(tmp, m.Lines[index+1:]...)
-	m.Lines = tmp
+	n.SetValues(append(append([]string{}, n.Values...), values...))
+	m.Lines = f.Lines()
 	return nil
 }
 
-// ReplaceValue removes the original entry value and inserts the new one.
+// ReplaceValue replaces the value of the specified entry.
+// The assignment operator and comments outside the entry are preserved.
 func (m *Mkf) ReplaceValue(entryName string, values []string) error {
-	err := m.RemoveValue(entryName)
+	f, n, err := m.variable(entryName)
 	if err != nil {
 		return err
 	}
-	return m.InsertValue(entryName, values)
+	n.SetValues(values)
+	m.Lines = f.Lines()
+	return nil
 }
 
 // AppendTextLines appends a text block at the end of the makefile,
@@ -254,58 +224,14 @@ func (m *Mkf) readAutoEditedVersion() string {
 	return ""
 }
 
+// ReadValue returns the value items of the first assignment
+// of the specified entry, one item per line.
 func (m *Mkf) ReadValue(entryName string) ([]string, error) {
-	r := make([]string, 0, 50)
-	checkNextLine := false
-	for _, line := range m.Lines {
-		if checkNextLine {
-			line = utils.TrimRightSpace(line)
-			if len(line) == 0 {
-				return r, nil
-			}
-			// Check if last character is '\'
-			if strings.HasSuffix(line, "\\") {
-				line = utils.TrimRightSpace(line[:len(line)-1])
-				if line != "" {
-					r = append(r, line)
-				}
-			} else { // line doesn't contain '\' at the end, it's the last line
-				if line != "" {
-					r = append(r, line)
-				}
-				return r, nil
-			}
-			continue
-		}
-		if strings.HasPrefix(line, entryName) {
-			pos := strings.Index(line, "=")
-			if pos == -1 {
-				return r, ErrValueNotFound
-			}
-			value := line[pos+1:]
-			value = strings.TrimSpace(value)
-
-			valueLength := len(value)
-			if valueLength == 0 { // empty value
-				return r, nil
-			}
-			// Check if last character is '\'
-			if strings.HasSuffix(value, "\\") {
-				value = strings.TrimSpace(value[:valueLength-1])
-				checkNextLine = true
-				if value != "" {
-					r = append(r, value)
-				}
-			} else { // line doesn't contain '\' at the end
-				r = append(r, value)
-			}
-			if checkNextLine {
-				continue
-			}
-			return r, nil
-		}
+	_, n, err := m.variable(entryName)
+	if err != nil {
+		return []string{}, err
 	}
-	return r, ErrEntryNotFound
+	return append([]string{}, n.Values...), nil
 }
 
 // ParseMkf parses the internal Lines field into ParsedMkf.
@@ -369,68 +295,10 @@ func (m *Mkf) String() string {
 // '_non_persistent/backups/makefile/'.
 func BackupMakefile(makefilePath string) error {
 	backupDir := filepath.Join("_non_persistent", "backups", "makefile")
-	err := os.MkdirAll(backupDir, fs.FileMode(config.DefaultDirPermissions))
-	if err != nil {
-		return fmt.Errorf("(BackupMakefile) failed to create directory %q: %w", backupDir, err)
-	}
-	// Get a list of backup files.
-	buFiles, err := utils.GetFileList(backupDir)
+	_, err := utils.BackupFile(makefilePath, backupDir, "makefile",
+		config.MakefileBackupsLimit, true, config.DefaultDirPermissions)
 	if err != nil {
-		return fmt.Errorf("(BackupMakefile) failed get file list of %q: %w", backupDir, err)
-	}
-
-	// Get the first and the last file prefix
-	var minFilePrefix uint64 = 0xFFFFFFFF
-	var maxFilePrefix uint64 = 0
-	for _, file := range buFiles {
-		split := strings.Split(file, "-")
-		if len(split) < 2 {
-			continue
-		}
-		prefixNum, err := strconv.ParseUint(split[0], 10, 32)
-		if err != nil {
-			continue
-		}
-		if prefixNum > maxFilePrefix {
-			maxFilePrefix = prefixNum
-		}
-		if prefixNum < minFilePrefix {
-			minFilePrefix = prefixNum
-		}
-	}
-
-	// Generate file name for new backup
-	t := time.Now()
-	date := t.Format("2006_01_02")
-	fileName := fmt.Sprintf("%d-makefile-%s", maxFilePrefix+1, date)
-	dest := filepath.Join(backupDir, fileName)
-	err = os.Rename(makefilePath, dest)
-	if err != nil {
-		return fmt.Errorf(
-			"(BackupMakefile) failed move file %q to %q: %w", makefilePath, dest, err)
-	}
-
-	// Remove old backups if number of backup files exceeds limit
-	if len(buFiles)+1 > config.MakefileBackupsLimit {
-		leastPrefix := fmt.Sprintf("%d-", minFilePrefix)
-		fileName = ""
-		for _, file := range buFiles {
-			if strings.HasPrefix(file, leastPrefix) {
-				fileName = file
-				break
-			}
-		}
-		if fileName == "" {
-			return fmt.Errorf(
-				"(BackupMakefile) failed to find file with prefix %q: %w",
-				leastPrefix, err)
-		}
-		dest := filepath.Join(backupDir, fileName)
-		err = os.Remove(dest)
-		if err != nil {
-			return fmt.Errorf(
-				"(BackupMakefile) failed remove file %q: %w", dest, err)
-		}
+		return fmt.Errorf("(BackupMakefile) %w", err)
 	}
 	return nil
 }
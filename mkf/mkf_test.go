@@ -1,6 +1,7 @@
 package mkf
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -121,3 +122,134 @@ TEST_ENTRY_2: \
 	err := m.AppendString(s, true)
 	require.Nil(t, err)
 }
+
+func TestAddCppSupport(t *testing.T) {
+	sample1Path := "./test_data/sample1.txt"
+	m, _ := FromFile(sample1Path)
+	originalLength := len(m.Lines)
+	err := m.AddCppSupport(nil, nil, "")
+	require.Nil(t, err)
+	require.Equal(t, originalLength, len(m.Lines))
+
+	err = m.AddCppSupport([]string{"App/app.cpp", "App/util.cc"}, []string{"-fno-exceptions"}, "")
+	require.Nil(t, err)
+
+	vals, err := m.ReadValue("CPP_SOURCES")
+	require.Nil(t, err)
+	require.Equal(t, []string{"App/app.cpp", "App/util.cc"}, vals)
+	vals, err = m.ReadValue("CPP_FLAGS")
+	require.Nil(t, err)
+	require.Equal(t, []string{"-fno-exceptions"}, vals)
+
+	f, err := m.AST()
+	require.Nil(t, err)
+	require.NotNil(t, f.Variable("CXX"))
+	require.NotNil(t, f.Variable("CXXFLAGS"))
+	for _, ext := range []string{"%.cpp", "%.cc"} {
+		found := false
+		for _, r := range f.Rules() {
+			if len(r.Prerequisites) > 0 && r.Prerequisites[0] == ext {
+				found = true
+				require.True(t, strings.HasPrefix(r.Recipe[0], "$(CXX) -c $(CXXFLAGS)"))
+			}
+		}
+		require.True(t, found, ext)
+	}
+	require.NotContains(t, m.String(), "%.cxx")
+	elf := f.Rule("$(BUILD_DIR)/$(TARGET).elf")
+	require.NotNil(t, elf)
+	require.Equal(t, "$(CXX) $(OBJECTS) $(LDFLAGS) -o $@", elf.Recipe[0])
+
+	err = m.AddCppSupport([]string{"main.cpp"}, nil, "")
+	require.NotNil(t, err)
+	vals, err = m.ReadValue("CXX")
+	require.Nil(t, err)
+	require.Equal(t, []string{"$(patsubst %gcc,%g++,$(CC))"}, vals)
+
+	// The configured C++ compiler
+	m, _ = FromFile(sample1Path)
+	err = m.AddCppSupport([]string{"main.cpp"}, nil, "/opt/arm/bin/arm-none-eabi-g++")
+	require.Nil(t, err)
+	vals, err = m.ReadValue("CXX")
+	require.Nil(t, err)
+	require.Equal(t, []string{"/opt/arm/bin/arm-none-eabi-g++"}, vals)
+}
+
+func TestBuildVariants(t *testing.T) {
+	m, _ := FromFile("./test_data/sample1.txt")
+	err := m.AddVariantCDefs([]string{"-DNDEBUG"})
+	require.Nil(t, err)
+	vals, err := m.ReadValue(VariantCDefsVar)
+	require.Nil(t, err)
+	require.Equal(t, []string{"-DNDEBUG"}, vals)
+	f, err := m.AST()
+	require.Nil(t, err)
+	require.Contains(t, f.Variables(nil).Value("C_DEFS"), "-DNDEBUG")
+
+	lines := VariantTargets([]BuildVariant{
+		{Name: "release", Vars: map[string]string{"OPT": "-O2", VariantCDefsVar: "-DNDEBUG -DX=1"}},
+	}, "all")
+	require.Contains(t, lines, ".PHONY: release release-prog")
+	require.Contains(t, lines, "\t$(MAKE) OPT=-O2 VARIANT_C_DEFS='-DNDEBUG -DX=1' all")
+	require.Contains(t, lines, "\t$(MAKE) OPT=-O2 VARIANT_C_DEFS='-DNDEBUG -DX=1' prog")
+	require.Nil(t, VariantTargets(nil, "all"))
+
+	// The nested variant build directories are created with their parents
+	require.Equal(t, []string{"mkdir $@"}, buildDirRecipe(t, m))
+	require.Nil(t, m.MakeBuildDirParents())
+	require.Equal(t, []string{"mkdir -p $@"}, buildDirRecipe(t, m))
+	require.Nil(t, m.MakeBuildDirParents())
+	require.Equal(t, []string{"mkdir -p $@"}, buildDirRecipe(t, m))
+}
+
+// buildDirRecipe returns the non-empty recipe lines of the '$(BUILD_DIR)' rule.
+func buildDirRecipe(t *testing.T, m *Mkf) []string {
+	f, err := m.AST()
+	require.Nil(t, err)
+	rule := f.Rule("$(BUILD_DIR)")
+	require.NotNil(t, rule)
+	r := make([]string, 0, len(rule.Recipe))
+	for _, line := range rule.Recipe {
+		if line = strings.TrimSpace(line); line != "" {
+			r = append(r, line)
+		}
+	}
+	return r
+}
+
+func TestImages(t *testing.T) {
+	m, _ := FromFile("./test_data/sample1.txt")
+	images := []Image{
+		{Name: "bootloader", Target: "boot", LinkerScript: "boot.ld", FlashAddress: 0x08000000,
+			CSources: []string{"Boot/boot.c"}, CDefs: []string{"-DBOOTLOADER"}},
+		{Name: "app", Target: "app", FlashAddress: 0x08008000, CIncludes: []string{"-IApp"}},
+	}
+	require.Nil(t, m.AddImages(images))
+	f, err := m.AST()
+	require.Nil(t, err)
+
+	vars := f.Variables(nil)
+	require.Equal(t, "boot", vars.Value("TARGET"))
+	require.Equal(t, "build/bootloader", vars.Value("BUILD_DIR"))
+	require.Equal(t, "boot.ld", vars.Value("LDSCRIPT"))
+	require.Contains(t, vars.Value("C_SOURCES"), "Boot/boot.c")
+	require.Contains(t, vars.Value("C_DEFS"), "-DBOOTLOADER")
+	require.Equal(t, []string{"mkdir -p $@"}, buildDirRecipe(t, m))
+
+	vars = f.Variables(map[string]string{"IMAGE": "app", "BUILD_DIR": "build/release"})
+	require.Equal(t, "app", vars.Value("TARGET"))
+	require.Equal(t, "build/release/app", vars.Value("BUILD_DIR"))
+	require.Equal(t, "STM32G431CBUx_FLASH.ld", vars.Value("LDSCRIPT"))
+	require.NotContains(t, vars.Value("C_SOURCES"), "Boot/boot.c")
+	require.Contains(t, vars.Value("C_INCLUDES"), "-IApp")
+
+	lines := ImageTargets(images, "stlink.cfg", "stm32g4x.cfg")
+	require.Contains(t, lines, "images: bootloader app")
+	require.Contains(t, lines, "\t$(MAKE) IMAGE=app all")
+	require.Contains(t, lines, "\topenocd -f interface/stlink.cfg -f target/stm32g4x.cfg"+
+		" -c \"program $(IMAGES_BUILD_DIR)/app/app.bin 0x08008000 verify reset exit\"")
+	require.Contains(t, lines, "\topenocd -f interface/stlink.cfg -f target/stm32g4x.cfg"+
+		" -c \"program $(IMAGES_BUILD_DIR)/bootloader/boot.bin 0x08000000 verify\""+
+		" -c \"program $(IMAGES_BUILD_DIR)/app/app.bin 0x08008000 verify reset exit\"")
+	require.Nil(t, ImageTargets(nil, "", ""))
+}
@@ -0,0 +1,133 @@
+package mkf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VariantCDefsVar is the variable that holds the definitions
+// of the selected build variant. It is appended to 'C_DEFS'.
+const VariantCDefsVar = "VARIANT_C_DEFS"
+
+// BuildVariant is a named set of Makefile variable overrides.
+type BuildVariant struct {
+	Name string
+	// Vars are passed to make in the command line, e.g. 'BUILD_DIR', 'OPT'.
+	Vars map[string]string
+}
+
+// AddVariantCDefs adds 'VARIANT_C_DEFS' with the specified definitions
+// after 'C_DEFS' and appends it to 'C_DEFS'.
+// The definitions must be prefixed with '-D'.
+func (m *Mkf) AddVariantCDefs(defs []string) error {
+	f, err := m.AST()
+	if err != nil {
+		return err
+	}
+	cDefs := f.Variable("C_DEFS")
+	if cDefs == nil {
+		return fmt.Errorf("'C_DEFS': %w", ErrEntryNotFound)
+	}
+	variantDefs := &Node{Kind: NodeAssignment, Name: VariantCDefsVar, Op: "="}
+	variantDefs.SetValues(defs)
+	nodes, err := parseNodes("", "# build variant definitions")
+	if err != nil {
+		return err
+	}
+	appendDefs, err := parseNodes(fmt.Sprintf("C_DEFS += $(%s)", VariantCDefsVar))
+	if err != nil {
+		return err
+	}
+	nodes = append(nodes, variantDefs)
+	nodes = append(nodes, appendDefs...)
+	f.InsertAfter(cDefs, nodes...)
+	m.Lines = f.Lines()
+	return nil
+}
+
+// MakeBuildDirParents makes the '$(BUILD_DIR)' rule create the parent directories,
+// STM32CubeMX runs 'mkdir $@' that fails for the nested build directories
+// of the variants, e.g. 'build/debug'.
+func (m *Mkf) MakeBuildDirParents() error {
+	f, err := m.AST()
+	if err != nil {
+		return err
+	}
+	if err = makeBuildDirParents(f); err != nil {
+		return err
+	}
+	m.Lines = f.Lines()
+	return nil
+}
+
+// makeBuildDirParents adds '-p' to the 'mkdir' commands of the '$(BUILD_DIR)' rule.
+func makeBuildDirParents(f *File) error {
+	rule := f.Rule("$(BUILD_DIR)")
+	if rule == nil {
+		return fmt.Errorf("'$(BUILD_DIR)' rule: %w", ErrEntryNotFound)
+	}
+	recipe := make([]string, 0, len(rule.Recipe))
+	changed := false
+	for _, r := range rule.Recipe {
+		fields := strings.Fields(r)
+		if len(fields) > 1 && fields[0] == "mkdir" && fields[1] != "-p" {
+			r = "mkdir -p " + strings.Join(fields[1:], " ")
+			changed = true
+		}
+		recipe = append(recipe, r)
+	}
+	if changed {
+		rule.SetRecipe(recipe)
+	}
+	return nil
+}
+
+// quoteShell quotes the value for the recipe shell if necessary.
+func quoteShell(s string) string {
+	if !strings.ContainsAny(s, " \t\"'$\\;&|<>()") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// VariantTargets returns the Makefile text with one phony target per variant.
+// Each target runs make recursively with the variant variables,
+// e.g. 'make release' or 'make release-prog'.
+// buildTarget is the target built by the variant target, e.g. 'all'.
+func VariantTargets(variants []BuildVariant, buildTarget string) []string {
+	if len(variants) == 0 {
+		return nil
+	}
+	r := []string{
+		"#######################################",
+		"# build variants",
+		"#######################################",
+	}
+	phony := make([]string, 0, len(variants)*2)
+	for _, v := range variants {
+		phony = append(phony, v.Name, v.Name+"-prog")
+	}
+	r = append(r, ".PHONY: "+strings.Join(phony, " "))
+
+	for _, v := range variants {
+		names := make([]string, 0, len(v.Vars))
+		for name := range v.Vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		args := make([]string, 0, len(names))
+		for _, name := range names {
+			args = append(args, name+"="+quoteShell(v.Vars[name]))
+		}
+		argString := strings.Join(args, " ")
+		r = append(r,
+			v.Name+":",
+			fmt.Sprintf("\t$(MAKE) %s %s", argString, buildTarget),
+			v.Name+"-prog:",
+			fmt.Sprintf("\t$(MAKE) %s prog", argString),
+		)
+	}
+	r = append(r, "")
+	return r
+}
@@ -0,0 +1,254 @@
+package ergomcu
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/mcu-art/ergomcutool/tpl"
+	"github.com/mcu-art/ergomcutool/utils"
+)
+
+// CreateOptions are the options of Create.
+type CreateOptions struct {
+	// AllowNoIoc creates the project even if the directory has no .ioc file.
+	AllowNoIoc bool
+}
+
+// Create creates a new project in the directory based on its .ioc file
+// and opens it. The local configuration is created if it doesn't exist.
+// The user configuration directory may not exist if the user configuration
+// file is replaced by the overrides.
+// Returns ErrNotInitialized if the user configuration doesn't exist,
+// ErrProjectExists if the directory already has a project file and
+// ErrNoIocFile if it has no .ioc file and opts.AllowNoIoc is false.
+func Create(dir string, cfg *Config, opts CreateOptions) (*Project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.overrides().UserConfigFile == "" && !utils.DirExists(cfg.userConfigDir()) {
+		return nil, ErrNotInitialized
+	}
+	tool, err := cfg.toolConfig(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	p := &Project{Dir: dir, Config: cfg, Tool: tool}
+	logger := cfg.logger()
+
+	// Return if project file already exists
+	if utils.FileExists(p.path(config.ProjectFilePath)) {
+		return nil, fmt.Errorf("%w: %q", ErrProjectExists, p.path(config.ProjectFilePath))
+	}
+
+	// Read the .ioc file
+	iocFiles, err := iocfile.FindIocFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file list of the directory %q: %w", dir, err)
+	}
+	// iocFile is the selected .ioc file
+	var iocFile string
+	var ioc *iocfile.Ioc
+	parsedIoc := &iocfile.ParsedIoc{}
+	parsedIoc.ProjectName = "unnamed_project"
+	parsedIoc.DeviceId = "unknown_device"
+	if len(iocFiles) == 0 {
+		if !opts.AllowNoIoc {
+			return nil, fmt.Errorf("%w in %q", ErrNoIocFile, dir)
+		}
+	} else {
+		iocFile = iocFiles[0]
+		if len(iocFiles) > 1 {
+			logger.Printf(`warning: specified directory contains more than one .ioc file.
+File %q will be used to collect the required data.
+`, iocFile)
+		}
+		ioc, err = iocfile.FromFile(p.path(iocFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the .ioc file %q: %w", iocFile, err)
+		}
+		parsedIoc, err = ioc.Parse()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the .ioc file %q: %w", iocFile, err)
+		}
+	}
+
+	projectTemplateReplacements := &proj.ErgomcuProjectTemplateReplacements{
+		ErgomcutoolVersion: config.FileSchemaVersion,
+		ProjectName:        parsedIoc.ProjectName,
+		DeviceId:           parsedIoc.DeviceId,
+	}
+	projectTemplateReplacements.OpenocdTarget = findOpenocdTargetFile(tool, logger,
+		projectTemplateReplacements.DeviceId)
+	if projectTemplateReplacements.OpenocdTarget == "" {
+		projectTemplateReplacements.OpenocdTarget = "unknown_openocd_target"
+	}
+
+	// Files and templates should be taken from
+	// the user config directory, that gives the user an ability
+	// to customize them if necessary.
+	assetsDir, cleanup, err := cfg.assetsDir()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// Copy asset files
+	err = utils.CopyDir(filepath.Join(assetsDir, "files"), dir,
+		config.DefaultDirPermissions, config.DefaultFilePermissions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy project files into %q: %w", dir, err)
+	}
+
+	// Rename the default files unless the project has its own
+	for _, name := range []string{".gitignore", ".clang-format"} {
+		if !utils.FileExists(p.path(name)) {
+			_ = os.Rename(p.path(name+"-default"), p.path(name))
+		}
+	}
+
+	// Instantiate templates
+	templatesDir := filepath.Join(assetsDir, "templates")
+	instantiate := func(templateFileName, dest string) error {
+		s, err := tpl.InstantiateToString(templatesDir, templateFileName, projectTemplateReplacements)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(p.path(dest), []byte(s), fs.FileMode(config.DefaultFilePermissions))
+	}
+	if err = instantiate("ergomcu_project.yaml.tmpl", config.ProjectFilePath); err != nil {
+		return nil, fmt.Errorf("failed to instantiate file %q: %w", config.ProjectFilePath, err)
+	}
+	if err = instantiate("README.md.tmpl", "README.md"); err != nil {
+		logger.Printf("warning: failed to instantiate file %q: %v.\n", p.path("README.md"), err)
+	}
+
+	// Create '_non_persistent' directory
+	nonPersistentDirPath := p.path("_non_persistent")
+	if !utils.DirExists(nonPersistentDirPath) {
+		err := os.Mkdir(nonPersistentDirPath, fs.FileMode(config.DefaultDirPermissions))
+		if err != nil {
+			logger.Printf("warning: failed to create directory %q: %v.\n",
+				nonPersistentDirPath, err)
+		}
+	}
+	// Grant execute permissions to the project scripts
+	scriptsDir := p.path(config.ProjectScriptsDir)
+	scriptFiles, err := utils.GetFileList(scriptsDir)
+	if err != nil {
+		logger.Printf("warning: failed to list files in directory %q: %v.\n",
+			config.ProjectScriptsDir, err)
+	}
+	for _, file := range scriptFiles {
+		filePath := filepath.Join(scriptsDir, file)
+		err := os.Chmod(filePath, fs.FileMode(config.DefaultScriptPermissions))
+		if err != nil {
+			logger.Printf("warning: failed to grant 'execute' permissions to %q: %v.\n",
+				filePath, err)
+		}
+	}
+
+	// Patch the .ioc file UserActions
+	if ioc != nil {
+		patchIocUserActions(ioc, logger)
+
+		// Remove old backup file and create new
+		backupDir := p.path("_non_persistent", "backups")
+		_ = os.MkdirAll(backupDir, fs.FileMode(config.DefaultDirPermissions))
+		backupFile := filepath.Join(backupDir, iocFile+".backup")
+		_ = os.Remove(backupFile)
+		_ = os.Rename(p.path(iocFile), backupFile)
+		err = ioc.WriteFile(p.path(iocFile), config.DefaultFilePermissions)
+		if err != nil {
+			logger.Printf("warning: failed to update file %q: %v.\n", iocFile, err)
+		}
+	}
+
+	// Copy .vscode files
+	src := filepath.Join(assetsDir, "linux", ".vscode")
+	err = utils.CopyDir(src, p.path(".vscode"), config.DefaultDirPermissions, config.DefaultFilePermissions)
+	if err != nil {
+		logger.Printf("warning: failed to copy .vscode directory from %q: %v.\n", src, err)
+	}
+
+	if p.Spec, err = p.read(logger); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// patchIocUserActions sets the CubeMX user action scripts
+// unless they are already defined.
+func patchIocUserActions(ioc *iocfile.Ioc, logger *log.Logger) {
+	actions := []struct {
+		key    string
+		script string
+	}{
+		{"ProjectManager.UAScriptBeforePath", config.CubeMXBeforeGenerateScript},
+		{"ProjectManager.UAScriptAfterPath", config.CubeMXAfterGenerateScript},
+	}
+	for _, a := range actions {
+		oldValue, _ := ioc.Get(a.key)
+		if oldValue != "" {
+			logger.Printf("warning: %q value already exists, original value left intact.\n", a.key)
+			continue
+		}
+		ioc.Set(a.key, a.script)
+	}
+}
+
+// findOpenocdTargetFile tries to identify openocd target file by matching DeviceId
+// with the file names from the openocd scripts/target dir.
+func findOpenocdTargetFile(tool *config.ToolConfigT, logger *log.Logger, deviceId string) string {
+	result := ""
+	scriptsTargetDir := filepath.Join(*tool.Openocd.ScriptsPath, "target")
+	availableTargets, err := utils.GetFileList(scriptsTargetDir)
+	if err != nil {
+		logger.Printf("warning: failed to get file list of the directory %q: %v.\n", scriptsTargetDir, err)
+		return ""
+	}
+
+	targetNameLower := strings.ToLower(deviceId)
+	var prefixSize uint32 = 4 // start with first 4 letters of the file name
+	numberOfMatches := 0
+	for int(prefixSize) <= len(targetNameLower) {
+		matches := make([]string, 0, 50)
+		prefix := targetNameLower[:prefixSize]
+		for _, targetFile := range availableTargets {
+			if strings.HasPrefix(targetFile, prefix) {
+				matches = append(matches, targetFile)
+			}
+		}
+		if len(matches) == 0 {
+			if result == "" {
+				logger.Printf(`warning: openocd target couldn't be figured out automatically.
+You either haven't properly configured openocd, or the specified device family
+%q is not supported. Check the configuration and fix this issue manually
+if on-chip debugger functionality is required.
+`, deviceId)
+			}
+			break
+		}
+		result = matches[0]
+		numberOfMatches = len(matches)
+		if numberOfMatches == 1 {
+			break
+		}
+		prefixSize++
+	}
+
+	if numberOfMatches > 1 {
+		logger.Printf(`warning: more than one openocd target matches
+the specified device family %q.
+File %q was chosen as a target, please verify manually if that choice is correct.
+`, deviceId, result)
+	}
+	return result
+}
@@ -0,0 +1,190 @@
+// Package ergomcu is the Go API of ergomcutool.
+// It creates and updates the projects in explicit directories
+// with an explicit configuration and returns errors instead of exiting,
+// so that different projects can be updated concurrently.
+//
+//	cfg := &ergomcu.Config{UserConfigDir: dir, Logger: log.Default()}
+//	p, err := ergomcu.Open("path/to/project", cfg)
+//	...
+//	changes, err := p.Update(ctx, ergomcu.UpdateOptions{DryRun: true})
+package ergomcu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mcu-art/ergomcutool/assets"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/gitdep"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/mcu-art/ergomcutool/utils"
+)
+
+var (
+	// ErrNotInitialized is returned if the user configuration doesn't exist,
+	// 'ergomcutool init' creates it.
+	ErrNotInitialized = config.ErrNotInitialized
+	// ErrProjectNotFound is returned if the directory has no project file.
+	ErrProjectNotFound = errors.New("project file doesn't exist")
+	// ErrProjectExists is returned if the project is created in the directory
+	// that already has a project file.
+	ErrProjectExists = errors.New("project file already exists")
+	// ErrNoIocFile is returned if the project is created in the directory
+	// without a .ioc file.
+	ErrNoIocFile = errors.New("no .ioc file found")
+	// ErrMakefileNotFound is returned if the Makefile of the project doesn't exist.
+	ErrMakefileNotFound = errors.New("makefile doesn't exist")
+	// ErrMakefileVariableNotFound is returned if the Makefile doesn't define
+	// a variable required by the update, e.g. 'BUILD_DIR'.
+	ErrMakefileVariableNotFound = errors.New("makefile variable is not defined")
+	// ErrNewerVersion is returned if the configuration or the project file
+	// was written by a newer ergomcutool.
+	ErrNewerVersion = config.ErrNewerVersion
+	// ErrDependencyNotFetched is returned if a git dependency is not fetched,
+	// 'ergomcutool deps fetch' fetches it.
+	ErrDependencyNotFetched = errors.New("git dependency is not fetched")
+)
+
+// ConfigValidationError is returned if the tool configuration is invalid.
+type ConfigValidationError = config.ValidationError
+
+// ProjectValidationError is returned if the project file is invalid.
+type ProjectValidationError = proj.ValidationError
+
+// DependencyError is an error of the external dependency.
+type DependencyError struct {
+	Var string
+	Err error
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("external dependency %q: %v", e.Var, e.Err)
+}
+
+func (e *DependencyError) Unwrap() error {
+	return e.Err
+}
+
+// Config is the environment of the API calls.
+type Config struct {
+	// UserConfigDir contains the user configuration file and the assets,
+	// config.UserConfigDir by default.
+	UserConfigDir string
+	// Tool is the tool configuration. If nil, it is loaded from the
+	// user configuration and the local configuration of the project.
+	Tool *config.ToolConfigT
+	// Overrides are applied over the loaded configuration files,
+	// config.DefaultOverrides if nil.
+	Overrides *config.Overrides
+	// Logger receives the progress messages and warnings,
+	// they are discarded if nil.
+	Logger *log.Logger
+	// Verbose enables the detailed progress messages.
+	Verbose bool
+}
+
+func (c *Config) userConfigDir() string {
+	if c.UserConfigDir == "" {
+		return config.UserConfigDir
+	}
+	return c.UserConfigDir
+}
+
+// assetsDir returns the assets directory of the user configuration.
+// If it doesn't exist, e.g. the user configuration file is replaced by
+// the overrides in CI, the embedded assets are extracted into a temporary
+// directory that is removed by the returned function.
+func (c *Config) assetsDir() (string, func(), error) {
+	dir := filepath.Join(c.userConfigDir(), "assets")
+	if utils.DirExists(dir) {
+		return dir, func() {}, nil
+	}
+	tmp, err := os.MkdirTemp("", "ergomcutool-assets-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(tmp) }
+	if err = assets.CopyAssets(tmp, config.DefaultDirPermissions, config.DefaultFilePermissions); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to copy the embedded assets: %w", err)
+	}
+	return filepath.Join(tmp, "assets"), cleanup, nil
+}
+
+func (c *Config) logger() *log.Logger {
+	if c.Logger == nil {
+		return log.New(io.Discard, "", 0)
+	}
+	return c.Logger
+}
+
+func (c *Config) overrides() *config.Overrides {
+	if c.Overrides == nil {
+		return config.DefaultOverrides
+	}
+	return c.Overrides
+}
+
+// toolConfig returns the tool configuration of the project in dir.
+func (c *Config) toolConfig(dir string, createLocal bool) (*config.ToolConfigT, error) {
+	if c.Tool != nil {
+		return c.Tool, nil
+	}
+	return config.LoadWithOverrides(c.userConfigDir(), dir, createLocal, c.overrides(), c.logger())
+}
+
+// Project is an ergomcutool project.
+type Project struct {
+	// Dir is the absolute path to the project root.
+	Dir    string
+	Config *Config
+	// Tool is the tool configuration of the project.
+	Tool *config.ToolConfigT
+	// Spec is the project file merged with the tool configuration.
+	Spec *proj.ErgomcuProjectT
+}
+
+// Open reads and validates the project in the directory.
+// Returns ErrProjectNotFound if the directory has no project file,
+// ErrNewerVersion if the project file was written by a newer ergomcutool,
+// *ConfigValidationError or *ProjectValidationError if the configuration
+// or the project file is invalid.
+func Open(dir string, cfg *Config) (*Project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	tool, err := cfg.toolConfig(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	p := &Project{Dir: dir, Config: cfg, Tool: tool}
+	if p.Spec, err = p.read(cfg.logger()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// read reads the project file, the warnings are printed to the logger.
+func (p *Project) read(logger *log.Logger) (*proj.ErgomcuProjectT, error) {
+	pc, err := proj.Read(p.Dir, gitdep.CacheDir(p.Config.userConfigDir()), p.Tool, logger)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrProjectNotFound, filepath.Join(p.Dir, config.ProjectFilePath))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// path returns the file system path of the project path.
+func (p *Project) path(elem ...string) string {
+	if len(elem) > 0 && filepath.IsAbs(elem[0]) {
+		return filepath.Join(elem...)
+	}
+	return filepath.Join(append([]string{p.Dir}, elem...)...)
+}
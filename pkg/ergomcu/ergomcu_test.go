@@ -0,0 +1,261 @@
+package ergomcu
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/assets"
+	"github.com/mcu-art/ergomcutool/backup"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/gitdep"
+	"github.com/mcu-art/ergomcutool/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig creates the user configuration in a temporary directory.
+func testConfig(t *testing.T) *Config {
+	dir := t.TempDir()
+	err := assets.CopyAssets(dir, config.DefaultDirPermissions, config.DefaultFilePermissions)
+	require.Nil(t, err)
+	err = os.Rename(filepath.Join(dir, "assets", config.UserConfigFileName),
+		filepath.Join(dir, config.UserConfigFileName))
+	require.Nil(t, err)
+	return &Config{UserConfigDir: dir}
+}
+
+// testProject creates a project with the .ioc file and the Makefile
+// in a temporary directory.
+func testProject(t *testing.T, cfg *Config) *Project {
+	dir := t.TempDir()
+	err := utils.CopyFile("../../iocfile/test_data/sample1.ioc", filepath.Join(dir, "sample1.ioc"))
+	require.Nil(t, err)
+	err = utils.CopyFile("../../mkf/test_data/sample1.txt", filepath.Join(dir, "Makefile"))
+	require.Nil(t, err)
+	p, err := Create(dir, cfg, CreateOptions{})
+	require.Nil(t, err)
+	return p
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Create(t.TempDir(), &Config{UserConfigDir: filepath.Join(t.TempDir(), "none")}, CreateOptions{})
+	require.True(t, errors.Is(err, ErrNotInitialized))
+
+	cfg := testConfig(t)
+	_, err = Open(t.TempDir(), cfg)
+	require.True(t, errors.Is(err, ErrProjectNotFound))
+	_, err = Create(t.TempDir(), cfg, CreateOptions{})
+	require.True(t, errors.Is(err, ErrNoIocFile))
+	p, err := Create(t.TempDir(), cfg, CreateOptions{AllowNoIoc: true})
+	require.Nil(t, err)
+	_, err = Create(p.Dir, cfg, CreateOptions{})
+	require.True(t, errors.Is(err, ErrProjectExists))
+	_, err = p.Update(context.Background(), UpdateOptions{})
+	require.True(t, errors.Is(err, ErrMakefileNotFound))
+
+	p = testProject(t, cfg)
+	data, err := os.ReadFile(filepath.Join(p.Dir, "Makefile"))
+	require.Nil(t, err)
+	data = regexp.MustCompile(`(?m)^BUILD_DIR = .*$`).ReplaceAll(data, nil)
+	require.Nil(t, os.WriteFile(filepath.Join(p.Dir, "Makefile"), data, 0o644))
+	_, err = p.Update(context.Background(), UpdateOptions{DryRun: true})
+	require.True(t, errors.Is(err, ErrMakefileVariableNotFound))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = testProject(t, cfg)
+	_, err = p.Update(ctx, UpdateOptions{})
+	require.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestUpdate(t *testing.T) {
+	p := testProject(t, testConfig(t))
+	p, err := Open(p.Dir, p.Config)
+	require.Nil(t, err)
+	require.Equal(t, "g421_cube_experim1", *p.Spec.ProjectName)
+
+	c, err := p.Update(context.Background(), UpdateOptions{DryRun: true})
+	require.Nil(t, err)
+	require.True(t, c.Changed())
+	original, err := os.ReadFile(filepath.Join(p.Dir, "Makefile"))
+	require.Nil(t, err)
+	makefile := filepath.Join(p.Dir, "Makefile")
+	found := false
+	for _, f := range c.Files {
+		if f.Path == makefile {
+			require.Equal(t, original, f.Old)
+			found = true
+		}
+	}
+	require.True(t, found)
+
+	_, err = p.Update(context.Background(), UpdateOptions{})
+	require.Nil(t, err)
+	require.FileExists(t, filepath.Join(p.Dir, "_non_persistent", "Makefile.pre-edit"))
+	require.FileExists(t, filepath.Join(p.Dir, "compile_commands.json"))
+	backups, err := backup.List(p.Dir)
+	require.Nil(t, err)
+	require.Len(t, backups, 2)
+	require.Equal(t, backup.KindUpdate, backups[0].Kind)
+	c, err = p.Update(context.Background(), UpdateOptions{DryRun: true})
+	require.Nil(t, err)
+	require.False(t, c.Changed())
+}
+
+func TestUpdateCMake(t *testing.T) {
+	p := testProject(t, testConfig(t))
+	projectFile := filepath.Join(p.Dir, config.ProjectFilePath)
+	data, err := os.ReadFile(projectFile)
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(projectFile, append(data, "\nbuild_system: cmake\n"...), 0o644))
+	require.Nil(t, os.MkdirAll(filepath.Join(p.Dir, "cmake", "stm32cubemx"), 0o755))
+	require.Nil(t, os.WriteFile(filepath.Join(p.Dir, config.CubeMXCMakeToolchainPath), []byte(
+		`set(CMAKE_EXE_LINKER_FLAGS "${CMAKE_EXE_LINKER_FLAGS} -T \"${CMAKE_SOURCE_DIR}/STM32G431CBUx_FLASH.ld\"")`),
+		0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(p.Dir, config.CubeMXCMakeListsPath), []byte(`
+set(MX_Defines_Syms
+	USE_HAL_DRIVER
+	STM32G431xx
+)
+set(MX_Include_Dirs
+    ${CMAKE_SOURCE_DIR}/Core/Inc
+    ${CMAKE_SOURCE_DIR}/Drivers/STM32G4xx_HAL_Driver/Inc
+)
+`), 0o644))
+
+	p, err = Open(p.Dir, p.Config)
+	require.Nil(t, err)
+	_, err = p.Update(context.Background(), UpdateOptions{})
+	require.Nil(t, err)
+	toolchain, err := os.ReadFile(filepath.Join(p.Dir, config.CMakeToolchainPath))
+	require.Nil(t, err)
+	require.Contains(t, string(toolchain), `-T \"${CMAKE_SOURCE_DIR}/STM32G431CBUx_FLASH.ld\"`)
+	properties, err := os.ReadFile(filepath.Join(p.Dir, ".vscode", "c_cpp_properties.json"))
+	require.Nil(t, err)
+	require.Contains(t, string(properties), `"Core/Inc"`)
+	require.Contains(t, string(properties), `"Drivers/STM32G4xx_HAL_Driver/Inc"`)
+	require.Contains(t, string(properties), `"STM32G431xx"`)
+
+	// The linker script in the project root is used without the CubeMX toolchain file
+	require.Nil(t, os.Remove(filepath.Join(p.Dir, config.CubeMXCMakeToolchainPath)))
+	require.Nil(t, os.WriteFile(filepath.Join(p.Dir, "STM32G431CBUx_RAM.ld"), nil, 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(p.Dir, "STM32G431CBUx_FLASH.ld"), nil, 0o644))
+	c, err := p.Update(context.Background(), UpdateOptions{DryRun: true})
+	require.Nil(t, err)
+	require.False(t, c.Changed())
+}
+
+func TestUpdateExclude(t *testing.T) {
+	cfg := testConfig(t)
+	p := testProject(t, cfg)
+	lib := t.TempDir()
+	for _, name := range []string{"a.c", "b.c", "c.c"} {
+		require.Nil(t, os.WriteFile(filepath.Join(lib, name), nil, 0o644))
+	}
+	cfg.Overrides = &config.Overrides{Env: []string{"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_PATH=" + lib}}
+	projectFile := filepath.Join(p.Dir, config.ProjectFilePath)
+	data, err := os.ReadFile(projectFile)
+	require.Nil(t, err)
+	data = regexp.MustCompile(`(?m)^c_src_dirs:$`).ReplaceAll(data, []byte(`c_src_dirs:
+  - path: "{{.MY_LIB}}"
+    exclude:
+      - "{{.MY_LIB}}/b.c"`))
+	data = regexp.MustCompile(`(?m)^exclude:$`).ReplaceAll(data, []byte(`exclude:
+  - "{{.MY_LIB}}/c.c"`))
+	require.Nil(t, os.WriteFile(projectFile, data, 0o644))
+
+	// The exclude patterns expand the external dependency variables
+	p, err = Open(p.Dir, cfg)
+	require.Nil(t, err)
+	_, err = p.Update(context.Background(), UpdateOptions{})
+	require.Nil(t, err)
+	makefile, err := os.ReadFile(filepath.Join(p.Dir, "Makefile"))
+	require.Nil(t, err)
+	require.Contains(t, string(makefile), filepath.Join(lib, "a.c"))
+	require.NotContains(t, string(makefile), filepath.Join(lib, "b.c"))
+	require.NotContains(t, string(makefile), filepath.Join(lib, "c.c"))
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	cfg := testConfig(t)
+	projects := []*Project{testProject(t, cfg), testProject(t, cfg)}
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(projects))
+	for i, p := range projects {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = p.Update(context.Background(), UpdateOptions{})
+		}()
+	}
+	wg.Wait()
+	for i, p := range projects {
+		require.Nil(t, errs[i])
+		require.FileExists(t, filepath.Join(p.Dir, "compile_commands.json"))
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	cfg := testConfig(t)
+	p := testProject(t, cfg)
+	cfg.Overrides = &config.Overrides{
+		Env: []string{
+			"ERGOMCUTOOL_OPENOCD_INTERFACE=jlink.cfg",
+			"ERGOMCUTOOL_INTELLISENSE_SKIP_COMPILE_COMMANDS=true",
+			"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_MY_LIB_PATH=" + p.Dir,
+		},
+		Settings: []string{"openocd.interface=cmsis-dap.cfg"},
+	}
+	p, err := Open(p.Dir, cfg)
+	require.Nil(t, err)
+	require.Equal(t, "cmsis-dap.cfg", *p.Tool.Openocd.Interface)
+	require.True(t, p.Tool.Intellisense.SkipCompileCommands)
+	require.Equal(t, "MY_LIB", p.Spec.ExternalDependencies[0].Var)
+	require.Equal(t, p.Dir, p.Spec.ExternalDependencies[0].Path)
+
+	cfg.Overrides.Settings = []string{"openocd.unknown=1"}
+	_, err = Open(p.Dir, cfg)
+	require.NotNil(t, err)
+	cfg.Overrides = &config.Overrides{Env: []string{}, UserConfigFile: filepath.Join(t.TempDir(), "none.yaml")}
+	_, err = Open(p.Dir, cfg)
+	require.NotNil(t, err)
+	require.False(t, errors.Is(err, ErrNotInitialized))
+
+	// The replaced user configuration doesn't need the user configuration directory
+	cfg = &Config{UserConfigDir: filepath.Join(t.TempDir(), "none"), Overrides: &config.Overrides{
+		Env:            []string{},
+		UserConfigFile: filepath.Join(testConfig(t).UserConfigDir, config.UserConfigFileName),
+	}}
+	dir := t.TempDir()
+	require.Nil(t, utils.CopyFile("../../iocfile/test_data/sample1.ioc", filepath.Join(dir, "sample1.ioc")))
+	require.Nil(t, utils.CopyFile("../../mkf/test_data/sample1.txt", filepath.Join(dir, "Makefile")))
+	p, err = Create(dir, cfg, CreateOptions{})
+	require.Nil(t, err)
+	_, err = p.Update(context.Background(), UpdateOptions{})
+	require.Nil(t, err)
+	require.NoDirExists(t, cfg.UserConfigDir)
+}
+
+func TestGitCacheDir(t *testing.T) {
+	cfg := testConfig(t)
+	p := testProject(t, cfg)
+	cfg.Overrides = &config.Overrides{Env: []string{
+		"ERGOMCUTOOL_EXTERNAL_DEPENDENCIES_LIB_GIT=../lib.git",
+	}}
+	lock := &gitdep.Lock{}
+	lock.Set(gitdep.LockEntry{Var: "LIB", Git: "../lib.git", Commit: "1a2b"})
+	_, err := lock.Write(filepath.Join(p.Dir, config.LockFilePath))
+	require.Nil(t, err)
+
+	// The checkout is in the cache of the user configuration directory,
+	// the local repository is relative to the project root
+	p, err = Open(p.Dir, cfg)
+	require.Nil(t, err)
+	url := filepath.Join(filepath.Dir(p.Dir), "lib.git")
+	require.Equal(t, gitdep.CheckoutDir(gitdep.CacheDir(cfg.UserConfigDir), url, "1a2b"),
+		p.Spec.ExternalDependencies[0].Path)
+}
@@ -0,0 +1,906 @@
+package ergomcu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/backup"
+	"github.com/mcu-art/ergomcutool/cgen"
+	"github.com/mcu-art/ergomcutool/changes"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/deps"
+	"github.com/mcu-art/ergomcutool/glob"
+	"github.com/mcu-art/ergomcutool/intellisense"
+	"github.com/mcu-art/ergomcutool/iocfile"
+	"github.com/mcu-art/ergomcutool/mkf"
+	"github.com/mcu-art/ergomcutool/proj"
+	"github.com/mcu-art/ergomcutool/tpl"
+	"github.com/mcu-art/ergomcutool/utils"
+)
+
+// UpdateOptions are the options of Project.Update.
+type UpdateOptions struct {
+	// Makefile is the path to the Makefile, 'Makefile' in the project root by default.
+	Makefile string
+	// Variant is the build variant used by default,
+	// the default variant of the project if empty.
+	Variant string
+	// DryRun only records the changes without making them.
+	DryRun bool
+}
+
+// updater is the state of a single project update.
+type updater struct {
+	*Project
+	pc  *proj.ErgomcuProjectT
+	c   *changes.Set
+	log *log.Logger
+	// replacements expand the external dependency variables
+	replacements map[string]string
+	// exclude are the exclude patterns of the project
+	// with the external dependency variables expanded
+	exclude []string
+}
+
+func (u *updater) verbosef(format string, v ...any) {
+	if u.Config.Verbose {
+		u.log.Printf(format, v...)
+	}
+}
+
+// Update patches the Makefile (or generates the CMake files),
+// updates the symlinks of the external dependencies, the generated headers,
+// 'compile_commands.json' and the VSCode settings.
+// Returns the changes that were made, or would be made if opts.DryRun is true.
+// The original contents of the changed files are saved into an update snapshot,
+// see backup.Snapshot.
+// Returns ErrMakefileNotFound if the Makefile doesn't exist,
+// ErrMakefileVariableNotFound if it doesn't define 'BUILD_DIR'
+// and *DependencyError if an external dependency can't be used.
+// The project file is re-read, so that Update can be called repeatedly,
+// its warnings are not repeated since Open has reported them.
+func (p *Project) Update(ctx context.Context, opts UpdateOptions) (*changes.Set, error) {
+	pc, err := p.read(log.New(io.Discard, "", 0))
+	if err != nil {
+		return nil, err
+	}
+	u := &updater{
+		Project:      p,
+		pc:           pc,
+		c:            changes.New(opts.DryRun, config.DefaultDirPermissions, config.DefaultFilePermissions),
+		log:          p.Config.logger(),
+		replacements: make(map[string]string, len(pc.ExternalDependencies)),
+	}
+
+	variant, err := pc.Variant(opts.Variant)
+	if err != nil {
+		return nil, err
+	}
+
+	u.log.Printf("Updating project %q...\n", *pc.ProjectName)
+	if variant != nil {
+		u.log.Printf("Selected build variant: %q.\n", variant.Name)
+	}
+	u.verbosef("* Using the following project configuration:\n%s", pc.String())
+
+	// Check if makefile exists
+	makefilePath := opts.Makefile
+	if makefilePath == "" {
+		makefilePath = "Makefile"
+	}
+	makefilePath = p.path(makefilePath)
+	if pc.BuildSystem == proj.BuildSystemMake && !utils.FileExists(makefilePath) {
+		return nil, fmt.Errorf("%w: %q", ErrMakefileNotFound, makefilePath)
+	}
+
+	for _, d := range pc.ExternalDependencies {
+		if d.Git != "" && (d.Commit == "" || !utils.DirExists(d.Path)) {
+			return nil, &DependencyError{Var: d.Var, Err: ErrDependencyNotFetched}
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Save the replaced files even if the update fails halfway
+	defer func() {
+		id, err := backup.Snapshot(p.Dir, u.c, config.UpdateSnapshotsLimit)
+		if err != nil {
+			u.log.Printf("warning: failed to save the snapshot of the replaced files: %v.\n", err)
+		} else if id != "" {
+			u.verbosef("* the replaced files were saved to backup %q.\n", id)
+		}
+	}()
+
+	if err = u.updateSymlinks(); err != nil {
+		return nil, err
+	}
+
+	// Merge the library manifests of the external dependencies
+	libs, err := deps.Resolve(p.Dir, pc.ExternalDependencies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve external dependencies: %w", err)
+	}
+	for _, lib := range libs {
+		if lib.Manifest != nil {
+			u.verbosef("* using library manifest %q.\n", filepath.Join(lib.Dir, deps.ManifestFileName))
+		}
+	}
+	deps.Apply(pc, libs)
+
+	if pc.BuildSystem == proj.BuildSystemCMake {
+		err = u.updateCMakeProject(ctx)
+	} else {
+		err = u.updateMakeProject(ctx, makefilePath, variant)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u.c, nil
+}
+
+// updateSymlinks creates the symlinks to the external dependencies in '_external'.
+func (u *updater) updateSymlinks() error {
+	if len(u.pc.ExternalDependencies) == 0 {
+		return nil
+	}
+	externalDir := u.path("_external")
+	u.log.Printf("Updating symlinks to external dependencies...\n")
+	for _, dep := range u.pc.ExternalDependencies {
+		if !dep.CreateInProjectLink {
+			continue
+		}
+		newLink := filepath.Join(externalDir, dep.LinkName)
+		// Append one relative level to relative symlinks
+		// e.g. ../a becomes ../../a
+		// This is required because symlinks will be created not
+		// at the project root, but inside '_external' directory.
+		target := dep.Path
+		if strings.HasPrefix(target, "..") {
+			target = filepath.Join("..", target)
+		}
+		if _, err := u.c.Symlink(newLink, target); err != nil {
+			return &DependencyError{Var: dep.Var,
+				Err: fmt.Errorf("failed to create or replace symlink %q to %q: %w", newLink, dep.Path, err)}
+		}
+	}
+	u.log.Printf("Done.\n")
+	return nil
+}
+
+// updateMakeProject patches the Makefile with the project values.
+func (u *updater) updateMakeProject(ctx context.Context, makefilePath string, variant *proj.BuildVariantT) error {
+	pc := u.pc
+	// Read the Makefile
+	makefile, err := mkf.FromFile(makefilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read and parse the makefile %q: %w", makefilePath, err)
+	}
+	preEditedMakefilePath := u.path("_non_persistent", "Makefile.pre-edit")
+	moveMakefileToPreEdited := false
+	if makefile.IsAutoEdited() {
+		// Read the original version
+		makefile, err = mkf.FromFile(preEditedMakefilePath)
+		if err != nil {
+			return fmt.Errorf("failed to read and parse %q: %w", preEditedMakefilePath, err)
+		}
+	} else { // Makefile wasn't edited, move it later to _non_persistent/Makefile.pre-edit
+		moveMakefileToPreEdited = true
+	}
+	u.verbosef("* original makefile contains %d lines.\n", len(makefile.Lines))
+	if _, err = makefileBuildDir(makefile); err != nil {
+		return err
+	}
+
+	// Create external dependencies expansion map
+	for _, d := range pc.ExternalDependencies {
+		u.replacements[d.Var] = d.Path
+	}
+	if u.exclude, err = u.expand("exclude", pc.Exclude); err != nil {
+		return err
+	}
+
+	// Merge values from the Makefile with project values
+	// c_src
+	c_src, err := makefile.ReadValue("C_SOURCES")
+	if err != nil {
+		return fmt.Errorf("failed to read C_SOURCES from the makefile: %w", err)
+	}
+	c_src = append(c_src, pc.CSrc...)
+	// Expand external dependencies in each line
+	c_src, err = u.expand("C_SOURCES", c_src)
+	if err != nil {
+		return err
+	}
+	// Resolve glob patterns and CSrcDirs
+	c_src, err = u.resolveSrcFiles(c_src)
+	if err != nil {
+		return err
+	}
+	srcDirFiles, err := u.resolveSrcDirs(pc.CSrcDirs, ".c")
+	if err != nil {
+		return err
+	}
+	c_src = excludePaths(append(c_src, srcDirFiles...), u.exclude)
+	if err = makefile.ReplaceValue("C_SOURCES", c_src); err != nil {
+		return fmt.Errorf("failed to replace C_SOURCES in the makefile: %w", err)
+	}
+
+	// c_includes
+	c_includes, err := makefile.ReadValue("C_INCLUDES")
+	if err != nil {
+		return fmt.Errorf("failed to read C_INCLUDES from the makefile: %w", err)
+	}
+	// Remove -I prefix for each line
+	for i, includeFile := range c_includes {
+		c_includes[i] = strings.TrimLeft(includeFile, "-I")
+	}
+	c_includes = append(c_includes, pc.CIncludeDirs...)
+	if pc.GeneratePinmap {
+		c_includes = append(c_includes, config.GeneratedDir)
+	}
+	// Expand external dependencies in each line
+	if c_includes, err = u.expand("C_INCLUDES", c_includes); err != nil {
+		return err
+	}
+	if c_includes, err = u.resolveIncludeDirs(c_includes); err != nil {
+		return err
+	}
+	c_includes = excludePaths(c_includes, u.exclude)
+	// Add -I prefix to each line
+	if err = makefile.ReplaceValue("C_INCLUDES", prefixAll("-I", c_includes)); err != nil {
+		return fmt.Errorf("failed to replace C_INCLUDES in the makefile: %w", err)
+	}
+
+	// C_DEFS
+	c_defs, err := makefile.ReadValue("C_DEFS")
+	if err != nil {
+		return fmt.Errorf("failed to read C_DEFS from the makefile: %w", err)
+	}
+	// Remove -D prefix for each line
+	for i, defFile := range c_defs {
+		c_defs[i] = strings.TrimLeft(defFile, "-D")
+	}
+	c_defs = append(c_defs, pc.CDefs...)
+	// Expand external dependencies in each line
+	if c_defs, err = u.expand("C_DEFS", c_defs); err != nil {
+		return err
+	}
+	// Add -D prefix to each line
+	if err = makefile.ReplaceValue("C_DEFS", prefixAll("-D", c_defs)); err != nil {
+		return fmt.Errorf("failed to replace C_DEFS in the makefile: %w", err)
+	}
+
+	// Build variant definitions
+	baseDefsCount := len(c_defs)
+	variantDefines, err := u.variantDefines(c_defs)
+	if err != nil {
+		return err
+	}
+	if variant != nil {
+		selectedDefs := variantDefines[variant.Name][baseDefsCount:]
+		if err = makefile.AddVariantCDefs(prefixAll("-D", selectedDefs)); err != nil {
+			return fmt.Errorf("failed to add %s to the makefile: %w", mkf.VariantCDefsVar, err)
+		}
+		c_defs = variantDefines[variant.Name]
+	}
+
+	// Firmware images
+	images, err := u.imageSettings()
+	if err != nil {
+		return err
+	}
+	if len(images) > 0 {
+		mkfImages := make([]mkf.Image, 0, len(images))
+		for _, img := range images {
+			mkfImages = append(mkfImages, mkf.Image{
+				Name:         img.image.Name,
+				Target:       img.image.OutputName,
+				LinkerScript: img.image.LinkerScript,
+				FlashAddress: img.image.FlashAddress(),
+				CSources:     img.cSrc,
+				CIncludes:    prefixAll("-I", img.includes),
+				CDefs:        prefixAll("-D", img.defs),
+			})
+		}
+		if err = makefile.AddImages(mkfImages); err != nil {
+			return fmt.Errorf("failed to add firmware images to the makefile: %w", err)
+		}
+	}
+
+	// asm_src
+	if len(pc.AsmSrc) > 0 {
+		asm_src, err := u.expand("ASM_SOURCES", pc.AsmSrc)
+		if err != nil {
+			return err
+		}
+		if asm_src, err = u.resolveSrcFiles(asm_src); err != nil {
+			return err
+		}
+		asm_src = excludePaths(asm_src, u.exclude)
+		// CubeMX puts preprocessed '.S' files into ASMM_SOURCES if the entry exists
+		asmEntries := map[string][]string{}
+		_, err = makefile.ReadValue("ASMM_SOURCES")
+		asmmSupported := err == nil
+		for _, f := range asm_src {
+			entry := "ASM_SOURCES"
+			if asmmSupported && filepath.Ext(f) == ".S" {
+				entry = "ASMM_SOURCES"
+			}
+			asmEntries[entry] = append(asmEntries[entry], f)
+		}
+		for _, entry := range []string{"ASM_SOURCES", "ASMM_SOURCES"} {
+			if len(asmEntries[entry]) == 0 {
+				continue
+			}
+			values, err := makefile.ReadValue(entry)
+			if err != nil {
+				return fmt.Errorf("failed to read %s from the makefile: %w", entry, err)
+			}
+			values = append(values, asmEntries[entry]...)
+			if err = makefile.ReplaceValue(entry, values); err != nil {
+				return fmt.Errorf("failed to replace %s in the makefile: %w", entry, err)
+			}
+		}
+	}
+
+	// AS_DEFS
+	if len(pc.AsmDefs) > 0 {
+		as_defs, err := makefile.ReadValue("AS_DEFS")
+		if err != nil {
+			return fmt.Errorf("failed to read AS_DEFS from the makefile: %w", err)
+		}
+		asmDefs, err := u.expand("AS_DEFS", pc.AsmDefs)
+		if err != nil {
+			return err
+		}
+		as_defs = append(as_defs, prefixAll("-D", asmDefs)...)
+		if err = makefile.ReplaceValue("AS_DEFS", as_defs); err != nil {
+			return fmt.Errorf("failed to replace AS_DEFS in the makefile: %w", err)
+		}
+	}
+
+	// cpp_src
+	cpp_src, err := u.expand("CPP_SOURCES", pc.CppSrc)
+	if err != nil {
+		return err
+	}
+	if cpp_src, err = u.resolveSrcFiles(cpp_src); err != nil {
+		return err
+	}
+	if srcDirFiles, err = u.resolveSrcDirs(pc.CppSrcDirs, mkf.CppExtensions...); err != nil {
+		return err
+	}
+	cpp_src = excludePaths(append(cpp_src, srcDirFiles...), u.exclude)
+	for _, f := range cpp_src {
+		if !mkf.IsCppSource(f) {
+			return fmt.Errorf("%q in 'cpp_src' is not a C++ source file (supported extensions: %s)",
+				f, strings.Join(mkf.CppExtensions, ", "))
+		}
+	}
+	cppCompiler := ""
+	if u.Tool.General.CppCompilerPath != nil {
+		cppCompiler = *u.Tool.General.CppCompilerPath
+	}
+	if err = makefile.AddCppSupport(cpp_src, pc.CppFlags, cppCompiler); err != nil {
+		return fmt.Errorf("failed to add C++ sources to the makefile: %w", err)
+	}
+
+	// Instantiate and append the 'prog' target,
+	// projects with firmware images get the combined 'prog' target instead
+	if len(images) > 0 {
+		mkfImages := make([]mkf.Image, 0, len(images))
+		for _, img := range images {
+			mkfImages = append(mkfImages, mkf.Image{Name: img.image.Name,
+				Target: img.image.OutputName, FlashAddress: img.image.FlashAddress()})
+		}
+		_ = makefile.AppendTextLines(mkf.ImageTargets(mkfImages,
+			*u.Tool.Openocd.Interface, *pc.Openocd.Target), false)
+	} else {
+		progSnippet, err := u.progSnippet()
+		if err != nil {
+			return err
+		}
+		if progSnippet != "" {
+			_ = makefile.AppendString(progSnippet, false)
+		}
+	}
+
+	// Build variant targets
+	if len(pc.Variants) > 0 {
+		variants := make([]mkf.BuildVariant, 0, len(pc.Variants))
+		for _, v := range pc.Variants {
+			variants = append(variants, mkf.BuildVariant{Name: v.Name,
+				Vars: variantMakeVars(&v, variantDefines[v.Name][baseDefsCount:])})
+		}
+		buildTarget := "all"
+		if len(images) > 0 {
+			buildTarget = "images"
+		}
+		_ = makefile.AppendTextLines(mkf.VariantTargets(variants, buildTarget), false)
+		// The variant build directories are nested, e.g. 'build/debug'
+		if err = makefile.MakeBuildDirParents(); err != nil {
+			return fmt.Errorf("failed to update the build directory rule of the makefile: %w", err)
+		}
+	}
+
+	// Update build options
+	buildOptions := u.Tool.BuildOptions
+	if variant != nil {
+		for name, value := range variantMakeVars(variant, nil) {
+			if name == mkf.VariantCDefsVar {
+				continue
+			}
+			if err = makefile.ReplaceValue(name, []string{value}); err != nil {
+				return fmt.Errorf("failed to replace %s in the makefile: %w", name, err)
+			}
+		}
+		if buildOptions != nil && (buildOptions.BuildDir != nil ||
+			buildOptions.Debug != nil || buildOptions.OptimizationFlags != nil) {
+			u.log.Printf("warning: 'build_options' from ergomcutool_config.yaml are ignored " +
+				"because the project defines build variants.\n")
+		}
+	} else if buildOptions != nil {
+		if buildOptions.BuildDir != nil && *buildOptions.BuildDir != "" {
+			_ = makefile.ReplaceValue("BUILD_DIR", []string{*buildOptions.BuildDir})
+		}
+		if buildOptions.Debug != nil {
+			_ = makefile.ReplaceValue("DEBUG", []string{*buildOptions.Debug})
+		}
+		if buildOptions.OptimizationFlags != nil && *buildOptions.OptimizationFlags != "" {
+			_ = makefile.ReplaceValue("OPT", []string{*buildOptions.OptimizationFlags})
+		}
+	}
+
+	// Insert auto-edited mark
+	_ = makefile.InsertAutoEditedMark()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if moveMakefileToPreEdited {
+		original, err := os.ReadFile(makefilePath)
+		if err == nil {
+			_, err = u.c.WriteFile(preEditedMakefilePath, original)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to move %q to %q: %w", makefilePath, preEditedMakefilePath, err)
+		}
+	}
+
+	u.verbosef("* updated makefile contains %d lines.\n", len(makefile.Lines))
+	if _, err = u.c.WriteFile(makefilePath, makefile.Bytes()); err != nil {
+		return fmt.Errorf("failed to write the updated %q: %w", makefilePath, err)
+	}
+
+	// Update the pin-map header
+	if pc.GeneratePinmap {
+		u.updatePinMap()
+	}
+
+	// compile_commands.json
+	if !u.Tool.Intellisense.SkipCompileCommands {
+		err = u.updateCompileCommands(makefile, c_src, cpp_src, c_includes, c_defs, images)
+		if err != nil {
+			u.log.Printf("warning: failed to update compile_commands.json: %v.\n", err)
+		}
+	}
+
+	// Update intellisense
+	buildDir, err := makefileBuildDir(makefile)
+	if err != nil {
+		return err
+	}
+	u.updateIntellisense(append(c_src, cpp_src...), c_includes, c_defs, buildDir,
+		variantDefines, images)
+	return nil
+}
+
+// makefileBuildDir returns the 'BUILD_DIR' of the makefile,
+// ErrMakefileVariableNotFound if it isn't defined.
+func makefileBuildDir(makefile *mkf.Mkf) (string, error) {
+	buildDir, err := makefile.ReadValue("BUILD_DIR")
+	if err != nil || len(buildDir) == 0 || buildDir[0] == "" {
+		return "", fmt.Errorf("%w: 'BUILD_DIR'", ErrMakefileVariableNotFound)
+	}
+	return buildDir[0], nil
+}
+
+// progSnippet instantiates the 'prog' target snippet,
+// the project snippet takes precedence over the user one.
+func (u *updater) progSnippet() (string, error) {
+	progSnippetLocalDir := u.path(config.LocalErgomcuDir, "snippets")
+	progSnippetFileName := "prog_task.txt.tmpl"
+	replacements := map[string]string{
+		"OpenocdInterface": *u.Tool.Openocd.Interface,
+		"OpenocdTarget":    *u.pc.Openocd.Target,
+	}
+	dir := progSnippetLocalDir
+	// Use the user snippet unless the local one exists
+	if !utils.FileExists(filepath.Join(progSnippetLocalDir, progSnippetFileName)) {
+		assetsDir, cleanup, err := u.Config.assetsDir()
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+		dir = filepath.Join(assetsDir, "snippets")
+	}
+	r, err := tpl.InstantiateToString(dir, progSnippetFileName, replacements)
+	if err != nil {
+		return "", fmt.Errorf("failed to instantiate snippet template %q: %w",
+			filepath.Join(dir, progSnippetFileName), err)
+	}
+	return r, nil
+}
+
+// variantDefines returns the definitions of each build variant:
+// the project definitions followed by the expanded variant definitions.
+func (u *updater) variantDefines(c_defs []string) (map[string][]string, error) {
+	r := make(map[string][]string, len(u.pc.Variants))
+	for _, v := range u.pc.Variants {
+		defs, err := u.expand(fmt.Sprintf("variant %q c_defs", v.Name), v.CDefs)
+		if err != nil {
+			return nil, err
+		}
+		r[v.Name] = append(append([]string{}, c_defs...), defs...)
+	}
+	return r, nil
+}
+
+// imageSettings are the firmware image settings
+// with expanded external dependencies.
+type imageSettings struct {
+	image    *proj.ImageT
+	cSrc     []string
+	includes []string
+	defs     []string
+}
+
+// imageSettings resolves the sources of each firmware image.
+func (u *updater) imageSettings() ([]imageSettings, error) {
+	r := make([]imageSettings, 0, len(u.pc.Images))
+	for i := range u.pc.Images {
+		img := &u.pc.Images[i]
+		cSrc, err := u.expand(fmt.Sprintf("image %q c_src", img.Name), img.CSrc)
+		if err != nil {
+			return nil, err
+		}
+		if cSrc, err = u.resolveSrcFiles(cSrc); err != nil {
+			return nil, err
+		}
+		srcDirFiles, err := u.resolveSrcDirs(img.CSrcDirs, ".c")
+		if err != nil {
+			return nil, err
+		}
+		includes, err := u.expand(fmt.Sprintf("image %q c_include_dirs", img.Name), img.CIncludeDirs)
+		if err != nil {
+			return nil, err
+		}
+		if includes, err = u.resolveIncludeDirs(includes); err != nil {
+			return nil, err
+		}
+		defs, err := u.expand(fmt.Sprintf("image %q c_defs", img.Name), img.CDefs)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, imageSettings{
+			image:    img,
+			cSrc:     excludePaths(append(cSrc, srcDirFiles...), u.exclude),
+			includes: excludePaths(includes, u.exclude),
+			defs:     defs,
+		})
+	}
+	return r, nil
+}
+
+// variantMakeVars returns the Makefile variables of the build variant.
+// defs are the variant definitions without '-D' prefix.
+func variantMakeVars(v *proj.BuildVariantT, defs []string) map[string]string {
+	r := map[string]string{
+		"BUILD_DIR":         v.BuildDir,
+		mkf.VariantCDefsVar: strings.Join(prefixAll("-D", defs), " "),
+	}
+	if v.Debug != nil {
+		r["DEBUG"] = *v.Debug
+	}
+	if v.OptimizationFlags != "" {
+		r["OPT"] = v.OptimizationFlags
+	}
+	if v.LinkerScript != "" {
+		r["LDSCRIPT"] = v.LinkerScript
+	}
+	return r
+}
+
+func prefixAll(prefix string, s []string) []string {
+	r := make([]string, 0, len(s))
+	for _, item := range s {
+		r = append(r, prefix+item)
+	}
+	return r
+}
+
+// updatePinMap re-generates the pin-map header from the project .ioc file.
+func (u *updater) updatePinMap() {
+	iocFiles, err := iocfile.FindIocFiles(u.Dir)
+	if err != nil || len(iocFiles) == 0 {
+		u.log.Printf("warning: no .ioc file found, %q was not updated.\n", config.PinMapHeaderPath)
+		return
+	}
+	ioc, err := iocfile.FromFile(u.path(iocFiles[0]))
+	if err == nil {
+		var parsed *iocfile.ParsedIoc
+		if parsed, err = ioc.Parse(); err == nil {
+			var written bool
+			header := cgen.PinMapHeader(parsed, iocFiles[0])
+			written, err = u.c.WriteFile(u.path(config.PinMapHeaderPath), []byte(header))
+			if written {
+				u.log.Printf("%q was updated.\n", config.PinMapHeaderPath)
+			}
+		}
+	}
+	if err != nil {
+		u.log.Printf("warning: failed to update the pin-map header: %v.\n", err)
+	}
+}
+
+// expand expands the external dependency variables in each value,
+// name is used in the error message.
+func (u *updater) expand(name string, values []string) ([]string, error) {
+	r, err := expandExternalDependencies(values, u.replacements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand external dependencies in %s: %w", name, err)
+	}
+	return r, nil
+}
+
+// resolveSrcFiles replaces the glob patterns with the matching files.
+// Other entries are kept as is.
+func (u *updater) resolveSrcFiles(files []string) ([]string, error) {
+	r := make([]string, 0, len(files))
+	for _, f := range files {
+		if !glob.HasMeta(f) {
+			r = append(r, f)
+			continue
+		}
+		matches, err := glob.Expand(u.Dir, f, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %q: %w", f, err)
+		}
+		if len(matches) == 0 {
+			u.log.Printf("warning: %q doesn't match any file.\n", f)
+		}
+		r = append(r, matches...)
+	}
+	return r, nil
+}
+
+// resolveIncludeDirs replaces the glob patterns with the matching directories.
+// Other entries are kept as is.
+func (u *updater) resolveIncludeDirs(dirs []string) ([]string, error) {
+	r := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if !glob.HasMeta(d) {
+			r = append(r, d)
+			continue
+		}
+		matches, err := glob.Expand(u.Dir, d, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %q: %w", d, err)
+		}
+		if len(matches) == 0 {
+			u.log.Printf("warning: %q doesn't match any directory.\n", d)
+		}
+		r = append(r, matches...)
+	}
+	return r, nil
+}
+
+// resolveSrcDirs returns the files with the specified extensions
+// from each directory, sorted by path within the directory.
+// The directory paths may contain external dependency variables and glob patterns,
+// the exclude patterns may contain external dependency variables.
+func (u *updater) resolveSrcDirs(dirs []proj.SrcDirT, extensions ...string) ([]string, error) {
+	r := make([]string, 0, 50)
+	for _, d := range dirs {
+		expanded, err := u.expand(fmt.Sprintf("%q", d.Path), []string{d.Path})
+		if err != nil {
+			return nil, err
+		}
+		paths, err := u.resolveIncludeDirs(expanded)
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := u.expand(fmt.Sprintf("%q exclude", d.Path), d.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			files, err := glob.Walk(u.Dir, p, d.Recursive, extensions...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read source directory %q: %w", p, err)
+			}
+			r = append(r, excludePaths(files, exclude)...)
+		}
+	}
+	return r, nil
+}
+
+// excludePaths removes the duplicates and the paths
+// that match any of the glob patterns.
+func excludePaths(paths, patterns []string) []string {
+	r := make([]string, 0, len(paths))
+	seen := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		if seen[p] || glob.MatchAny(patterns, p) {
+			continue
+		}
+		seen[p] = true
+		r = append(r, p)
+	}
+	return r
+}
+
+func expandExternalDependencies(s []string, replacements any) ([]string, error) {
+	r := make([]string, 0, len(s))
+	for _, l := range s {
+		expanded, err := tpl.InstantiateFromString(l, replacements)
+		if err != nil {
+			return r, err
+		}
+		r = append(r, expanded)
+	}
+	return r, nil
+}
+
+// updateIntellisense updates the VSCode settings.
+// srcFiles is a list of all source files of the project.
+// variantDefines are the definitions of each build variant.
+// Each firmware image gets its own configurations as well.
+func (u *updater) updateIntellisense(srcFiles, c_includes, c_defs []string, buildDir string,
+	variantDefines map[string][]string, images []imageSettings) {
+	pc, tool := u.pc, u.Tool
+	ccppVariants := make([]intellisense.CCppPropertiesVariant, 0, len(pc.Variants)+len(images))
+	launchVariants := make([]intellisense.LaunchVariant, 0, len(pc.Variants)+len(images))
+	taskNames := make([]string, 0, len(pc.Variants)+len(images))
+	for _, v := range pc.Variants {
+		ccppVariants = append(ccppVariants,
+			intellisense.CCppPropertiesVariant{Name: v.Name, Defines: variantDefines[v.Name]})
+		launchVariants = append(launchVariants, intellisense.LaunchVariant{Name: v.Name,
+			Executable: filepath.Join(v.BuildDir, *pc.ProjectName+".elf")})
+		taskNames = append(taskNames, v.Name)
+	}
+	for _, img := range images {
+		srcFiles = append(srcFiles, img.cSrc...)
+		c_includes = append(c_includes, img.includes...)
+		ccppVariants = append(ccppVariants, intellisense.CCppPropertiesVariant{Name: img.image.Name,
+			Defines: append(append([]string{}, c_defs...), img.defs...)})
+		launchVariants = append(launchVariants, intellisense.LaunchVariant{Name: img.image.Name,
+			Executable: filepath.Join(buildDir, img.image.Name, img.image.OutputName+".elf")})
+		taskNames = append(taskNames, img.image.Name)
+	}
+
+	includePathsPlusSrcDirs := c_includes
+	if !tool.Intellisense.SkipAddingSourceDirectories {
+		includePathsPlusSrcDirs = make([]string, 0, len(c_includes)*2)
+		includePathsPlusSrcDirs = append(includePathsPlusSrcDirs, c_includes...)
+		includePathsPlusSrcDirs = append(includePathsPlusSrcDirs, getSrcDirs(srcFiles, false)...)
+	}
+	warn := func(file string, err error) {
+		if err != nil {
+			u.log.Printf(`warning: failed to update .vscode/%s: %v.
+The intellisense may not work properly.`, file, err)
+		}
+	}
+
+	// c_cpp_properties.json
+	ccppPropertiesReplacements := intellisense.CCppPropertiesReplacements{
+		IncludePath:  includePathsPlusSrcDirs,
+		Defines:      c_defs,
+		CompilerPath: *tool.General.CCompilerPath,
+		Variants:     ccppVariants,
+	}
+	warn("c_cpp_properties.json", intellisense.ProcessCCppPropertiesJson(u.c, u.Dir, ccppPropertiesReplacements))
+
+	// launch.json
+	svdFilePath := tool.Openocd.SvdFilePath
+	if svdFilePath == "" {
+		svdFilePath = pc.Openocd.SvdFilePath
+	}
+	if svdFilePath != "" && !utils.FileExists(u.path(svdFilePath)) {
+		u.log.Printf("warning: specified .svd file doesn't exist: %q\n", svdFilePath)
+	}
+	launchReplacements := intellisense.LaunchReplacements{
+		Executable: filepath.Join(buildDir, *pc.ProjectName+".elf"),
+		ConfigFiles: []string{filepath.Join("interface", *tool.Openocd.Interface),
+			filepath.Join("target", *pc.Openocd.Target)},
+		SvdFile:  svdFilePath,
+		Variants: launchVariants,
+	}
+	warn("launch.json", intellisense.ProcessLaunchJson(u.c, u.Dir, launchReplacements))
+
+	// settings.json
+	settingsReplacements := intellisense.SettingsReplacements{
+		IncludePaths:                includePathsPlusSrcDirs,
+		CCompilerPath:               *tool.General.CCompilerPath,
+		CppCompilerPath:             *tool.General.CppCompilerPath,
+		DebuggerPath:                *tool.General.DebuggerPath,
+		CortexDebugArmToolchainPath: *tool.General.ArmToolchainPath,
+		CortexDebugOpenocdPath:      *tool.Openocd.BinPath,
+		CortexDebugGdbPath:          *tool.General.DebuggerPath,
+	}
+	warn("settings.json", intellisense.ProcessSettingsJson(u.c, u.Dir, settingsReplacements))
+
+	// tasks.json
+	warn("tasks.json", intellisense.ProcessTasksJson(u.c, u.Dir, taskNames))
+}
+
+// updateCompileCommands writes 'compile_commands.json' to the project root.
+// Compiler flags are taken from the updated Makefile,
+// include directories and definitions are the merged project values.
+func (u *updater) updateCompileCommands(makefile *mkf.Mkf,
+	c_src, cpp_src, c_includes, c_defs []string, images []imageSettings) error {
+	ast, err := makefile.AST()
+	if err != nil {
+		return err
+	}
+	// Include directories and definitions are added separately,
+	// image sources are added below with the image settings
+	vars := ast.Variables(map[string]string{"C_DEFS": "", "C_INCLUDES": "", mkf.ImageVar: ""})
+	options := intellisense.CompileCommandsOptions{
+		Directory:   u.Dir,
+		BuildDir:    vars.Value("BUILD_DIR"),
+		CCompiler:   *u.Tool.General.CCompilerPath,
+		CppCompiler: *u.Tool.General.CppCompilerPath,
+		CFlags:      strings.Fields(vars.Value("CFLAGS")),
+		CppFlags:    strings.Fields(vars.Value("CPP_FLAGS")),
+		IncludeDirs: c_includes,
+		Defines:     c_defs,
+		CSources:    c_src,
+		CppSources:  cpp_src,
+	}
+	commands := intellisense.CompileCommands(options)
+	for _, img := range images {
+		imageOptions := options
+		imageOptions.BuildDir = filepath.Join(options.BuildDir, img.image.Name)
+		imageOptions.IncludeDirs = append(append([]string{}, c_includes...), img.includes...)
+		imageOptions.Defines = append(append([]string{}, c_defs...), img.defs...)
+		imageOptions.CSources = img.cSrc
+		imageOptions.CppSources = nil
+		commands = append(commands, intellisense.CompileCommands(imageOptions)...)
+	}
+	written, err := intellisense.WriteCompileCommands(u.c, u.path("compile_commands.json"), commands)
+	if err != nil {
+		return err
+	}
+	if written {
+		u.verbosef("* compile_commands.json was updated (%d entries).\n", len(commands))
+	}
+	return nil
+}
+
+// getSrcDirs creates a list of all unique directories that contain source files
+// in the order of the files, so that the generated files are stable.
+func getSrcDirs(c_src []string, externalOnly bool) []string {
+	r := make([]string, 0, 100)
+	m := make(map[string]bool, 100)
+	for _, file := range c_src {
+		if file == "" {
+			continue
+		}
+		if externalOnly && !strings.HasPrefix(file, "/") && !strings.HasPrefix(file, "../") {
+			continue
+		}
+		fileDir := filepath.Dir(file)
+		if !m[fileDir] {
+			m[fileDir] = true
+			r = append(r, fileDir)
+		}
+	}
+	return r
+}
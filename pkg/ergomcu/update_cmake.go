@@ -0,0 +1,209 @@
+package ergomcu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcu-art/ergomcutool/cmk"
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/mkf"
+	"github.com/mcu-art/ergomcutool/proj"
+)
+
+// updateCMakeProject generates the CMake include and toolchain files
+// instead of patching the Makefile. Only the project values are added to the target,
+// the STM32CubeMX sources are managed by the CubeMX-generated CMake files.
+// The toolchain file replaces the CubeMX one, so it passes the linker script.
+func (u *updater) updateCMakeProject(ctx context.Context) error {
+	pc := u.pc
+	// External dependencies are referenced as CMake variables,
+	// so that they can be overridden in the CMake command line.
+	cmakeExpansionMap := make(map[string]string, len(pc.ExternalDependencies))
+	deps := make([]cmk.ExternalDependency, 0, len(pc.ExternalDependencies))
+	for _, d := range pc.ExternalDependencies {
+		cmakeExpansionMap[d.Var] = "${" + d.Var + "}"
+		u.replacements[d.Var] = d.Path
+		deps = append(deps, cmk.ExternalDependency{Var: d.Var, Path: d.Path})
+	}
+	var err error
+	if u.exclude, err = u.expand("exclude", pc.Exclude); err != nil {
+		return err
+	}
+
+	// Glob patterns and directories are resolved with the real paths
+	c_src, err := u.resolveSources("c_src", pc.CSrc, pc.CSrcDirs, ".c")
+	if err != nil {
+		return err
+	}
+	cpp_src, err := u.resolveSources("cpp_src", pc.CppSrc, pc.CppSrcDirs, mkf.CppExtensions...)
+	if err != nil {
+		return err
+	}
+	asm_src, err := u.resolveSources("asm_src", pc.AsmSrc, nil)
+	if err != nil {
+		return err
+	}
+	c_includes, err := u.expand("c_include_dirs", pc.CIncludeDirs)
+	if err != nil {
+		return err
+	}
+	if c_includes, err = u.resolveIncludeDirs(c_includes); err != nil {
+		return err
+	}
+	if pc.GeneratePinmap {
+		c_includes = append(c_includes, config.GeneratedDir)
+	}
+	c_includes = excludePaths(c_includes, u.exclude)
+
+	cmakeDefs, err := expandExternalDependencies(pc.CDefs, cmakeExpansionMap)
+	if err != nil {
+		return fmt.Errorf("failed to expand external dependencies in c_defs: %w", err)
+	}
+	cmakeAsmDefs, err := expandExternalDependencies(pc.AsmDefs, cmakeExpansionMap)
+	if err != nil {
+		return fmt.Errorf("failed to expand external dependencies in asm_defs: %w", err)
+	}
+	openocdTarget := ""
+	if !pc.Openocd.Disabled {
+		openocdTarget = *pc.Openocd.Target
+	}
+	include := cmk.IncludeFile(cmk.IncludeParams{
+		ExternalDependencies: deps,
+		CSources:             cmakeDependencyPaths(c_src, deps),
+		CppSources:           cmakeDependencyPaths(cpp_src, deps),
+		AsmSources:           cmakeDependencyPaths(asm_src, deps),
+		IncludeDirs:          cmakeDependencyPaths(c_includes, deps),
+		Defines:              cmakeDefs,
+		AsmDefines:           cmakeAsmDefs,
+		CppFlags:             pc.CppFlags,
+		OpenocdInterface:     *u.Tool.Openocd.Interface,
+		OpenocdTarget:        openocdTarget,
+	})
+	if err = u.writeGeneratedFile(config.CMakeIncludePath, include); err != nil {
+		return err
+	}
+
+	targetFlags, ok := cmk.TargetFlags(*pc.DeviceId)
+	if !ok {
+		u.log.Printf("warning: unknown device family %q, specify the -mcpu flags in %q manually.\n",
+			*pc.DeviceId, config.CMakeToolchainPath)
+	}
+	toolchain := cmk.ToolchainFile(cmk.ToolchainParams{
+		ArmToolchainPath: *u.Tool.General.ArmToolchainPath,
+		CCompilerPath:    *u.Tool.General.CCompilerPath,
+		CppCompilerPath:  *u.Tool.General.CppCompilerPath,
+		TargetFlags:      targetFlags,
+		LinkerScript:     u.cmakeLinkerScript(),
+	})
+	if err = u.writeGeneratedFile(config.CMakeToolchainPath, toolchain); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if pc.GeneratePinmap {
+		u.updatePinMap()
+	}
+
+	// Intellisense needs the real paths and the STM32CubeMX values
+	// that are added to the target by the CubeMX CMake files
+	c_defs, err := u.expand("c_defs", pc.CDefs)
+	if err != nil {
+		return err
+	}
+	cubeMXLists := u.readCubeMXFile(config.CubeMXCMakeListsPath)
+	c_defs = append(cmk.CubeMXList(cubeMXLists, "MX_Defines_Syms"), c_defs...)
+	c_includes = append(excludePaths(cmk.CubeMXList(cubeMXLists, "MX_Include_Dirs"), u.exclude), c_includes...)
+	buildDir := "build"
+	if b := u.Tool.BuildOptions; b != nil && b.BuildDir != nil && *b.BuildDir != "" {
+		buildDir = *b.BuildDir
+	}
+	if len(pc.Variants) > 0 || len(pc.Images) > 0 {
+		u.log.Printf("warning: build variants and firmware images are only supported with the %q build system.\n",
+			proj.BuildSystemMake)
+	}
+	u.updateIntellisense(append(c_src, cpp_src...), c_includes, c_defs, buildDir, nil, nil)
+	return nil
+}
+
+// readCubeMXFile returns the contents of the STM32CubeMX-generated CMake file,
+// empty if it doesn't exist.
+func (u *updater) readCubeMXFile(path string) string {
+	data, err := os.ReadFile(u.path(path))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			u.log.Printf("warning: failed to read %q: %v.\n", path, err)
+		}
+		return ""
+	}
+	return string(data)
+}
+
+// cmakeLinkerScript returns the linker script of the project: the one
+// passed by the STM32CubeMX toolchain file or the '*.ld' file in the project root.
+func (u *updater) cmakeLinkerScript() string {
+	if script, ok := cmk.CubeMXLinkerScript(u.readCubeMXFile(config.CubeMXCMakeToolchainPath)); ok {
+		return script
+	}
+	for _, pattern := range []string{"*_FLASH.ld", "*.ld"} {
+		if scripts, _ := filepath.Glob(u.path(pattern)); len(scripts) == 1 {
+			return filepath.Base(scripts[0])
+		}
+	}
+	u.log.Printf("warning: the linker script is not found, add '-T<script>' to the linker flags in %q manually.\n",
+		config.CMakeToolchainPath)
+	return ""
+}
+
+// resolveSources expands the external dependencies and the glob patterns
+// in the source files and appends the files from the source directories.
+func (u *updater) resolveSources(name string, files []string, dirs []proj.SrcDirT,
+	extensions ...string) ([]string, error) {
+	r, err := u.expand(name, files)
+	if err != nil {
+		return nil, err
+	}
+	if r, err = u.resolveSrcFiles(r); err != nil {
+		return nil, err
+	}
+	dirFiles, err := u.resolveSrcDirs(dirs, extensions...)
+	if err != nil {
+		return nil, err
+	}
+	return excludePaths(append(r, dirFiles...), u.exclude), nil
+}
+
+// cmakeDependencyPaths replaces the external dependency paths
+// with the CMake variables, e.g. '../lib/a.c' becomes '${EXAMPLE_LIB}/a.c'.
+func cmakeDependencyPaths(paths []string, deps []cmk.ExternalDependency) []string {
+	r := make([]string, 0, len(paths))
+	for _, p := range paths {
+		cleaned := filepath.Clean(p)
+		for _, d := range deps {
+			depPath := filepath.Clean(d.Path)
+			if cleaned == depPath || strings.HasPrefix(cleaned, depPath+string(filepath.Separator)) {
+				p = "${" + d.Var + "}" + filepath.ToSlash(cleaned[len(depPath):])
+				break
+			}
+		}
+		r = append(r, p)
+	}
+	return r
+}
+
+// writeGeneratedFile writes the project file if its contents have changed.
+func (u *updater) writeGeneratedFile(path, contents string) error {
+	written, err := u.c.WriteFile(u.path(path), []byte(contents))
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	if written {
+		u.log.Printf("%q was updated.\n", filepath.ToSlash(strings.TrimPrefix(path, "./")))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package proj
+
+import (
+	"path/filepath"
+
+	"github.com/mcu-art/ergomcutool/config"
+)
+
+// Migrations are the migration steps of the project file,
+// e.g. config.RenameKeyStep or config.AddSectionStep.
+// The project file schema hasn't changed since 'ergomcutool_version' was added.
+var Migrations = []config.MigrationStep{}
+
+// Migrate migrates the project file of the project to the current version.
+func Migrate(root string) (*config.Migration, error) {
+	return config.MigrateFile(filepath.Join(root, config.ProjectFilePath), config.FileSchemaVersion, Migrations)
+}
@@ -0,0 +1,37 @@
+package proj
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcu-art/ergomcutool/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateProject(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, config.ProjectFilePath)
+	require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	data, err := os.ReadFile("./test_data/ergomcu_project_sample1.yaml")
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(path, data, 0644))
+
+	m, err := Migrate(root)
+	require.Nil(t, err)
+	require.False(t, m.Needed())
+
+	old := []byte("# Comment\nergomcutool_version: 1.0.0\nproject_name: p\n")
+	require.Nil(t, os.WriteFile(path, old, 0644))
+	m, err = Migrate(root)
+	require.Nil(t, err)
+	require.True(t, m.Needed())
+	backup, err := m.Write(filepath.Join(root, config.MigrateBackupsDir))
+	require.Nil(t, err)
+	saved, err := os.ReadFile(backup)
+	require.Nil(t, err)
+	require.Equal(t, old, saved)
+	data, err = os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "# Comment\nergomcutool_version: "+config.FileSchemaVersion+"\nproject_name: p\n", string(data))
+}
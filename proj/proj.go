@@ -5,12 +5,20 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/mcu-art/ergomcutool/config"
+	"github.com/mcu-art/ergomcutool/gitdep"
 	"github.com/mcu-art/ergomcutool/utils"
 	"gopkg.in/yaml.v2"
 )
 
+// Supported build systems
+const (
+	BuildSystemMake  = "make"
+	BuildSystemCMake = "cmake"
+)
+
 type ErgomcuProjectTemplateReplacements struct {
 	ErgomcutoolVersion string
 	ProjectName        string
@@ -25,9 +33,204 @@ type ErgomcuProjectT struct {
 	Openocd              *OpenocdDescriptor           `yaml:"openocd"`
 	ExternalDependencies []config.ExternalDependencyT `yaml:"external_dependencies"`
 	CSrc                 []string                     `yaml:"c_src"`
-	CSrcDirs             []string                     `yaml:"c_src_dirs"`
+	CSrcDirs             []SrcDirT                    `yaml:"c_src_dirs"`
 	CIncludeDirs         []string                     `yaml:"c_include_dirs"`
 	CDefs                []string                     `yaml:"c_defs"`
+	CppSrc               []string                     `yaml:"cpp_src"`
+	CppSrcDirs           []SrcDirT                    `yaml:"cpp_src_dirs"`
+	CppFlags             []string                     `yaml:"cpp_flags"`
+	AsmSrc               []string                     `yaml:"asm_src"`
+	AsmDefs              []string                     `yaml:"asm_defs"`
+	GeneratePinmap       bool                         `yaml:"generate_pinmap"`
+	// Exclude are the glob patterns of the sources and include directories
+	// that are removed from the project, including the Makefile values
+	Exclude []string `yaml:"exclude"`
+	// BuildSystem is either 'make' (default) or 'cmake'
+	BuildSystem string `yaml:"build_system"`
+	// Variants are the named build variants, e.g. 'debug' and 'release'
+	Variants []BuildVariantT `yaml:"variants"`
+	// DefaultVariant is the variant used when none is specified,
+	// the first variant if empty
+	DefaultVariant string `yaml:"default_variant"`
+	// Images are the firmware images built from the project,
+	// e.g. a bootloader and an application
+	Images []ImageT `yaml:"images"`
+}
+
+// SrcDirT is a source directory. It is specified either as a path
+// or as a mapping with 'path', 'recursive' and 'exclude' keys.
+type SrcDirT struct {
+	// Path may be a glob pattern, e.g. 'lib/*/src'
+	Path      string   `yaml:"path"`
+	Recursive bool     `yaml:"recursive"`
+	Exclude   []string `yaml:"exclude"`
+}
+
+func (d *SrcDirT) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&d.Path); err == nil {
+		return nil
+	}
+	type plain SrcDirT
+	if err := unmarshal((*plain)(d)); err != nil {
+		return err
+	}
+	if d.Path == "" {
+		return fmt.Errorf("source directory 'path' is missing")
+	}
+	return nil
+}
+
+func (d SrcDirT) MarshalYAML() (interface{}, error) {
+	if !d.Recursive && len(d.Exclude) == 0 {
+		return d.Path, nil
+	}
+	type plain SrcDirT
+	return plain(d), nil
+}
+
+// FlashBaseAddress is the start address of the STM32 flash memory.
+const FlashBaseAddress = 0x08000000
+
+// ImageT is a firmware image with its own sources and linker script.
+// The project sources are shared by all images.
+type ImageT struct {
+	Name string `yaml:"name"`
+	// OutputName is the TARGET of the image, 'name' by default
+	OutputName string `yaml:"output_name"`
+	// LinkerScript is the Makefile LDSCRIPT by default
+	LinkerScript string `yaml:"linker_script"`
+	// FlashOffset is the image offset from the flash start, e.g. '0x8000'
+	FlashOffset  string    `yaml:"flash_offset"`
+	CSrc         []string  `yaml:"c_src"`
+	CSrcDirs     []SrcDirT `yaml:"c_src_dirs"`
+	CIncludeDirs []string  `yaml:"c_include_dirs"`
+	CDefs        []string  `yaml:"c_defs"`
+}
+
+// FlashAddress returns the address the image is programmed at.
+func (img *ImageT) FlashAddress() uint64 {
+	offset, _ := strconv.ParseUint(img.FlashOffset, 0, 32)
+	return FlashBaseAddress + offset
+}
+
+// BuildVariantT is a named set of build settings.
+type BuildVariantT struct {
+	Name string `yaml:"name"`
+	// BuildDir is 'build/<name>' by default
+	BuildDir          string   `yaml:"build_dir"`
+	Debug             *string  `yaml:"debug"`
+	OptimizationFlags string   `yaml:"optimization_flags"`
+	CDefs             []string `yaml:"c_defs"`
+	LinkerScript      string   `yaml:"linker_script"`
+}
+
+// reservedTargetNames are the Makefile targets that can't be used
+// as variant or image names.
+var reservedTargetNames = map[string]bool{
+	"all":    true,
+	"clean":  true,
+	"prog":   true,
+	"images": true,
+}
+
+// validateTargetName checks that the name can be used as a Makefile target.
+// kind is either 'variant' or 'image'.
+func validateTargetName(kind, name string, index int, names map[string]bool) error {
+	if name == "" {
+		return fmt.Errorf("%s #%d: 'name' is missing", kind, index+1)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%s %q: name may only contain letters, digits, '-' and '_'", kind, name)
+		}
+	}
+	if reservedTargetNames[name] {
+		return fmt.Errorf("%s name %q is reserved", kind, name)
+	}
+	if names[name] {
+		return fmt.Errorf("%s %q: name is already used", kind, name)
+	}
+	names[name] = true
+	return nil
+}
+
+// validateVariants validates the variants and fills in the default values.
+func validateVariants(variants []BuildVariantT, defaultVariant string) error {
+	names := make(map[string]bool, len(variants))
+	for i := range variants {
+		v := &variants[i]
+		if err := validateTargetName("variant", v.Name, i, names); err != nil {
+			return err
+		}
+		if v.Debug != nil && !(*v.Debug == "0" || *v.Debug == "1") {
+			return fmt.Errorf("variant %q: 'debug' must have value '0' or '1'", v.Name)
+		}
+		if v.BuildDir == "" {
+			v.BuildDir = filepath.Join("build", v.Name)
+		}
+	}
+	if defaultVariant != "" && !names[defaultVariant] {
+		return fmt.Errorf("default variant %q is not defined", defaultVariant)
+	}
+	return nil
+}
+
+// validateImages validates the images and fills in the default values.
+// Image names must differ from the variant names.
+func validateImages(images []ImageT, variants []BuildVariantT) error {
+	names := make(map[string]bool, len(images)+len(variants))
+	for _, v := range variants {
+		names[v.Name] = true
+	}
+	offsets := make(map[uint64]string, len(images))
+	for i := range images {
+		img := &images[i]
+		if err := validateTargetName("image", img.Name, i, names); err != nil {
+			return err
+		}
+		if img.OutputName == "" {
+			img.OutputName = img.Name
+		}
+		if img.FlashOffset == "" {
+			img.FlashOffset = "0"
+		}
+		if _, err := strconv.ParseUint(img.FlashOffset, 0, 32); err != nil {
+			return fmt.Errorf("image %q: invalid 'flash_offset' %q", img.Name, img.FlashOffset)
+		}
+		// The Makefile linker script places the image at the flash start
+		if img.FlashAddress() != FlashBaseAddress && img.LinkerScript == "" {
+			return fmt.Errorf("image %q: 'linker_script' is required with a non-zero 'flash_offset'", img.Name)
+		}
+		if other, ok := offsets[img.FlashAddress()]; ok {
+			return fmt.Errorf("images %q and %q have the same 'flash_offset'", other, img.Name)
+		}
+		offsets[img.FlashAddress()] = img.Name
+	}
+	return nil
+}
+
+// Variant returns the variant with the specified name.
+// If the name is empty, the default variant is returned.
+// Returns nil if the project has no variants.
+func (p *ErgomcuProjectT) Variant(name string) (*BuildVariantT, error) {
+	if len(p.Variants) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("variant %q is not defined, the project has no variants", name)
+		}
+		return nil, nil
+	}
+	if name == "" {
+		name = p.DefaultVariant
+	}
+	if name == "" {
+		return &p.Variants[0], nil
+	}
+	for i := range p.Variants {
+		if p.Variants[i].Name == name {
+			return &p.Variants[i], nil
+		}
+	}
+	return nil, fmt.Errorf("variant %q is not defined", name)
 }
 
 func (p *ErgomcuProjectT) String() string {
@@ -41,7 +244,9 @@ type OpenocdDescriptor struct {
 	SvdFilePath string  `yaml:"svd_file_path"`
 }
 
-func (g *OpenocdDescriptor) Validate() error {
+// Validate validates the openocd settings against the tool configuration,
+// warnings are printed to the logger.
+func (g *OpenocdDescriptor) Validate(cfg *config.ToolConfigT, logger *log.Logger) error {
 	if g.Disabled {
 		return nil
 	}
@@ -51,23 +256,69 @@ func (g *OpenocdDescriptor) Validate() error {
 		return fmt.Errorf("openocd target parameter is missing in project configuration file")
 	}
 
-	if config.ToolConfig.Openocd == nil {
-		log.Printf("warning: config.ToolConfig.Openocd is nil (configuration not read?)")
+	if cfg.Openocd == nil || cfg.Openocd.ScriptsPath == nil {
+		logger.Printf("warning: config.ToolConfig.Openocd is nil (configuration not read?)")
 		return nil
 	}
 
-	targetFile := filepath.Join(*config.ToolConfig.Openocd.ScriptsPath, "target",
+	targetFile := filepath.Join(*cfg.Openocd.ScriptsPath, "target",
 		*g.Target)
 
 	exists := utils.FileExists(targetFile)
 	if !exists {
-		log.Printf("%s openocd:'target' %q doesn't exist.\n",
+		logger.Printf("%s openocd:'target' %q doesn't exist.\n",
 			warningPrefix, targetFile)
 	}
 	return nil
 }
 
+// ReadExternalDependencies reads the external dependencies
+// declared in the project file, without the configuration ones.
+func ReadExternalDependencies(path string) ([]config.ExternalDependencyT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &struct {
+		ExternalDependencies []config.ExternalDependencyT `yaml:"external_dependencies"`
+	}{}
+	err = yaml.Unmarshal(data, r)
+	return r.ExternalDependencies, err
+}
+
+// ValidationError is returned if the project file is invalid.
+type ValidationError struct {
+	Path string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%q: project validation failed: %v", e.Path, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ReadAndValidate reads the project file merged with config.ToolConfig,
+// the git dependencies are checked out in the cache of config.UserConfigDir.
+// The project root is the parent of the directory of the project file.
+// Returns *ValidationError if the project is invalid.
 func ReadAndValidate(path string) (*ErgomcuProjectT, error) {
+	return read(path, filepath.Dir(filepath.Dir(path)), gitdep.CacheDir(config.UserConfigDir),
+		config.ToolConfig, log.Default())
+}
+
+// Read reads the project file of the project in the root directory,
+// the external dependencies are merged with the ones of the configuration.
+// The git dependencies are checked out in gitCacheDir, see gitdep.CacheDir.
+// Returns *ValidationError if the project is invalid.
+// Warnings are printed to the logger.
+func Read(root, gitCacheDir string, cfg *config.ToolConfigT, logger *log.Logger) (*ErgomcuProjectT, error) {
+	return read(filepath.Join(root, config.ProjectFilePath), root, gitCacheDir, cfg, logger)
+}
+
+func read(path, root, gitCacheDir string, cfg *config.ToolConfigT, logger *log.Logger) (*ErgomcuProjectT, error) {
 	r := &ErgomcuProjectT{}
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -80,51 +331,91 @@ func ReadAndValidate(path string) (*ErgomcuProjectT, error) {
 	}
 
 	// Validate
-	msgPrefix := "error: ergomcutool project validation failed:"
-	msgSuffix := fmt.Sprintf("Fix errors in %q and try again.", path)
+	invalid := func(format string, a ...any) error {
+		return &ValidationError{Path: path, Err: fmt.Errorf(format, a...)}
+	}
 	if r.ErgomcutoolVersion == nil || *r.ErgomcutoolVersion == "" {
-		log.Fatalf("%s 'ergomcutool_version' is missing.\n%s\n", msgPrefix, msgSuffix)
+		return r, invalid("'ergomcutool_version' is missing")
+	}
+	if err = config.CheckVersion(path, data, logger); err != nil {
+		return r, err
 	}
 
 	if r.ProjectName == nil || *r.ProjectName == "" {
-		log.Fatalf("%s 'project_name' is missing.\n%s\n", msgPrefix, msgSuffix)
+		return r, invalid("'project_name' is missing")
 	}
 
 	if r.DeviceId == nil || *r.DeviceId == "" {
-		log.Fatalf("%s 'device_id' is missing.\n%s\n", msgPrefix, msgSuffix)
+		return r, invalid("'device_id' is missing")
+	}
+
+	switch r.BuildSystem {
+	case "":
+		r.BuildSystem = BuildSystemMake
+	case BuildSystemMake, BuildSystemCMake:
+	default:
+		return r, invalid("'build_system' must be either %q or %q", BuildSystemMake, BuildSystemCMake)
+	}
+
+	if err = validateVariants(r.Variants, r.DefaultVariant); err != nil {
+		return r, invalid("%w", err)
+	}
+	if err = validateImages(r.Images, r.Variants); err != nil {
+		return r, invalid("%w", err)
 	}
 
 	if r.Openocd == nil {
-		log.Fatalf("%s 'openocd' section is missing.\n%s\n", msgPrefix, msgSuffix)
+		return r, invalid("'openocd' section is missing")
+	}
+	if err = r.Openocd.Validate(cfg, logger); err != nil {
+		return r, invalid("%w", err)
 	}
-	if err = r.Openocd.Validate(); err != nil {
-		log.Fatalf("%s %v.\n%s\n",
-			msgPrefix, err, msgSuffix)
+
+	for _, d := range r.ExternalDependencies {
+		if d.Git != "" && d.Path != "" {
+			return r, invalid("external dependency %q: 'path' and 'git' must not be specified together", d.Var)
+		}
 	}
 
 	// Merge ExternalDependencies:
-	r.ExternalDependencies = mergeExternalDeps(r.ExternalDependencies)
+	r.ExternalDependencies = mergeExternalDeps(r.ExternalDependencies, cfg.ExternalDependencies)
+
+	// Git dependencies are checked out at the locked commits,
+	// the lockfile is next to the project file
+	lock, err := gitdep.ReadLock(filepath.Join(filepath.Dir(path), filepath.Base(config.LockFilePath)))
+	if err != nil {
+		return r, fmt.Errorf("failed to read the lockfile: %w", err)
+	}
+	for i := range r.ExternalDependencies {
+		d := &r.ExternalDependencies[i]
+		if d.Git == "" {
+			continue
+		}
+		if e := lock.Find(d.Var); e != nil && e.Git == d.Git && e.Ref == d.Ref {
+			d.Commit = e.Commit
+			d.Path = gitdep.CheckoutDir(gitCacheDir, gitdep.ResolveURL(root, d.Git), e.Commit)
+		}
+	}
 
 	// Validate merged dependencies
 	for _, d := range r.ExternalDependencies {
-		if err = d.Validate(); err != nil {
-			log.Fatalf("%s %v.\n%s\n",
-				msgPrefix, err, msgSuffix)
+		if err = d.Validate(root, logger); err != nil {
+			return r, invalid("%w", err)
 		}
 	}
 
 	return r, nil
 }
 
-func mergeExternalDeps(projectExternalDeps []config.ExternalDependencyT) []config.ExternalDependencyT {
+func mergeExternalDeps(projectExternalDeps, configExternalDeps []config.ExternalDependencyT) []config.ExternalDependencyT {
 	r := make([]config.ExternalDependencyT, 0,
-		len(projectExternalDeps)+len(config.ToolConfig.ExternalDependencies))
+		len(projectExternalDeps)+len(configExternalDeps))
 
 	// For each project dependency,
 	// check if there is same dependency in the config
 	commonDependencies := make(map[string]config.ExternalDependencyT, len(projectExternalDeps))
 	for _, d := range projectExternalDeps {
-		for _, other := range config.ToolConfig.ExternalDependencies {
+		for _, other := range configExternalDeps {
 			if d.Var == other.Var {
 				d.MergeSpecial(&other)
 				commonDependencies[d.Var] = d
@@ -139,7 +430,7 @@ func mergeExternalDeps(projectExternalDeps []config.ExternalDependencyT) []confi
 			r = append(r, d)
 		}
 	}
-	for _, d := range config.ToolConfig.ExternalDependencies {
+	for _, d := range configExternalDeps {
 		_, ok := commonDependencies[d.Var]
 		if !ok {
 			r = append(r, d)
@@ -1,9 +1,17 @@
 package proj
 
 import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/mcu-art/ergomcutool/config"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestReadAndValidate(t *testing.T) {
@@ -11,4 +19,116 @@ func TestReadAndValidate(t *testing.T) {
 	m, err := ReadAndValidate(sample1Path)
 	require.Nil(t, err)
 	require.NotNil(t, m)
+
+	// The version is checked against the version of ergomcutool
+	data, err := os.ReadFile(sample1Path)
+	require.Nil(t, err)
+	root := t.TempDir()
+	path := filepath.Join(root, config.ProjectFilePath)
+	require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	for _, tc := range []struct {
+		version string
+		warning bool
+		newer   bool
+	}{
+		{config.FileSchemaVersion, false, false},
+		{"1.0.0", true, false},
+		{"99.0.0", false, true},
+	} {
+		newData := strings.Replace(string(data), "ergomcutool_version: "+config.FileSchemaVersion,
+			"ergomcutool_version: "+tc.version, 1)
+		require.Nil(t, os.WriteFile(path, []byte(newData), 0644))
+		buf := bytes.Buffer{}
+		_, err = Read(root, t.TempDir(), config.ToolConfig, log.New(&buf, "", 0))
+		require.Equal(t, tc.newer, errors.Is(err, config.ErrNewerVersion), tc.version)
+		if !tc.newer {
+			require.Nil(t, err, tc.version)
+		}
+		require.Equal(t, tc.warning, strings.Contains(buf.String(), "run 'ergomcutool migrate'"), tc.version)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	sample1Path := "./test_data/ergomcu_project_sample1.yaml"
+	m, err := ReadAndValidate(sample1Path)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(m.Variants))
+	require.Equal(t, "build/debug", m.Variants[0].BuildDir)
+
+	v, err := m.Variant("")
+	require.Nil(t, err)
+	require.Equal(t, "debug", v.Name)
+	v, err = m.Variant("release")
+	require.Nil(t, err)
+	require.Equal(t, "build/rel", v.BuildDir)
+	require.Equal(t, []string{"NDEBUG"}, v.CDefs)
+	_, err = m.Variant("unknown")
+	require.NotNil(t, err)
+
+	m.DefaultVariant = "release"
+	v, err = m.Variant("")
+	require.Nil(t, err)
+	require.Equal(t, "release", v.Name)
+
+	one := "1"
+	bad := "2"
+	require.NotNil(t, validateVariants([]BuildVariantT{{Name: "a"}, {Name: "a"}}, ""))
+	require.NotNil(t, validateVariants([]BuildVariantT{{Name: "clean"}}, ""))
+	require.NotNil(t, validateVariants([]BuildVariantT{{Name: "a b"}}, ""))
+	require.NotNil(t, validateVariants([]BuildVariantT{{Name: "a", Debug: &bad}}, ""))
+	require.NotNil(t, validateVariants([]BuildVariantT{{Name: "a"}}, "b"))
+	require.Nil(t, validateVariants([]BuildVariantT{{Name: "release-lto", Debug: &one}}, "release-lto"))
+
+	empty := &ErgomcuProjectT{}
+	v, err = empty.Variant("")
+	require.Nil(t, err)
+	require.Nil(t, v)
+	_, err = empty.Variant("debug")
+	require.NotNil(t, err)
+}
+
+func TestImages(t *testing.T) {
+	sample1Path := "./test_data/ergomcu_project_sample1.yaml"
+	m, err := ReadAndValidate(sample1Path)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(m.Images))
+	require.Equal(t, "boot", m.Images[0].OutputName)
+	require.Equal(t, uint64(0x08000000), m.Images[0].FlashAddress())
+	require.Equal(t, "app", m.Images[1].OutputName)
+	require.Equal(t, uint64(0x08008000), m.Images[1].FlashAddress())
+	require.Equal(t, []string{"BOOTLOADER"}, m.Images[0].CDefs)
+
+	require.NotNil(t, validateImages([]ImageT{{Name: "a"}, {Name: "a", FlashOffset: "0x100"}}, nil))
+	require.NotNil(t, validateImages([]ImageT{{Name: "images"}}, nil))
+	require.NotNil(t, validateImages([]ImageT{{Name: "a", FlashOffset: "abc"}}, nil))
+	require.NotNil(t, validateImages([]ImageT{{Name: "a"}, {Name: "b"}}, nil))
+	require.NotNil(t, validateImages([]ImageT{{Name: "release"}},
+		[]BuildVariantT{{Name: "release"}}))
+	require.NotNil(t, validateImages([]ImageT{{Name: "a"}, {Name: "b", FlashOffset: "0x8000"}}, nil))
+	require.Nil(t, validateImages([]ImageT{{Name: "a"}, {Name: "b", FlashOffset: "32768", LinkerScript: "b.ld"}}, nil))
+}
+
+func TestSrcDirs(t *testing.T) {
+	p := &ErgomcuProjectT{}
+	err := yaml.Unmarshal([]byte(`
+c_src_dirs:
+  - src
+  - path: lib
+    recursive: true
+    exclude:
+      - lib/test/**
+exclude:
+  - "**/*_test.c"
+`), p)
+	require.Nil(t, err)
+	require.Equal(t, []SrcDirT{{Path: "src"},
+		{Path: "lib", Recursive: true, Exclude: []string{"lib/test/**"}}}, p.CSrcDirs)
+	require.Equal(t, []string{"**/*_test.c"}, p.Exclude)
+
+	data, err := yaml.Marshal(p.CSrcDirs)
+	require.Nil(t, err)
+	require.Equal(t, "- src\n- path: lib\n  recursive: true\n  exclude:\n  - lib/test/**\n", string(data))
+
+	err = yaml.Unmarshal([]byte("c_src_dirs:\n  - recursive: true\n"), p)
+	require.NotNil(t, err)
 }
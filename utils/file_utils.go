@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
@@ -9,7 +10,9 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 )
@@ -353,3 +356,96 @@ func ReadTextFileEx(path string) ([]string, string, error) {
 	}
 	return r, lineEnding, nil
 }
+
+// WriteFileIfChanged writes data to the file only if the file doesn't exist
+// or its contents differ, so that the file modification time doesn't
+// trigger unnecessary rebuilds. Missing parent directories are created.
+// Returns true if the file was written.
+func WriteFileIfChanged(path string, data []byte, dirPerm, filePerm uint32) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(path), fs.FileMode(dirPerm)); err != nil {
+		return false, err
+	}
+	if err = os.WriteFile(path, data, fs.FileMode(filePerm)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// BackupFile copies (or moves, if 'move' is true) the file into 'backupDir'
+// under the name '<N>-<baseName>-<date>', where N is incremented with each backup.
+// If the number of backups exceeds 'limit', the oldest one is removed.
+// Returns the path to the created backup.
+func BackupFile(path, backupDir, baseName string, limit int, move bool, dirPerm uint32) (string, error) {
+	err := os.MkdirAll(backupDir, fs.FileMode(dirPerm))
+	if err != nil {
+		return "", fmt.Errorf("(BackupFile) failed to create directory %q: %w", backupDir, err)
+	}
+	// Get a list of backup files.
+	buFiles, err := GetFileList(backupDir)
+	if err != nil {
+		return "", fmt.Errorf("(BackupFile) failed get file list of %q: %w", backupDir, err)
+	}
+
+	// Get the first and the last file prefix
+	var minFilePrefix uint64 = 0xFFFFFFFF
+	var maxFilePrefix uint64 = 0
+	numBackups := 0
+	for _, file := range buFiles {
+		split := strings.SplitN(file, "-", 2)
+		if len(split) < 2 || !strings.HasPrefix(split[1], baseName+"-") {
+			continue
+		}
+		prefixNum, err := strconv.ParseUint(split[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		numBackups++
+		if prefixNum > maxFilePrefix {
+			maxFilePrefix = prefixNum
+		}
+		if prefixNum < minFilePrefix {
+			minFilePrefix = prefixNum
+		}
+	}
+
+	// Generate file name for new backup
+	date := time.Now().Format("2006_01_02")
+	fileName := fmt.Sprintf("%d-%s-%s", maxFilePrefix+1, baseName, date)
+	dest := filepath.Join(backupDir, fileName)
+	if move {
+		err = os.Rename(path, dest)
+	} else {
+		err = CopyFile(path, dest)
+	}
+	if err != nil {
+		return "", fmt.Errorf(
+			"(BackupFile) failed to back up file %q to %q: %w", path, dest, err)
+	}
+
+	// Remove old backups if number of backup files exceeds limit
+	if numBackups+1 > limit {
+		leastPrefix := fmt.Sprintf("%d-%s-", minFilePrefix, baseName)
+		fileName = ""
+		for _, file := range buFiles {
+			if strings.HasPrefix(file, leastPrefix) {
+				fileName = file
+				break
+			}
+		}
+		if fileName == "" {
+			return dest, fmt.Errorf(
+				"(BackupFile) failed to find file with prefix %q", leastPrefix)
+		}
+		oldest := filepath.Join(backupDir, fileName)
+		err = os.Remove(oldest)
+		if err != nil {
+			return dest, fmt.Errorf(
+				"(BackupFile) failed remove file %q: %w", oldest, err)
+		}
+	}
+	return dest, nil
+}